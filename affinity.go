@@ -0,0 +1,72 @@
+package asyncigo
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+)
+
+// SetAffinityChecks enables or disables loop-affinity checks, intended for debugging.
+// While enabled, the loop keeps track of which goroutine is currently running on its behalf,
+// and [AssertOnLoop], [Future.Await] and [Future.SetResult] panic with an [*AffinityError]
+// if called from any other goroutine, e.g. from a goroutine started using the go statement
+// instead of going through [EventLoop.RunCallbackThreadsafe].
+//
+// Only futures that have been awaited while checks were enabled are checked in [Future.SetResult].
+// Tracking the running goroutine adds noticeable overhead to every context switch.
+func (e *EventLoop) SetAffinityChecks(enabled bool) {
+	e.affinityChecks.Store(enabled)
+	if enabled {
+		e.enterGoroutine()
+	} else {
+		e.goroutine.Store(0)
+	}
+}
+
+// enterGoroutine records that the calling goroutine is now running on behalf of the loop,
+// if affinity checks are enabled.
+func (e *EventLoop) enterGoroutine() {
+	if e.affinityChecks.Load() {
+		e.goroutine.Store(goroutineID())
+	}
+}
+
+// checkAffinity panics if affinity checks are enabled and the calling goroutine
+// isn't running on behalf of the loop.
+func (e *EventLoop) checkAffinity(op string) {
+	if !e.affinityChecks.Load() {
+		return
+	}
+	if id := goroutineID(); id != e.goroutine.Load() {
+		panic(&AffinityError{Op: op, Goroutine: id, Stack: debug.Stack()})
+	}
+}
+
+// AssertOnLoop panics with an [*AffinityError] if ctx doesn't belong to a running [EventLoop].
+// If affinity checks have been enabled using [EventLoop.SetAffinityChecks],
+// it also panics if the calling goroutine isn't the one currently running the loop.
+//
+// Place calls to AssertOnLoop in code that manipulates loop state, such as futures and queues,
+// to catch accidental calls from other goroutines early.
+func AssertOnLoop(ctx context.Context) {
+	loop, ok := RunningLoopMaybe(ctx)
+	if !ok {
+		panic(&AffinityError{Op: "AssertOnLoop", Goroutine: goroutineID(), Stack: debug.Stack()})
+	}
+	loop.checkAffinity("AssertOnLoop")
+}
+
+// goroutineID returns the ID of the calling goroutine, as reported in stack traces.
+func goroutineID() uint64 {
+	var buf [64]byte
+	stack := buf[:runtime.Stack(buf[:], false)]
+	// the stack trace starts with "goroutine <id> [<status>]:"
+	stack = bytes.TrimPrefix(stack, []byte("goroutine "))
+	if i := bytes.IndexByte(stack, ' '); i >= 0 {
+		stack = stack[:i]
+	}
+	id, _ := strconv.ParseUint(string(stack), 10, 64)
+	return id
+}
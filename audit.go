@@ -0,0 +1,95 @@
+package asyncigo
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// UnboundedWait describes a location that awaited with a context that had no deadline.
+// See [EventLoop.SetWaitAudit].
+type UnboundedWait struct {
+	// Location is the place the wait was started from, as "file:line (function)",
+	// skipping any frames inside this package such as [Sleep] or [Awaitable.Await] itself.
+	Location string
+	// Count is the number of unbounded waits performed from Location.
+	Count int
+	// Detached is set if any of the waits used a context that can never be cancelled,
+	// e.g. one derived using [context.WithoutCancel], so the wait isn't even interrupted
+	// once the main task exits.
+	Detached bool
+}
+
+// SetWaitAudit enables or disables auditing of unbounded waits, intended for debugging.
+// While enabled, every await performed by a task with a context that has no deadline is recorded
+// by the location it was started from. Recorded waits are listed by [EventLoop.UnboundedWaits]
+// and [EventLoop.DumpState], helping enforce the use of timeouts in large codebases.
+//
+// Determining the location of each wait adds noticeable overhead to every await.
+// Disabling the audit discards all recorded waits.
+func (e *EventLoop) SetWaitAudit(enabled bool) {
+	if !enabled {
+		e.unboundedWaits = nil
+	} else if e.unboundedWaits == nil {
+		e.unboundedWaits = make(map[string]*UnboundedWait)
+	}
+}
+
+// UnboundedWaits returns the unbounded waits recorded since [EventLoop.SetWaitAudit] was enabled,
+// ordered by location.
+//
+// UnboundedWaits is not threadsafe, and must be called from the event loop.
+func (e *EventLoop) UnboundedWaits() []UnboundedWait {
+	waits := make([]UnboundedWait, 0, len(e.unboundedWaits))
+	for _, wait := range e.unboundedWaits {
+		waits = append(waits, *wait)
+	}
+	slices.SortFunc(waits, func(a, b UnboundedWait) int {
+		return cmp.Compare(a.Location, b.Location)
+	})
+	return waits
+}
+
+// auditWait records the wait for fut if the wait audit is enabled and ctx has no deadline.
+func (e *EventLoop) auditWait(ctx context.Context, fut Futurer) {
+	if e.unboundedWaits == nil || fut == nil || fut.HasResult() {
+		return
+	} else if _, ok := ctx.Deadline(); ok {
+		return
+	}
+
+	location := waitLocation()
+	wait := e.unboundedWaits[location]
+	if wait == nil {
+		wait = &UnboundedWait{Location: location}
+		e.unboundedWaits[location] = wait
+	}
+	wait.Count++
+	if ctx.Done() == nil {
+		wait.Detached = true
+	}
+}
+
+// packageDir is the directory holding the source files of this package.
+var packageDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// waitLocation returns the first caller outside of this package's non-test source files.
+func waitLocation() string {
+	var pcs [32]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs[:])])
+	for {
+		frame, more := frames.Next()
+		if filepath.Dir(frame.File) != packageDir || strings.HasSuffix(frame.File, "_test.go") {
+			return fmt.Sprintf("%s:%d (%s)", frame.File, frame.Line, frame.Function)
+		} else if !more {
+			return "unknown"
+		}
+	}
+}
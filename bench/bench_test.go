@@ -0,0 +1,222 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arvidfm/asyncigo"
+)
+
+// runLoop runs main as the main task of a fresh event loop,
+// skipping the benchmark if the poller doesn't support the operations used.
+func runLoop(b *testing.B, main func(ctx context.Context, loop *asyncigo.EventLoop) error) {
+	b.Helper()
+	loop := asyncigo.NewEventLoop()
+	err := loop.Run(context.Background(), func(ctx context.Context) error {
+		return main(ctx, loop)
+	})
+	if errors.Is(err, asyncigo.ErrNotImplemented) {
+		b.Skipf("function not supported on this platform")
+	} else if err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkEcho(b *testing.B) {
+	for _, size := range []int{64, 4096, 65536} {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			runLoop(b, func(ctx context.Context, loop *asyncigo.EventLoop) error {
+				listener, err := loop.Listen(ctx, "tcp", "127.0.0.1:0")
+				if err != nil {
+					return err
+				}
+				defer listener.Close()
+				server := asyncigo.SpawnTask(ctx, func(ctx context.Context) (any, error) {
+					return nil, listener.Serve(ctx, func(ctx context.Context, stream *asyncigo.AsyncStream, remote net.Addr) error {
+						for chunk, err := range stream.Stream(ctx, size) {
+							if err != nil {
+								return err
+							}
+							if _, err := stream.Write(ctx, chunk).Await(ctx); err != nil {
+								return err
+							}
+						}
+						return nil
+					})
+				})
+				defer server.Cancel(nil)
+
+				client, err := loop.Dial(ctx, "tcp", listener.Addr().String())
+				if err != nil {
+					return err
+				}
+				defer client.Close()
+
+				payload := bytes.Repeat([]byte{'x'}, size)
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for range b.N {
+					if _, err := client.Write(ctx, payload).Await(ctx); err != nil {
+						return err
+					}
+					if _, err := client.ReadChunk(ctx, size); err != nil {
+						return err
+					}
+				}
+				b.StopTimer()
+				return nil
+			})
+		})
+	}
+}
+
+func BenchmarkTimerChurn(b *testing.B) {
+	b.Run("cancel", func(b *testing.B) {
+		runLoop(b, func(ctx context.Context, loop *asyncigo.EventLoop) error {
+			// keep a realistic number of timers pending, e.g. one idle timeout per connection
+			for i := range 1000 {
+				defer loop.ScheduleCallback(time.Hour+time.Duration(i)*time.Millisecond, func() {}).Cancel()
+			}
+
+			b.ResetTimer()
+			for i := range b.N {
+				loop.ScheduleCallback(time.Duration(i%1000)*time.Millisecond, func() {}).Cancel()
+			}
+			b.StopTimer()
+			return nil
+		})
+	})
+	b.Run("fire", func(b *testing.B) {
+		runLoop(b, func(ctx context.Context, loop *asyncigo.EventLoop) error {
+			b.ResetTimer()
+			for range b.N {
+				if err := asyncigo.Sleep(ctx, 0); err != nil {
+					return err
+				}
+			}
+			b.StopTimer()
+			return nil
+		})
+	})
+}
+
+func BenchmarkSpawnTask(b *testing.B) {
+	noop := func(ctx context.Context) (int, error) {
+		return 1, nil
+	}
+
+	b.Run("sequential", func(b *testing.B) {
+		runLoop(b, func(ctx context.Context, loop *asyncigo.EventLoop) error {
+			b.ResetTimer()
+			for range b.N {
+				if _, err := asyncigo.SpawnTask(ctx, noop).Await(ctx); err != nil {
+					return err
+				}
+			}
+			b.StopTimer()
+			return nil
+		})
+	})
+	b.Run("concurrent", func(b *testing.B) {
+		runLoop(b, func(ctx context.Context, loop *asyncigo.EventLoop) error {
+			tasks := make([]asyncigo.Futurer, 0, 100)
+			b.ResetTimer()
+			for i := 0; i < b.N; i += cap(tasks) {
+				tasks = tasks[:0]
+				for range min(cap(tasks), b.N-i) {
+					tasks = append(tasks, asyncigo.SpawnTask(ctx, noop))
+				}
+				if err := asyncigo.Wait(ctx, asyncigo.WaitAll, tasks...); err != nil {
+					return err
+				}
+			}
+			b.StopTimer()
+			return nil
+		})
+	})
+}
+
+func BenchmarkFutureAwait(b *testing.B) {
+	b.Run("completed", func(b *testing.B) {
+		runLoop(b, func(ctx context.Context, loop *asyncigo.EventLoop) error {
+			fut := asyncigo.NewFuture[int]()
+			fut.SetResult(1, nil)
+			b.ResetTimer()
+			for range b.N {
+				if _, err := fut.Await(ctx); err != nil {
+					return err
+				}
+			}
+			b.StopTimer()
+			return nil
+		})
+	})
+	b.Run("pending", func(b *testing.B) {
+		runLoop(b, func(ctx context.Context, loop *asyncigo.EventLoop) error {
+			b.ResetTimer()
+			for range b.N {
+				fut := asyncigo.NewFuture[int]()
+				loop.RunCallback(func() {
+					fut.SetResult(1, nil)
+				})
+				if _, err := fut.Await(ctx); err != nil {
+					return err
+				}
+			}
+			b.StopTimer()
+			return nil
+		})
+	})
+}
+
+func TestCompare(t *testing.T) {
+	parse := func(output string) []Result {
+		results, err := Parse(strings.NewReader(output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+
+	old := parse(`goos: linux
+BenchmarkEcho/64B-8     	  100000	     10000 ns/op	   6.40 MB/s	     128 B/op	       4 allocs/op
+BenchmarkEcho/64B-8     	  100000	     12000 ns/op	   5.33 MB/s	     128 B/op	       4 allocs/op
+BenchmarkEcho/64B-8     	  100000	     11000 ns/op	   5.82 MB/s	     128 B/op	       4 allocs/op
+BenchmarkSpawnTask-8    	  500000	      2000 ns/op
+BenchmarkRemoved-8      	  500000	      2000 ns/op
+PASS
+`)
+	new := parse(`BenchmarkEcho/64B-8     	  100000	     10500 ns/op	   6.10 MB/s	     128 B/op	       3 allocs/op
+BenchmarkSpawnTask-8    	  500000	      2500 ns/op
+BenchmarkAdded-8        	  500000	      2000 ns/op
+`)
+	if len(old) != 5 || old[0].Name != "BenchmarkEcho/64B" || old[0].MBPerSec != 6.4 || old[0].AllocsPerOp != 4 {
+		t.Fatalf("unexpected parse result: %+v", old)
+	}
+
+	comparisons := Compare(old, new)
+	want := []Comparison{
+		{Name: "BenchmarkEcho/64B", Old: 11000, New: 10500, OldAllocs: 4, NewAllocs: 3},
+		{Name: "BenchmarkSpawnTask", Old: 2000, New: 2500},
+	}
+	if len(comparisons) != len(want) || comparisons[0] != want[0] || comparisons[1] != want[1] {
+		t.Fatalf("expected %+v, got: %+v", want, comparisons)
+	}
+	if regressions := Regressions(comparisons, 0.05); len(regressions) != 1 || regressions[0].Name != "BenchmarkSpawnTask" {
+		t.Errorf("expected only BenchmarkSpawnTask to regress, got: %+v", regressions)
+	}
+
+	var report strings.Builder
+	if err := WriteReport(&report, comparisons, 0.05); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(report.String()), "\n"); len(lines) != 3 || !strings.Contains(lines[2], "+25.0%") || !strings.Contains(lines[2], "REGRESSION") {
+		t.Errorf("unexpected report:\n%s", report.String())
+	}
+}
@@ -0,0 +1,54 @@
+// Command benchcmp compares two sets of benchmark results recorded using go test -bench,
+// printing a table of the changes and exiting with status 1 if any benchmark regressed.
+//
+// Usage:
+//
+//	benchcmp [-threshold 0.05] old.txt new.txt
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/arvidfm/asyncigo/bench"
+)
+
+func main() {
+	threshold := flag.Float64("threshold", 0.05, "relative slowdown above which a benchmark is considered to have regressed")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: benchcmp [-threshold 0.05] old.txt new.txt")
+		os.Exit(2)
+	}
+
+	old, err := parseFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	new, err := parseFile(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	comparisons := bench.Compare(old, new)
+	if err := bench.WriteReport(os.Stdout, comparisons, *threshold); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if regressions := bench.Regressions(comparisons, *threshold); len(regressions) > 0 {
+		fmt.Printf("\n%d benchmark(s) regressed by more than %.0f%%\n", len(regressions), *threshold*100)
+		os.Exit(1)
+	}
+}
+
+func parseFile(path string) ([]bench.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return bench.Parse(f)
+}
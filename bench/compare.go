@@ -0,0 +1,166 @@
+// Package bench contains reproducible benchmarks of the asyncigo event loop,
+// along with a harness for comparing benchmark runs to catch performance regressions.
+//
+// To compare a change against a baseline, record both runs and compare them:
+//
+//	go test -run '^$' -bench . -benchmem -count 10 ./bench > old.txt
+//	# apply the change
+//	go test -run '^$' -bench . -benchmem -count 10 ./bench > new.txt
+//	go run ./bench/cmd/benchcmp old.txt new.txt
+package bench
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Result holds the measurements of a single benchmark run, as reported by go test -bench.
+// Measurements that weren't reported are left as 0.
+type Result struct {
+	// Name is the name of the benchmark, without the GOMAXPROCS suffix.
+	Name        string
+	Iterations  int
+	NsPerOp     float64
+	MBPerSec    float64
+	BytesPerOp  float64
+	AllocsPerOp float64
+}
+
+// Parse reads benchmark results in the format printed by go test -bench, ignoring any other lines.
+func Parse(r io.Reader) ([]Result, error) {
+	var results []Result
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+
+		iterations, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		result := Result{Name: trimProcs(fields[0]), Iterations: iterations}
+		for i := 2; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid measurement %q", fields[0], fields[i])
+			}
+			switch fields[i+1] {
+			case "ns/op":
+				result.NsPerOp = value
+			case "MB/s":
+				result.MBPerSec = value
+			case "B/op":
+				result.BytesPerOp = value
+			case "allocs/op":
+				result.AllocsPerOp = value
+			}
+		}
+		results = append(results, result)
+	}
+	return results, scanner.Err()
+}
+
+// trimProcs removes the -N GOMAXPROCS suffix from a benchmark name.
+func trimProcs(name string) string {
+	if i := strings.LastIndexByte(name, '-'); i >= 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil {
+			return name[:i]
+		}
+	}
+	return name
+}
+
+// Comparison compares the time per operation of a benchmark between two sets of runs.
+// If a benchmark was run several times, e.g. using -count, the median of each set is compared,
+// which is less sensitive to outliers than the mean.
+type Comparison struct {
+	Name string
+	// Old and New are the median times per operation in nanoseconds.
+	Old, New float64
+	// OldAllocs and NewAllocs are the median allocations per operation.
+	OldAllocs, NewAllocs float64
+}
+
+// Delta returns the relative change in time per operation, e.g. 0.1 if the benchmark got 10% slower.
+func (c Comparison) Delta() float64 {
+	if c.Old == 0 {
+		return 0
+	}
+	return (c.New - c.Old) / c.Old
+}
+
+// Compare compares the benchmarks present in both old and new, ordered by name.
+func Compare(old, new []Result) []Comparison {
+	oldByName, newByName := groupByName(old), groupByName(new)
+
+	var comparisons []Comparison
+	for name, oldResults := range oldByName {
+		newResults, ok := newByName[name]
+		if !ok {
+			continue
+		}
+		comparisons = append(comparisons, Comparison{
+			Name:      name,
+			Old:       median(oldResults, func(r Result) float64 { return r.NsPerOp }),
+			New:       median(newResults, func(r Result) float64 { return r.NsPerOp }),
+			OldAllocs: median(oldResults, func(r Result) float64 { return r.AllocsPerOp }),
+			NewAllocs: median(newResults, func(r Result) float64 { return r.AllocsPerOp }),
+		})
+	}
+	slices.SortFunc(comparisons, func(a, b Comparison) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return comparisons
+}
+
+// Regressions returns the comparisons whose time per operation increased by more than threshold,
+// e.g. 0.05 for 5%.
+func Regressions(comparisons []Comparison, threshold float64) []Comparison {
+	return slices.DeleteFunc(slices.Clone(comparisons), func(c Comparison) bool {
+		return c.Delta() <= threshold
+	})
+}
+
+// WriteReport writes a table of the given comparisons to w,
+// marking those whose time per operation increased by more than threshold.
+func WriteReport(w io.Writer, comparisons []Comparison, threshold float64) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "name\told ns/op\tnew ns/op\tdelta\told allocs/op\tnew allocs/op\t\t")
+	for _, c := range comparisons {
+		var mark string
+		if c.Delta() > threshold {
+			mark = "REGRESSION"
+		}
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%+.1f%%\t%.0f\t%.0f\t%s\t\n",
+			c.Name, c.Old, c.New, c.Delta()*100, c.OldAllocs, c.NewAllocs, mark)
+	}
+	return tw.Flush()
+}
+
+func groupByName(results []Result) map[string][]Result {
+	byName := make(map[string][]Result)
+	for _, r := range results {
+		byName[r.Name] = append(byName[r.Name], r)
+	}
+	return byName
+}
+
+func median(results []Result, value func(Result) float64) float64 {
+	values := make([]float64, len(results))
+	for i, r := range results {
+		values[i] = value(r)
+	}
+	slices.Sort(values)
+	if n := len(values); n%2 == 0 {
+		return (values[n/2-1] + values[n/2]) / 2
+	}
+	return values[len(values)/2]
+}
@@ -0,0 +1,44 @@
+package asyncigo
+
+import (
+	"context"
+	"time"
+)
+
+// Budget is an overall time limit shared by a sequence of operations,
+// e.g. resolving a host, connecting to it, and then sending a request,
+// such that each step is given whatever time is left over by the preceding steps.
+type Budget struct {
+	deadline time.Time
+}
+
+// NewBudget starts a new [Budget] with the given total duration.
+func NewBudget(total time.Duration) *Budget {
+	return &Budget{deadline: time.Now().Add(total)}
+}
+
+// Deadline returns the point in time at which the budget runs out.
+// Pass it to [Awaitable.AwaitDeadline] to bound a single await by the remaining budget.
+func (b *Budget) Deadline() time.Time {
+	return b.deadline
+}
+
+// Remaining returns the time left before the budget runs out, or zero if it has already run out.
+func (b *Budget) Remaining() time.Duration {
+	return max(0, time.Until(b.deadline))
+}
+
+// Expired reports whether the budget has run out.
+func (b *Budget) Expired() bool {
+	return b.Remaining() == 0
+}
+
+// Context returns a copy of ctx that expires once the budget runs out,
+// or earlier if ctx has an earlier deadline.
+// Once the budget has run out, the cause of the context's cancellation will be [ErrTimeout].
+//
+// Note that tasks only observe context cancellation when resumed;
+// to interrupt an await as soon as the budget runs out, use [Awaitable.AwaitDeadline].
+func (b *Budget) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithDeadlineCause(ctx, b.deadline, ErrTimeout)
+}
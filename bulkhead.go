@@ -0,0 +1,95 @@
+package asyncigo
+
+import (
+	"context"
+	"slices"
+)
+
+// Bulkhead isolates calls to a dependency by bounding both how many calls may run concurrently
+// and how many calls may be queued waiting for a free slot, rejecting calls beyond that with [ErrBulkheadFull].
+// This keeps one slow dependency from tying up every task in a service.
+// Bulkhead is not threadsafe.
+type Bulkhead struct {
+	maxConcurrent int
+	maxQueued     int
+
+	running int
+	queue   []*Future[any]
+}
+
+// NewBulkhead constructs a new [Bulkhead] allowing maxConcurrent calls to run at a time,
+// with up to maxQueued additional calls waiting for a slot.
+func NewBulkhead(maxConcurrent, maxQueued int) *Bulkhead {
+	return &Bulkhead{
+		maxConcurrent: max(1, maxConcurrent),
+		maxQueued:     max(0, maxQueued),
+	}
+}
+
+// WithBulkhead wraps the given coroutine so that each call is run through the bulkhead.
+func WithBulkhead[T any](b *Bulkhead, coro Coroutine2[T]) Coroutine2[T] {
+	return func(ctx context.Context) (T, error) {
+		if err := b.acquire(ctx); err != nil {
+			var zero T
+			return zero, err
+		}
+		defer b.release()
+		return coro(ctx)
+	}
+}
+
+// Run runs the given coroutine through the bulkhead.
+// Returns [ErrBulkheadFull] without running the coroutine if the bulkhead's queue is full.
+func (b *Bulkhead) Run(ctx context.Context, coro Coroutine1) error {
+	_, err := WithBulkhead(b, func(ctx context.Context) (any, error) {
+		return nil, coro(ctx)
+	})(ctx)
+	return err
+}
+
+// Running returns the number of calls currently running.
+func (b *Bulkhead) Running() int {
+	return b.running
+}
+
+// Queued returns the number of calls currently waiting for a slot.
+func (b *Bulkhead) Queued() int {
+	return len(b.queue)
+}
+
+func (b *Bulkhead) acquire(ctx context.Context) error {
+	if b.running < b.maxConcurrent {
+		b.running++
+		return nil
+	} else if len(b.queue) >= b.maxQueued {
+		return ErrBulkheadFull
+	}
+
+	fut := NewFuture[any]()
+	b.queue = append(b.queue, fut)
+	if _, err := fut.Await(ctx); err != nil {
+		if fut.Err() == nil {
+			// we were handed a slot just as we were cancelled, so hand it over to someone else
+			b.release()
+		} else {
+			b.queue = slices.DeleteFunc(b.queue, func(f *Future[any]) bool {
+				return f == fut
+			})
+		}
+		return err
+	}
+	return nil
+}
+
+func (b *Bulkhead) release() {
+	// hand the slot directly to the next call in line
+	for len(b.queue) > 0 {
+		fut := b.queue[0]
+		b.queue = b.queue[1:]
+		if !fut.HasResult() {
+			fut.SetResult(nil, nil)
+			return
+		}
+	}
+	b.running--
+}
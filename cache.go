@@ -0,0 +1,149 @@
+package asyncigo
+
+import (
+	"context"
+	"time"
+)
+
+// RequestCache caches the results of an asynchronous operation keyed by request,
+// such as the responses of a remote API, combining three behaviours:
+//   - concurrent requests for the same key share a single in-flight fetch
+//   - results are cached for a TTL, after which they are considered stale
+//   - stale results are returned immediately for a further grace period,
+//     while a background task fetches a fresh result
+//
+// Results expire lazily based on when they were fetched, so the cache schedules no timers
+// that could keep the event loop running. Expired results are swept away as the cache is used,
+// so little state is kept for keys that have not been requested recently.
+// Errors are not cached; if a background refresh fails, the stale result keeps being served until it expires.
+// RequestCache is not threadsafe.
+type RequestCache[K comparable, V any] struct {
+	fetch    func(ctx context.Context, key K) (V, error)
+	ttl      time.Duration
+	staleFor time.Duration
+
+	entries map[K]*requestCacheEntry[V]
+	// lastSweep is the time expired results were last removed
+	lastSweep time.Time
+}
+
+type requestCacheEntry[V any] struct {
+	value    V
+	hasValue bool
+	stale    bool
+	// fetched is the time the value was stored, from which it goes stale and is eventually evicted
+	fetched time.Time
+	// pending is the fetch currently in flight, if any
+	pending *Task[V]
+}
+
+// NewRequestCache constructs a new [RequestCache] that calls fetch to retrieve the result for a key.
+// Results are fresh for ttl, and are then served while being refreshed in the background for up to staleFor.
+// If staleFor is zero, results are evicted as soon as they become stale.
+func NewRequestCache[K comparable, V any](fetch func(ctx context.Context, key K) (V, error), ttl, staleFor time.Duration) *RequestCache[K, V] {
+	return &RequestCache[K, V]{
+		fetch:    fetch,
+		ttl:      ttl,
+		staleFor: staleFor,
+		entries:  make(map[K]*requestCacheEntry[V]),
+	}
+}
+
+// Get returns the result for the given key, fetching it if there is no cached result.
+// If a fetch for the key is already in flight, Get waits for it rather than starting another one.
+// A stale result is returned immediately, starting a background refresh unless one is already in flight.
+//
+// Cancelling a call to Get only cancels the fetch once every caller waiting for it has been cancelled.
+func (c *RequestCache[K, V]) Get(ctx context.Context, key K) (V, error) {
+	now := time.Now()
+	if now.Sub(c.lastSweep) >= c.ttl {
+		c.expireAll(now)
+	}
+
+	entry := c.entries[key]
+	if entry != nil {
+		c.expire(now, key, entry)
+	}
+	if entry == nil || c.entries[key] != entry {
+		entry = &requestCacheEntry[V]{}
+		c.entries[key] = entry
+	}
+
+	if entry.hasValue {
+		if entry.stale {
+			c.refresh(ctx, key, entry)
+		}
+		return entry.value, nil
+	}
+	return c.refresh(ctx, key, entry).Await(ctx)
+}
+
+// Invalidate discards any cached result for the given key,
+// so that the next call to [RequestCache.Get] fetches a fresh result.
+// Callers already waiting for a fetch in flight still receive its result, but it isn't cached.
+func (c *RequestCache[K, V]) Invalidate(key K) {
+	delete(c.entries, key)
+}
+
+// Len returns the number of keys with an unexpired result or a fetch in flight.
+func (c *RequestCache[K, V]) Len() int {
+	c.expireAll(time.Now())
+	return len(c.entries)
+}
+
+// refresh returns the fetch in flight for the entry, starting one if necessary.
+func (c *RequestCache[K, V]) refresh(ctx context.Context, key K, entry *requestCacheEntry[V]) *Task[V] {
+	if entry.pending != nil {
+		return entry.pending
+	}
+
+	// the fetch is shared by all callers, so it mustn't be tied to the context of whoever started it
+	entry.pending = SpawnTask(context.WithoutCancel(ctx), func(ctx context.Context) (V, error) {
+		return c.fetch(ctx, key)
+	}).CancelOnAbandon()
+	entry.pending.AddResultCallback(func(value V, err error) {
+		entry.pending = nil
+		if c.entries[key] != entry {
+			// invalidated while the fetch was in flight
+			return
+		} else if err != nil {
+			if !entry.hasValue {
+				delete(c.entries, key)
+			}
+			return
+		}
+		entry.value, entry.hasValue, entry.stale, entry.fetched = value, true, false, time.Now()
+	})
+	return entry.pending
+}
+
+// expireAll marks or evicts every result according to its age. See [RequestCache.expire].
+func (c *RequestCache[K, V]) expireAll(now time.Time) {
+	c.lastSweep = now
+	for key, entry := range c.entries {
+		c.expire(now, key, entry)
+	}
+}
+
+// expire marks the entry's value as stale once it is older than the TTL,
+// and evicts it once it has been stale for too long.
+func (c *RequestCache[K, V]) expire(now time.Time, key K, entry *requestCacheEntry[V]) {
+	if !entry.hasValue {
+		return
+	}
+	if age := now.Sub(entry.fetched); age >= c.ttl+max(c.staleFor, 0) {
+		c.evict(key, entry)
+	} else if age >= c.ttl {
+		entry.stale = true
+	}
+}
+
+// evict discards the entry's value once it has expired.
+// An entry with a fetch in flight is kept around for the fetch to repopulate.
+func (c *RequestCache[K, V]) evict(key K, entry *requestCacheEntry[V]) {
+	var zero V
+	entry.value, entry.hasValue, entry.stale = zero, false, false
+	if entry.pending == nil && c.entries[key] == entry {
+		delete(c.entries, key)
+	}
+}
@@ -0,0 +1,111 @@
+package asyncigo
+
+import (
+	"context"
+	"math/rand"
+	"syscall"
+	"time"
+)
+
+// ChaosPolicy controls the faults injected by [ChaosStream].
+// Probabilities are in the range [0, 1] and are evaluated for each read or write.
+type ChaosPolicy struct {
+	// Seed seeds the random number generator, so that the same sequence of operations
+	// results in the same sequence of faults.
+	Seed int64
+	// Latency is the maximum delay added each time the stream waits for I/O.
+	// Delays are chosen uniformly at random and implemented using event loop timers.
+	Latency time.Duration
+	// ShortRead is the probability of a read returning fewer bytes than are available.
+	ShortRead float64
+	// WouldBlock is the probability of a read or write failing with [syscall.EAGAIN]
+	// even though the file is ready, forcing the stream to wait for I/O again.
+	WouldBlock float64
+	// Reset is the probability of the connection being reset, causing the operation
+	// and all further operations to fail with [syscall.ECONNRESET].
+	Reset float64
+	// Corrupt is the probability of each read having a random bit flipped.
+	Corrupt float64
+}
+
+// ChaosStream starts injecting faults into the stream according to the given policy,
+// allowing protocol implementations to be tested for robustness against misbehaving peers and networks.
+//
+// Like [RecordStream], ChaosStream hides any optional capabilities of the underlying file handle.
+func ChaosStream(stream *AsyncStream, policy ChaosPolicy) *AsyncStream {
+	stream.file = &chaosFile{
+		AsyncReadWriteCloser: stream.file,
+		policy:               policy,
+		rand:                 rand.New(rand.NewSource(policy.Seed)),
+	}
+	return stream
+}
+
+// chaosFile is an [AsyncReadWriteCloser] that injects faults according to a [ChaosPolicy].
+type chaosFile struct {
+	AsyncReadWriteCloser
+	policy ChaosPolicy
+	rand   *rand.Rand
+
+	// blocked is set if we pretended the file wasn't ready, in which case the
+	// underlying file won't report any new I/O events, so we shouldn't wait for them
+	blocked bool
+	reset   bool
+}
+
+// Read implements [io.Reader].
+func (c *chaosFile) Read(p []byte) (n int, err error) {
+	if err := c.fault(); err != nil {
+		return 0, err
+	}
+
+	if len(p) > 1 && c.chance(c.policy.ShortRead) {
+		p = p[:1+c.rand.Intn(len(p)-1)]
+	}
+	n, err = c.AsyncReadWriteCloser.Read(p)
+	if n > 0 && c.chance(c.policy.Corrupt) {
+		p[c.rand.Intn(n)] ^= 1 << c.rand.Intn(8)
+	}
+	return n, err
+}
+
+// Write implements [io.Writer].
+func (c *chaosFile) Write(p []byte) (n int, err error) {
+	if err := c.fault(); err != nil {
+		return 0, err
+	}
+	return c.AsyncReadWriteCloser.Write(p)
+}
+
+// WaitForReady implements [AsyncReadWriteCloser], adding random latency.
+func (c *chaosFile) WaitForReady(ctx context.Context) error {
+	if c.blocked {
+		c.blocked = false
+	} else if err := c.AsyncReadWriteCloser.WaitForReady(ctx); err != nil {
+		return err
+	}
+
+	if c.policy.Latency > 0 {
+		return Sleep(ctx, time.Duration(c.rand.Int63n(int64(c.policy.Latency))))
+	}
+	// yield even without latency, so that an EAGAIN storm doesn't monopolise the loop
+	return Checkpoint(ctx)
+}
+
+// fault returns the fault to inject into the next operation, if any.
+func (c *chaosFile) fault() error {
+	if c.reset || c.chance(c.policy.Reset) {
+		c.reset = true
+		return syscall.ECONNRESET
+	}
+	if c.chance(c.policy.WouldBlock) {
+		c.blocked = true
+		return syscall.EAGAIN
+	}
+	return nil
+}
+
+// chance returns true with the given probability.
+func (c *chaosFile) chance(p float64) bool {
+	return p > 0 && c.rand.Float64() < p
+}
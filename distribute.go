@@ -0,0 +1,97 @@
+package asyncigo
+
+import (
+	"context"
+)
+
+// DistributeOptions configures how [Distribute] handles items that fail to be processed.
+type DistributeOptions[T any] struct {
+	// Retry decides whether an item that failed on the given attempt (starting at 1)
+	// should be handed to a worker again. If nil, failed items are not retried.
+	Retry func(item T, attempt int, err error) bool
+	// DeadLetter is called with each item that failed and will not be retried.
+	// If nil, the first such failure stops all workers and is returned from Distribute.
+	DeadLetter func(item T, err error)
+}
+
+// distributeJob is an item handed to a worker by [Distribute].
+type distributeJob[T any] struct {
+	item    T
+	attempt int
+}
+
+// Distribute consumes items from the given iterable using the given number of worker tasks,
+// calling handler for each item. An item is acknowledged once handler returns nil;
+// failed items are routed according to opts.
+// At most one item per worker is taken from the iterable at a time,
+// so a slow handler exerts backpressure on the source.
+//
+// Distribute returns once the iterable is exhausted and all items have been processed,
+// or once the iterable or a terminal failure returns an error, in which case all workers are cancelled.
+func Distribute[T any](ctx context.Context, it AsyncIterable[T], workers int, handler func(ctx context.Context, item T) error, opts DistributeOptions[T]) error {
+	workers = max(1, workers)
+
+	var queue Queue[distributeJob[T]]
+	// each item holds a slot from when it's taken from the source until it's been acknowledged or dead-lettered
+	slots := NewSemaphore(workers)
+	var pending int
+	var sourceDone bool
+	finishItem := func() {
+		pending--
+		slots.Release()
+		if sourceDone && pending == 0 {
+			queue.Close()
+		}
+	}
+
+	tasks := []Futurer{SpawnTask(ctx, func(ctx context.Context) (any, error) {
+		for item, err := range it {
+			if err != nil {
+				return nil, err
+			}
+			if err := slots.Acquire(ctx); err != nil {
+				return nil, err
+			}
+			pending++
+			queue.Push(distributeJob[T]{item: item, attempt: 1})
+		}
+
+		sourceDone = true
+		if pending == 0 {
+			queue.Close()
+		}
+		return nil, nil
+	})}
+
+	for range workers {
+		tasks = append(tasks, SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			var err error
+			for job := range queue.Iter(ctx).UntilErr(&err) {
+				handlerErr := handler(ctx, job.item)
+				if handlerErr == nil {
+					finishItem()
+					continue
+				}
+
+				if opts.Retry != nil && opts.Retry(job.item, job.attempt, handlerErr) {
+					job.attempt++
+					queue.Push(job)
+				} else if opts.DeadLetter != nil {
+					opts.DeadLetter(job.item, handlerErr)
+					finishItem()
+				} else {
+					return nil, handlerErr
+				}
+			}
+			return nil, err
+		}))
+	}
+
+	err := Wait(ctx, WaitFirstError, tasks...)
+	if err != nil {
+		for _, task := range tasks {
+			task.Cancel(nil)
+		}
+	}
+	return err
+}
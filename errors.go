@@ -0,0 +1,218 @@
+package asyncigo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	// ErrFuturePending is returned when retrieving the result of a [Future] that has not yet completed.
+	ErrFuturePending = &sentinelError{msg: "future is still pending"}
+	// ErrNotReady is an alias of [ErrFuturePending].
+	//
+	// Deprecated: use ErrFuturePending.
+	ErrNotReady = ErrFuturePending
+	// ErrTaskCancelled is the error a [Task] completes with when cancelled without an explicit cause.
+	// It matches [context.Canceled] when used with [errors.Is].
+	ErrTaskCancelled = &sentinelError{msg: "task cancelled", wrapped: context.Canceled}
+	// ErrTimeout is returned by operations that did not complete within their allotted time.
+	// It matches [context.DeadlineExceeded] when used with [errors.Is].
+	ErrTimeout = &sentinelError{msg: "operation timed out", wrapped: context.DeadlineExceeded}
+	// ErrLoopClosed is returned when attempting to perform I/O using an [EventLoop] that is not running.
+	ErrLoopClosed = &sentinelError{msg: "event loop is not running"}
+	// ErrStreamClosed is returned when attempting to use an [AsyncStream] that has been closed.
+	// It matches [net.ErrClosed] when used with [errors.Is].
+	ErrStreamClosed = &sentinelError{msg: "stream is closed", wrapped: net.ErrClosed}
+	// ErrBufferFull is returned when reading from an [AsyncStream] would exceed its maximum buffer size.
+	// See [StreamOptions].
+	ErrBufferFull = &sentinelError{msg: "stream buffer is full"}
+	// ErrBulkheadFull is returned when a [Bulkhead] has no capacity left to queue another call.
+	ErrBulkheadFull = &sentinelError{msg: "bulkhead is full"}
+	// ErrQueueClosed is returned when getting an item from a [Queue] that has been closed and drained.
+	ErrQueueClosed = &sentinelError{msg: "queue is closed"}
+	// ErrQueueFull is returned by [Queue.PutNoWait] when a bounded [Queue] has no space left.
+	ErrQueueFull = &sentinelError{msg: "queue is full"}
+	// ErrBarrierBroken is returned when waiting on a [Barrier] that has been aborted,
+	// or whose waiters were cancelled before all parties arrived.
+	ErrBarrierBroken = &sentinelError{msg: "barrier is broken"}
+	// ErrLockTimeout is returned by [Mutex.LockTimeout] if the lock could not be acquired in time.
+	// It matches [ErrTimeout] when used with [errors.Is].
+	ErrLockTimeout = &sentinelError{msg: "timed out waiting for lock", wrapped: ErrTimeout}
+	// ErrSupervisorShutdown is the cause children of a [Supervisor] are cancelled with when stopped,
+	// and is returned when adding children to a Supervisor that has been shut down.
+	// It matches [context.Canceled] when used with [errors.Is].
+	ErrSupervisorShutdown = &sentinelError{msg: "supervisor is shut down", wrapped: context.Canceled}
+	// ErrMalformedFragment is returned by [Reassembler.Add] for datagrams that weren't produced by [Fragment].
+	ErrMalformedFragment = &sentinelError{msg: "malformed datagram fragment"}
+	// ErrAcceptThrottled is returned by [AcceptThrottle.Filter] to reject a connection
+	// from an address that has exceeded its connection rate limit.
+	ErrAcceptThrottled = &sentinelError{msg: "too many connections from this address"}
+	// ErrAddressBanned is returned by [AcceptThrottle.Filter] to reject a connection
+	// from an address that has been banned using [AcceptThrottle.Ban].
+	ErrAddressBanned = &sentinelError{msg: "address is temporarily banned"}
+	// ErrSocksNoAcceptableMethod is returned by [Socks5Server.ServeConn] if the client
+	// offers none of the authentication methods supported by the server.
+	ErrSocksNoAcceptableMethod = &sentinelError{msg: "socks5: no acceptable authentication method"}
+	// ErrSocksAuthFailed is returned by [Socks5Server.ServeConn] if the client's credentials are rejected,
+	// joined with the error returned by [Socks5Server.Auth].
+	ErrSocksAuthFailed = &sentinelError{msg: "socks5: authentication failed"}
+	// ErrSocksUnsupported is returned by [Socks5Server.ServeConn] if the client requests
+	// a command other than CONNECT, or uses an unsupported address type.
+	ErrSocksUnsupported = &sentinelError{msg: "socks5: unsupported command or address type"}
+)
+
+// sentinelError is an error with a distinct identity that may also match
+// a more general error from the standard library.
+type sentinelError struct {
+	msg     string
+	wrapped error
+}
+
+// Error implements [error].
+func (e *sentinelError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the more general error matched by this error, if any.
+func (e *sentinelError) Unwrap() error {
+	return e.wrapped
+}
+
+// CancelledError is the error an [Awaitable] completes with when cancelled using [Futurer.Cancel],
+// or when a [Task] is cancelled because its context was cancelled.
+// It allows distinguishing "this operation was cancelled" from other failures,
+// while Cause records why the operation was cancelled.
+//
+// A CancelledError always matches [context.Canceled] when used with [errors.Is],
+// as well as its cause.
+type CancelledError struct {
+	// Cause is the reason for the cancellation, e.g. the error passed to [Futurer.Cancel],
+	// [ErrTaskCancelled], or the cause of a cancelled [context.Context].
+	Cause error
+}
+
+// newCancelledError wraps the given cause in a [CancelledError],
+// unless it already is one.
+func newCancelledError(cause error) error {
+	if cerr, ok := cause.(*CancelledError); ok {
+		return cerr
+	}
+	if cause == nil {
+		cause = context.Canceled
+	}
+	return &CancelledError{Cause: cause}
+}
+
+// Error implements [error].
+func (e *CancelledError) Error() string {
+	if e.Cause == nil {
+		return context.Canceled.Error()
+	}
+	return e.Cause.Error()
+}
+
+// Unwrap returns the cause of the cancellation.
+func (e *CancelledError) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports whether target is [context.Canceled].
+func (e *CancelledError) Is(target error) bool {
+	return target == context.Canceled
+}
+
+// AwaitPanic is the value [Awaitable.MustAwait] panics with when Await returns an error.
+// It records enough context to make sense of a crash deep within a chain of coroutines.
+type AwaitPanic struct {
+	// Err is the error returned by Await.
+	Err error
+	// Awaitable is the name of the awaited [Awaitable]; see [Future.WithName].
+	// Unnamed tasks are identified by their sequence number, e.g. "task 3".
+	Awaitable string
+	// AwaitChain describes the task that called MustAwait, followed by the task awaiting it,
+	// and so on up to the outermost task.
+	AwaitChain []string
+}
+
+// newAwaitPanic constructs an [AwaitPanic], walking the await chain of the currently running task.
+func newAwaitPanic(ctx context.Context, name string, err error) *AwaitPanic {
+	p := &AwaitPanic{Err: err, Awaitable: name}
+	if loop, ok := RunningLoopMaybe(ctx); ok && len(loop.currentTasks) > 0 {
+		// guard against cycles in case tasks have awaited each other in turn
+		seen := map[tasker]bool{}
+		for t := loop.currentTask(); t != nil && !seen[t]; t = t.awaiter() {
+			seen[t] = true
+			p.AwaitChain = append(p.AwaitChain, t.describe())
+		}
+	}
+	return p
+}
+
+// Error implements [error].
+func (p *AwaitPanic) Error() string {
+	var sb strings.Builder
+	sb.WriteString("MustAwait failed")
+	if p.Awaitable != "" {
+		fmt.Fprintf(&sb, " on %s", p.Awaitable)
+	}
+	if len(p.AwaitChain) > 0 {
+		fmt.Fprintf(&sb, " (await chain: %s)", strings.Join(p.AwaitChain, " <- "))
+	}
+	fmt.Fprintf(&sb, ": %v", p.Err)
+	return sb.String()
+}
+
+// Unwrap returns the error returned by Await.
+func (p *AwaitPanic) Unwrap() error {
+	return p.Err
+}
+
+// DeadlockError is returned when awaiting would cause a cycle of tasks waiting on each other,
+// if deadlock detection has been enabled using [EventLoop.SetDeadlockDetection].
+type DeadlockError struct {
+	// Cycle describes the tasks involved, starting and ending with the task that attempted to await,
+	// with each task waiting on the next.
+	Cycle []string
+}
+
+// Error implements [error].
+func (e *DeadlockError) Error() string {
+	return "deadlock detected: " + strings.Join(e.Cycle, " -> ")
+}
+
+// AffinityError is the panic value raised when an operation bound to an [EventLoop] is invoked
+// from a goroutine that isn't running on the loop; see [AssertOnLoop] and [EventLoop.SetAffinityChecks].
+type AffinityError struct {
+	// Op is the operation that was invoked.
+	Op string
+	// Goroutine is the ID of the offending goroutine.
+	Goroutine uint64
+	// Stack is the stack trace of the offending goroutine.
+	Stack []byte
+}
+
+// Error implements [error].
+func (e *AffinityError) Error() string {
+	return fmt.Sprintf("%s called from goroutine %d, which is not running the event loop\n%s", e.Op, e.Goroutine, e.Stack)
+}
+
+// PanicError is the error a [Task] completes with if its coroutine panics.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the stack trace of the panicking coroutine.
+	Stack []byte
+}
+
+// Error implements [error].
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panicked: %v\n%s", e.Value, e.Stack)
+}
+
+// Unwrap returns the value passed to panic if it is an error, such as an [*AwaitPanic].
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
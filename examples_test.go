@@ -91,14 +91,14 @@ func ExampleFuture_Shield() {
 	// Output:
 	// waiting for shielded...
 	// waiting for unshielded...
-	// task1: context canceled
-	// task2: context canceled
+	// task1: task cancelled
+	// task2: task cancelled
 	// shielded: <nil>
-	// unshielded: context canceled
+	// unshielded: task cancelled
 }
 
 // When a task has been cancelled, it will continue running, but any calls to [Awaitable.Await]
-// will immediately return [context.Canceled].
+// will immediately return [asyncigo.ErrTaskCancelled].
 // It's the responsibility of the task to stop early when cancelled.
 func ExampleTask_Cancel() {
 	_ = asyncigo.NewEventLoop().Run(context.Background(), func(ctx context.Context) error {
@@ -131,12 +131,12 @@ func ExampleTask_Cancel() {
 	// 2: (2, <nil>)
 	// 3: (3, <nil>)
 	// 4: (4, <nil>)
-	// 5: (0, context canceled)
-	// 6: (0, context canceled)
-	// 7: (0, context canceled)
-	// 8: (0, context canceled)
-	// 9: (0, context canceled)
-	// task result: (0, context canceled)
+	// 5: (0, task cancelled)
+	// 6: (0, task cancelled)
+	// 7: (0, task cancelled)
+	// 8: (0, task cancelled)
+	// 9: (0, task cancelled)
+	// task result: (0, task cancelled)
 }
 
 func ExampleIterator_Collect() {
@@ -0,0 +1,26 @@
+//go:build !unix
+
+package asyncigo
+
+import (
+	"context"
+	"os"
+)
+
+// FileLock is an advisory lock held on a file.
+type FileLock struct{}
+
+// LockFile is not supported on this platform and will always return [ErrNotImplemented].
+func LockFile[F string | *os.File](_ context.Context, _ F, _ bool) (*FileLock, error) {
+	return nil, ErrNotImplemented
+}
+
+// File returns the locked file.
+func (l *FileLock) File() *os.File {
+	return nil
+}
+
+// Unlock releases the lock.
+func (l *FileLock) Unlock() error {
+	return ErrNotImplemented
+}
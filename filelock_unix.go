@@ -0,0 +1,83 @@
+//go:build unix
+
+package asyncigo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	fileLockMinRetry = time.Millisecond
+	fileLockMaxRetry = time.Millisecond * 100
+)
+
+// FileLock is an advisory lock held on a file using flock(2).
+type FileLock struct {
+	file     *os.File
+	ownsFile bool
+}
+
+// LockFile acquires an advisory lock on the given file, which may be given either as a path
+// or as an already opened [os.File]. If exclusive is false, a shared lock will be acquired instead.
+//
+// flock(2) has no non-blocking wait mechanism, so if the lock is held by another process,
+// the calling coroutine will sleep and retry with exponential backoff rather than block the event loop.
+// The lock is released by the kernel if the holding process exits, so no lease refresh is required.
+func LockFile[F string | *os.File](ctx context.Context, file F, exclusive bool) (*FileLock, error) {
+	lock := &FileLock{}
+	switch f := any(file).(type) {
+	case string:
+		var err error
+		if lock.file, err = os.OpenFile(f, os.O_RDWR|os.O_CREATE, 0o666); err != nil {
+			return nil, err
+		}
+		lock.ownsFile = true
+	case *os.File:
+		lock.file = f
+	}
+
+	how := unix.LOCK_SH
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+
+	retry := fileLockMinRetry
+	for {
+		err := unix.Flock(int(lock.file.Fd()), how|unix.LOCK_NB)
+		if err == nil {
+			return lock, nil
+		}
+
+		if errors.Is(err, unix.EWOULDBLOCK) || errors.Is(err, unix.EINTR) {
+			if err = Sleep(ctx, retry); err == nil {
+				retry = min(retry*2, fileLockMaxRetry)
+				continue
+			}
+		}
+
+		if lock.ownsFile {
+			_ = lock.file.Close()
+		}
+		return nil, err
+	}
+}
+
+// File returns the locked file.
+func (l *FileLock) File() *os.File {
+	return l.file
+}
+
+// Unlock releases the lock. If the lock was acquired using a path,
+// the file opened by [LockFile] will be closed as well.
+func (l *FileLock) Unlock() error {
+	err := unix.Flock(int(l.file.Fd()), unix.LOCK_UN)
+	if l.ownsFile {
+		err = errors.Join(err, l.file.Close())
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package asyncigo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+// StreamFile returns an [AsyncIterable] that yields the contents of the file at the given path
+// in chunks of up to chunkSize bytes. If chunkSize isn't positive, chunks of up to 64 KiB are used.
+//
+// Since regular files can't be polled for readiness, the file is read by a background goroutine,
+// which keeps up to readAhead chunks ready ahead of the consumer.
+// This lets e.g. file-serving code overlap disk latency with writing the previous chunks to the network.
+func StreamFile(ctx context.Context, path string, chunkSize, readAhead int) AsyncIterable[[]byte] {
+	if chunkSize <= 0 {
+		chunkSize = 64 << 10
+	}
+	return AsyncIter(func(yield func([]byte) error) error {
+		loop := RunningLoop(ctx)
+
+		var chunks Queue[Result[[]byte]]
+		// the reader needs a credit for each chunk it reads, and gets one back for each chunk we consume
+		credits := make(chan struct{}, max(1, readAhead))
+		done := make(chan struct{})
+		defer close(done)
+
+		go func() {
+			defer loop.RunCallbackThreadsafe(ctx, chunks.Close)
+
+			f, err := os.Open(path)
+			if err != nil {
+				loop.RunCallbackThreadsafe(ctx, func() {
+					chunks.Push(Result[[]byte]{Err: err})
+				})
+				return
+			}
+			defer f.Close()
+
+			for {
+				select {
+				case credits <- struct{}{}:
+				case <-done:
+					return
+				}
+
+				buf := make([]byte, chunkSize)
+				n, err := io.ReadFull(f, buf)
+				if errors.Is(err, io.EOF) {
+					return
+				} else if errors.Is(err, io.ErrUnexpectedEOF) {
+					err = nil
+				}
+
+				loop.RunCallbackThreadsafe(ctx, func() {
+					chunks.Push(Result[[]byte]{Value: buf[:n], Err: err})
+				})
+				if err != nil || n < chunkSize {
+					return
+				}
+			}
+		}()
+
+		for {
+			chunk, err := chunks.Get().Await(ctx)
+			if errors.Is(err, ErrQueueClosed) {
+				return nil
+			} else if err != nil {
+				return err
+			} else if chunk.Err != nil {
+				return chunk.Err
+			}
+			<-credits
+
+			if err := yield(chunk.Value); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// SendFile writes the contents of the file at the given path to the stream, returning the number of bytes written.
+// The file is read as by [StreamFile], using the given chunkSize and readAhead.
+// If progress callbacks are passed, they are invoked with the total number of bytes written so far
+// after each chunk has been written.
+func (a *AsyncStream) SendFile(ctx context.Context, path string, chunkSize, readAhead int, progress ...func(sent int64)) (sent int64, err error) {
+	for chunk, err := range StreamFile(ctx, path, chunkSize, readAhead) {
+		if err != nil {
+			return sent, err
+		}
+
+		written, err := a.Write(ctx, chunk).Await(ctx)
+		sent += int64(written)
+		if err != nil {
+			return sent, err
+		}
+		for _, p := range progress {
+			p(sent)
+		}
+	}
+	return sent, nil
+}
+
+// SendFileProgress is like [AsyncStream.SendFile], but runs the transfer as a background task,
+// returning a [Progress] that reports the number of bytes written so far out of the size of the file,
+// and completes with the total number of bytes written.
+func (a *AsyncStream) SendFileProgress(ctx context.Context, path string, chunkSize, readAhead int) *Progress[int64] {
+	p := NewProgress[int64]()
+	task := SpawnTask(ctx, func(ctx context.Context) (int64, error) {
+		// regular files can't be polled, so don't block the loop on the disk
+		info, err := Go(ctx, func(context.Context) (os.FileInfo, error) {
+			return os.Stat(path)
+		}).Await(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return a.SendFile(ctx, path, chunkSize, readAhead, func(sent int64) {
+			p.Report(sent, info.Size())
+		})
+	})
+	task.AddResultCallback(p.SetResult)
+	// cancelling the Progress stops the transfer
+	p.AddDoneCallback(func(err error) {
+		task.Cancel(err)
+	})
+	return p
+}
@@ -0,0 +1,89 @@
+package asyncigo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileWatcher is implemented by [Poller] implementations
+// that can monitor files for changes.
+type fileWatcher interface {
+	// WatchFile returns a handle that becomes ready whenever the file at the given path changes.
+	// Reading from the handle consumes the pending change notifications,
+	// and fails with an error matching [os.ErrNotExist] once the file has been deleted.
+	WatchFile(path string) (handle AsyncReadWriteCloser, err error)
+}
+
+// fileStablePollInterval is the maximum interval at which [WaitForFileStable] checks for changes
+// when the poller in use does not support watching files.
+const fileStablePollInterval = 100 * time.Millisecond
+
+// WaitForFileStable suspends the calling coroutine until the file at the given path
+// has not been changed for the given quiet period, e.g. to wait for an upload to finish
+// before ingesting a file.
+//
+// If the poller in use supports it (e.g. inotify with the epoll poller), the file is watched for changes;
+// otherwise its size and modification time are checked periodically.
+// Fails with an error matching [os.ErrNotExist] if the file doesn't exist or is deleted while waiting.
+func WaitForFileStable(ctx context.Context, path string, quietPeriod time.Duration) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	watcher, ok := RunningLoop(ctx).poller.(fileWatcher)
+	if !ok {
+		return pollFileStable(ctx, path, quietPeriod)
+	}
+
+	handle, err := watcher.WatchFile(path)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	buf := make([]byte, 4096)
+	for {
+		changed := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, handle.WaitForReady(ctx)
+		})
+		if _, err := changed.AwaitTimeout(ctx, quietPeriod); errors.Is(err, ErrTimeout) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// drain the pending events; we only care that something happened
+		for {
+			if _, err := handle.Read(buf); errors.Is(err, syscall.EAGAIN) {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pollFileStable implements [WaitForFileStable] by periodically checking the file's size and modification time.
+func pollFileStable(ctx context.Context, path string, quietPeriod time.Duration) error {
+	interval := min(quietPeriod/4, fileStablePollInterval)
+	var lastSize int64
+	var lastModTime, lastChange time.Time
+	for {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Size() != lastSize || !info.ModTime().Equal(lastModTime) {
+			lastSize, lastModTime, lastChange = info.Size(), info.ModTime(), time.Now()
+		} else if time.Since(lastChange) >= quietPeriod {
+			return nil
+		}
+
+		if err := Sleep(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package asyncigo
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// fragmentHeaderSize is the size of the header prepended to each datagram by [Fragment]:
+// a 32-bit message ID followed by the 16-bit index of the fragment and the 16-bit fragment count.
+const fragmentHeaderSize = 8
+
+// Fragment splits payload into datagrams of at most mtu bytes, for sending over a datagram transport
+// such as UDP. Each datagram is prefixed with a small header identifying the message
+// and the fragment's position within it, so that the payload can be put back together
+// on the receiving end using [Reassemble], even if the datagrams arrive out of order.
+// Message IDs should not be reused while fragments of an earlier message with the same ID may be in flight.
+//
+// Returns an error if mtu is too small to hold any data, or if the payload would need more than 65535 fragments.
+func Fragment(msgID uint32, payload []byte, mtu int) (Iterator[[]byte], error) {
+	chunkSize := mtu - fragmentHeaderSize
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("mtu must be larger than the %d byte fragment header, got %d", fragmentHeaderSize, mtu)
+	}
+	count := max(1, (len(payload)+chunkSize-1)/chunkSize)
+	if count > math.MaxUint16 {
+		return nil, fmt.Errorf("payload of %d bytes needs %d fragments, more than the maximum of %d", len(payload), count, math.MaxUint16)
+	}
+
+	return func(yield func([]byte) bool) {
+		for i := range count {
+			chunk := payload[min(i*chunkSize, len(payload)):min((i+1)*chunkSize, len(payload))]
+			datagram := make([]byte, fragmentHeaderSize, fragmentHeaderSize+len(chunk))
+			binary.BigEndian.PutUint32(datagram[0:], msgID)
+			binary.BigEndian.PutUint16(datagram[4:], uint16(i))
+			binary.BigEndian.PutUint16(datagram[6:], uint16(count))
+			if !yield(append(datagram, chunk...)) {
+				return
+			}
+		}
+	}, nil
+}
+
+// Message is a payload reassembled from datagrams produced by [Fragment].
+type Message struct {
+	ID      uint32
+	Payload []byte
+}
+
+// Reassembler puts messages split up by [Fragment] back together.
+// Fragments may arrive in any order, and duplicates are ignored;
+// messages that aren't completed within the reassembler's timeout are discarded.
+// Reassembler is not threadsafe.
+type Reassembler struct {
+	timeout time.Duration
+	pending map[uint32]*partialMessage
+	expired int
+}
+
+// partialMessage holds the fragments received so far for a message being reassembled.
+type partialMessage struct {
+	fragments [][]byte
+	received  int
+	timer     *Callback
+}
+
+// NewReassembler constructs a new [Reassembler] that discards messages
+// whose fragments haven't all arrived within the given timeout of the first one.
+func NewReassembler(timeout time.Duration) *Reassembler {
+	return &Reassembler{
+		timeout: timeout,
+		pending: make(map[uint32]*partialMessage),
+	}
+}
+
+// Add adds a received datagram to the reassembler, returning the message it belongs to
+// if this was the last missing fragment. The datagram is copied, so the caller may reuse it.
+// Returns [ErrMalformedFragment] if the datagram wasn't produced by [Fragment].
+func (r *Reassembler) Add(ctx context.Context, datagram []byte) (msg Message, ok bool, err error) {
+	if len(datagram) < fragmentHeaderSize {
+		return Message{}, false, ErrMalformedFragment
+	}
+	id := binary.BigEndian.Uint32(datagram[0:])
+	index := int(binary.BigEndian.Uint16(datagram[4:]))
+	count := int(binary.BigEndian.Uint16(datagram[6:]))
+	if index >= count {
+		return Message{}, false, ErrMalformedFragment
+	}
+
+	partial := r.pending[id]
+	if partial == nil {
+		partial = &partialMessage{fragments: make([][]byte, count)}
+		partial.timer = RunningLoop(ctx).ScheduleCallback(r.timeout, func() {
+			delete(r.pending, id)
+			r.expired++
+		})
+		r.pending[id] = partial
+	} else if len(partial.fragments) != count {
+		return Message{}, false, ErrMalformedFragment
+	}
+
+	if partial.fragments[index] != nil {
+		return Message{}, false, nil
+	}
+	partial.fragments[index] = slices.Clone(datagram[fragmentHeaderSize:])
+	partial.received++
+	if partial.received < count {
+		return Message{}, false, nil
+	}
+
+	partial.timer.Cancel()
+	delete(r.pending, id)
+	return Message{ID: id, Payload: slices.Concat(partial.fragments...)}, true, nil
+}
+
+// Pending returns the number of messages that are still missing fragments.
+func (r *Reassembler) Pending() int {
+	return len(r.pending)
+}
+
+// Expired returns the number of messages discarded so far because they weren't completed in time.
+func (r *Reassembler) Expired() int {
+	return r.expired
+}
+
+// Close discards all incomplete messages.
+func (r *Reassembler) Close() {
+	for id, partial := range r.pending {
+		partial.timer.Cancel()
+		delete(r.pending, id)
+	}
+}
+
+// Reassemble returns an [AsyncIterable] yielding the messages reassembled from the given datagrams
+// as each one is completed. Malformed datagrams are skipped, and incomplete messages are discarded
+// once the given timeout has passed since their first fragment arrived. See [Reassembler].
+func Reassemble(ctx context.Context, datagrams AsyncIterable[[]byte], timeout time.Duration) AsyncIterable[Message] {
+	return AsyncIter(func(yield func(Message) error) error {
+		r := NewReassembler(timeout)
+		defer r.Close()
+
+		for datagram, err := range datagrams {
+			if err != nil {
+				return err
+			}
+			if msg, ok, err := r.Add(ctx, datagram); ok && err == nil {
+				if err := yield(msg); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
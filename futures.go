@@ -3,11 +3,14 @@ package asyncigo
 import (
 	"context"
 	"errors"
+	"fmt"
 	"iter"
-)
-
-var (
-	ErrNotReady = errors.New("future is still pending")
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
 )
 
 // Coroutine1 is a coroutine that can return an error.
@@ -40,10 +43,19 @@ type Futurer interface {
 	// AddDoneCallback registers a type-unaware callback to run once this Futurer
 	// completes or is cancelled. If called when the Futurer has already completed,
 	// the callback will be run immediately.
-	AddDoneCallback(callback func(error)) Futurer
-	// Cancel cancels this Futurer. If err is nil, the Futurer
-	// will be canceled with [context.Canceled]. If the Futurer
-	// has already completed, this has no effect.
+	// The returned handle can be passed to [Futurer.RemoveCallback] to unregister the callback.
+	AddDoneCallback(callback func(error)) *CallbackHandle
+	// AddCompletionCallback is like [Futurer.AddDoneCallback], but the callback also receives
+	// metadata about the completion, such as which task completed the Futurer.
+	// The context is used to look up the running [EventLoop].
+	AddCompletionCallback(ctx context.Context, callback func(Completion)) *CallbackHandle
+	// RemoveCallback unregisters a callback previously registered on this Futurer,
+	// so that it won't be run once the Futurer completes. Reports whether the callback was removed;
+	// false is returned if the Futurer has already completed, or if the callback belongs to another Futurer.
+	RemoveCallback(handle *CallbackHandle) bool
+	// Cancel cancels this Futurer, completing it with a [CancelledError]
+	// with err as its cause. If err is nil, the cause will be [context.Canceled].
+	// If the Futurer has already completed, this has no effect.
 	Cancel(err error)
 }
 
@@ -51,6 +63,24 @@ type Futurer interface {
 type tasker interface {
 	Futurer
 	yield(ctx context.Context, fut Futurer) error
+	describe() string
+	info() TaskInfo
+	awaiter() tasker
+	waitingOn() Futurer
+	resultFuture() Futurer
+	spawnedAt() string
+}
+
+// Completion describes how a [Futurer] completed.
+type Completion struct {
+	// Err is the error the Futurer completed with, if any.
+	Err error
+	// Task describes the task that completed the Futurer, either by returning or by resolving it
+	// from its coroutine. Task is nil if the Futurer was completed from outside of a task,
+	// e.g. by a callback scheduled on the loop.
+	Task *TaskInfo
+	// Time is the time at which the Futurer completed.
+	Time time.Time
 }
 
 // Awaitable is a type that holds the result of an operation
@@ -69,9 +99,15 @@ type Awaitable[T any] interface {
 	// and the Awaitable will be cancelled as well.
 	// See the [Awaitable.Shield] method if you do not want the Awaitable to be cancelled.
 	Await(ctx context.Context) (T, error)
-	// MustAwait is the same as [Awaitable.Await], but it panics if Await
-	// returns an error.
+	// MustAwait is the same as [Awaitable.Await], but it panics with an [*AwaitPanic]
+	// if Await returns an error.
 	MustAwait(ctx context.Context) T
+	// AwaitTimeout is the same as [Awaitable.Await], but cancels the Awaitable with [ErrTimeout]
+	// if it has not completed within the given duration.
+	AwaitTimeout(ctx context.Context, timeout time.Duration) (T, error)
+	// AwaitDeadline is the same as [Awaitable.AwaitTimeout], but takes a point in time
+	// rather than a duration.
+	AwaitDeadline(ctx context.Context, deadline time.Time) (T, error)
 	// Shield returns a new [Future] which completes once this Awaitable completes,
 	// but which will not cancel this Awaitable if cancelled.
 	// Allows for awaiting an Awaitable from a [Task] without cancelling
@@ -80,7 +116,8 @@ type Awaitable[T any] interface {
 	// AddResultCallback registers a type-aware callback to run once this Awaitable
 	// completes or is cancelled. If called when the Awaitable has already completed,
 	// the callback will be run immediately.
-	AddResultCallback(callback func(result T, err error)) Awaitable[T]
+	// The returned handle can be passed to [Futurer.RemoveCallback] to unregister the callback.
+	AddResultCallback(callback func(result T, err error)) *CallbackHandle
 	// WriteResultTo registers a pointer to write the result
 	// of this Awaitable to if it completes with no error.
 	//
@@ -91,7 +128,7 @@ type Awaitable[T any] interface {
 	// of this Awaitable. Returns itself if the Awaitable is a Future.
 	Future() *Future[T]
 	// Result returns the result of this Awaitable.
-	// If this Awaitable has not yet completed, [ErrNotReady] will be returned.
+	// If this Awaitable has not yet completed, [ErrFuturePending] will be returned.
 	Result() (T, error)
 }
 
@@ -99,10 +136,30 @@ type Awaitable[T any] interface {
 // It will run any callbacks registered using [Futurer.AddDoneCallback] or [Awaitable.AddResultCallback]
 // once populated with a result using either [Future.SetResult] or [Futurer.Cancel].
 type Future[ResType any] struct {
+	name      string
 	done      bool
 	result    ResType
 	err       error
-	callbacks []func(ResType, error)
+	callbacks []futureCallback[ResType]
+
+	// loop is set once a completion callback has been registered,
+	// signalling that the completion should be recorded
+	loop       *EventLoop
+	completion Completion
+	// affinity is set if the Future has been awaited while affinity checks were enabled
+	affinity *EventLoop
+}
+
+// CallbackHandle identifies a callback registered on a [Futurer],
+// allowing it to be unregistered using [Futurer.RemoveCallback].
+type CallbackHandle struct {
+	// ensure that every handle has a distinct address
+	_ byte
+}
+
+type futureCallback[ResType any] struct {
+	handle   *CallbackHandle
+	callback func(ResType, error)
 }
 
 // NewFuture returns a new [Future] instance ready to be awaited
@@ -111,6 +168,18 @@ func NewFuture[ResType any]() *Future[ResType] {
 	return &Future[ResType]{}
 }
 
+// WithName assigns a name to this Future, used to identify it in diagnostics
+// such as the [AwaitPanic] raised by [Awaitable.MustAwait]. Returns the Future itself.
+func (f *Future[ResType]) WithName(name string) *Future[ResType] {
+	f.name = name
+	return f
+}
+
+// Name returns the name assigned using [Future.WithName].
+func (f *Future[ResType]) Name() string {
+	return f.name
+}
+
 // HasResult implements [Futurer].
 func (f *Future[ResType]) HasResult() bool {
 	return f.done
@@ -128,7 +197,7 @@ func (f *Future[ResType]) Result() (ResType, error) {
 	}
 
 	var zero ResType
-	return zero, ErrNotReady
+	return zero, ErrFuturePending
 }
 
 // Future implements [Awaitable].
@@ -137,33 +206,72 @@ func (f *Future[ResType]) Future() *Future[ResType] {
 }
 
 // AddDoneCallback implements [Futurer].
-func (f *Future[ResType]) AddDoneCallback(callback func(error)) Futurer {
-	f.AddResultCallback(func(_ ResType, err error) {
+func (f *Future[ResType]) AddDoneCallback(callback func(error)) *CallbackHandle {
+	return f.AddResultCallback(func(_ ResType, err error) {
 		callback(err)
 	})
-	return f
+}
+
+// AddCompletionCallback implements [Futurer].
+// If the Future had already completed before any completion callback was registered,
+// the callback will only be passed the error.
+func (f *Future[ResType]) AddCompletionCallback(ctx context.Context, callback func(Completion)) *CallbackHandle {
+	if f.HasResult() {
+		completion := f.completion
+		completion.Err = f.err
+		callback(completion)
+		return &CallbackHandle{}
+	}
+
+	f.loop = RunningLoop(ctx)
+	return f.addCallback(func(ResType, error) {
+		callback(f.completion)
+	})
 }
 
 // AddResultCallback implements [Awaitable].
-func (f *Future[ResType]) AddResultCallback(callback func(ResType, error)) Awaitable[ResType] {
+func (f *Future[ResType]) AddResultCallback(callback func(ResType, error)) *CallbackHandle {
 	if f.HasResult() {
 		callback(f.result, f.err)
-	} else {
-		f.callbacks = append(f.callbacks, callback)
+		return &CallbackHandle{}
 	}
-	return f
+	return f.addCallback(callback)
+}
+
+func (f *Future[ResType]) addCallback(callback func(ResType, error)) *CallbackHandle {
+	handle := &CallbackHandle{}
+	f.callbacks = append(f.callbacks, futureCallback[ResType]{handle: handle, callback: callback})
+	return handle
+}
+
+// RemoveCallback implements [Futurer].
+func (f *Future[ResType]) RemoveCallback(handle *CallbackHandle) bool {
+	i := slices.IndexFunc(f.callbacks, func(cb futureCallback[ResType]) bool {
+		return cb.handle == handle
+	})
+	if i < 0 {
+		return false
+	}
+	f.callbacks = slices.Delete(f.callbacks, i, i+1)
+	return true
 }
 
 // WriteResultTo implements [Awaitable].
 func (f *Future[ResType]) WriteResultTo(dest *ResType) Awaitable[ResType] {
-	return f.AddResultCallback(func(result ResType, err error) {
+	f.AddResultCallback(func(result ResType, err error) {
 		*dest = result
 	})
+	return f
 }
 
 // Await implements [Awaitable].
 func (f *Future[ResType]) Await(ctx context.Context) (ResType, error) {
-	if err := RunningLoop(ctx).Yield(ctx, f); err != nil {
+	loop := RunningLoop(ctx)
+	if loop.affinityChecks.Load() {
+		loop.checkAffinity("Future.Await")
+		f.affinity = loop
+	}
+	if err := loop.Yield(ctx, f); err != nil {
 		var zero ResType
 		return zero, err
 	}
@@ -174,18 +282,29 @@ func (f *Future[ResType]) Await(ctx context.Context) (ResType, error) {
 func (f *Future[ResType]) MustAwait(ctx context.Context) ResType {
 	res, err := f.Await(ctx)
 	if err != nil {
-		panic(err)
+		panic(newAwaitPanic(ctx, f.name, err))
 	}
 	return res
 }
 
+// AwaitTimeout implements [Awaitable].
+func (f *Future[ResType]) AwaitTimeout(ctx context.Context, timeout time.Duration) (ResType, error) {
+	handle := RunningLoop(ctx).ScheduleCallback(timeout, func() {
+		f.Cancel(ErrTimeout)
+	})
+	defer handle.Cancel()
+	return f.Await(ctx)
+}
+
+// AwaitDeadline implements [Awaitable].
+func (f *Future[ResType]) AwaitDeadline(ctx context.Context, deadline time.Time) (ResType, error) {
+	return f.AwaitTimeout(ctx, time.Until(deadline))
+}
+
 // Cancel implements [Futurer].
 func (f *Future[ResType]) Cancel(err error) {
-	if err == nil {
-		err = context.Canceled
-	}
 	var zero ResType
-	f.SetResult(zero, err)
+	f.SetResult(zero, newCancelledError(err))
 }
 
 // Shield implements [Awaitable].
@@ -199,7 +318,8 @@ func (f *Future[ResType]) Shield() *Future[ResType] {
 		fut.SetResult(result, err)
 	})
 	fut.AddResultCallback(func(result ResType, err error) {
-		if !errors.Is(err, context.Canceled) {
+		var cerr *CancelledError
+		if !errors.As(err, &cerr) {
 			f.SetResult(result, err)
 		}
 	})
@@ -211,22 +331,69 @@ func (f *Future[ResType]) Shield() *Future[ResType] {
 // will be propagated to any registered callbacks
 // and returned from any future calls to [Awaitable.Result].
 func (f *Future[ResType]) SetResult(result ResType, err error) {
+	if f.affinity != nil {
+		f.affinity.checkAffinity("Future.SetResult")
+	}
 	if f.HasResult() {
 		return
 	}
 
 	f.result, f.err = result, err
 	f.done = true
+	if f.loop != nil {
+		f.completion = Completion{Err: err, Time: time.Now()}
+		if len(f.loop.currentTasks) > 0 {
+			info := f.loop.currentTask().info()
+			f.completion.Task = &info
+		}
+	}
 
-	for _, callback := range f.callbacks {
-		callback(result, err)
+	// detach the callbacks so they can be released,
+	// and so that callbacks removing other callbacks can't disturb the iteration
+	callbacks := f.callbacks
+	f.callbacks = nil
+	for _, cb := range callbacks {
+		cb.callback(result, err)
 	}
 }
 
+// ThreadsafeFuture is a [Future] that can be completed from any goroutine,
+// e.g. from callbacks invoked by C libraries or other goroutine-based APIs.
+// The result is handed over to the loop using [EventLoop.RunCallbackThreadsafe],
+// so awaiters only observe it once the loop has processed it.
+//
+// Only SetResult and Cancel are threadsafe; all other methods must be called from the event loop.
+type ThreadsafeFuture[ResType any] struct {
+	*Future[ResType]
+	loop *EventLoop
+}
+
+// NewThreadsafeFuture returns a new [ThreadsafeFuture] that is completed on the given loop.
+func NewThreadsafeFuture[ResType any](loop *EventLoop) *ThreadsafeFuture[ResType] {
+	return &ThreadsafeFuture[ResType]{Future: NewFuture[ResType](), loop: loop}
+}
+
+// SetResult populates the Future with a result. SetResult is threadsafe.
+// See [Future.SetResult].
+func (f *ThreadsafeFuture[ResType]) SetResult(result ResType, err error) {
+	f.loop.RunCallbackThreadsafe(context.Background(), func() {
+		f.Future.SetResult(result, err)
+	})
+}
+
+// Cancel cancels the Future. Cancel is threadsafe.
+// See [Future.Cancel].
+func (f *ThreadsafeFuture[ResType]) Cancel(err error) {
+	f.loop.RunCallbackThreadsafe(context.Background(), func() {
+		f.Future.Cancel(err)
+	})
+}
+
 // Task is responsible for driving a coroutine, intercepting any [Awaitable] instances
 // awaited from the coroutine and advancing the coroutine once the pending Awaitable completes.
 type Task[RetType any] struct {
 	loop    *EventLoop
+	id      uint64
 	yielder func(Futurer) bool
 
 	next       func() (Futurer, bool)
@@ -235,29 +402,75 @@ type Task[RetType any] struct {
 	cancel     context.CancelCauseFunc
 	pendingFut Futurer
 	resultFut  *Future[RetType]
+	// awaitedBy is the task that most recently awaited this task, if any
+	awaitedBy tasker
+	// if cancelOnAbandon is set, awaiters holds the number of pending calls to Await
+	cancelOnAbandon bool
+	awaiters        int
+	// spawnStack holds the program counters of the callers of SpawnTask,
+	// and is only captured while task tracking is enabled
+	spawnStack [4]uintptr
+	// observed is set once anyone has shown an interest in the task's result
+	observed bool
+	// unobserved is set if the task failed before anyone observed it
+	unobserved *unobservedError
+	// cancelRequested is set by CancelGraceful if the task yielded without awaiting anything
+	cancelRequested bool
+	// finalizers are run if the task has to be cancelled forcibly by CancelGraceful
+	finalizers []func()
 }
 
 // SpawnTask starts the given coroutine as a background task.
 func SpawnTask[RetType any](ctx context.Context, coro Coroutine2[RetType]) *Task[RetType] {
 	ctx, cancel := context.WithCancelCause(ctx)
+	loop := RunningLoop(ctx)
+	loop.lastTaskID++
 	task := &Task[RetType]{
-		loop:      RunningLoop(ctx),
+		loop:      loop,
+		id:        loop.lastTaskID,
 		resultFut: NewFuture[RetType](),
 		ctx:       ctx,
 		cancel:    cancel,
 	}
+	loop.log(ctx, slog.LevelDebug, "task spawned", func() []slog.Attr {
+		return []slog.Attr{slog.Uint64("task", task.id)}
+	})
+	for _, hook := range loop.taskSpawnHooks {
+		hook(task.info())
+	}
+	loop.setOwner(task.resultFut, task)
+	loop.liveTasks++
+	if loop.tasks != nil {
+		runtime.Callers(2, task.spawnStack[:])
+		loop.tasks[task] = struct{}{}
+	}
 
 	// this is where the magic happens; the entirety of the library
 	// is predicated on this iter.Pull call
 	next, stop := iter.Pull(func(yield func(Futurer) bool) {
 		task.yielder = yield
-		task.resultFut.SetResult(coro(ctx))
+		loop.enterGoroutine()
+		task.resultFut.SetResult(callRecovering(ctx, coro))
 	})
 	task.resultFut.AddDoneCallback(func(err error) {
+		// if the task completed while suspended, it must have been cancelled,
+		// so propagate the cancellation to whatever it is waiting for
 		if task.pendingFut != nil {
-			task.pendingFut.Cancel(nil)
+			task.pendingFut.Cancel(err)
 		}
 		task.cancel(err)
+		loop.liveTasks--
+		delete(loop.tasks, task)
+		var cerr *CancelledError
+		if err != nil && !task.observed && !errors.As(err, &cerr) {
+			task.unobserved = newUnobservedError(loop, task.info(), err)
+		}
+		loop.log(ctx, slog.LevelDebug, "task done", func() []slog.Attr {
+			return []slog.Attr{slog.Uint64("task", task.id), slog.Any("error", err)}
+		})
+		for _, hook := range loop.taskDoneHooks {
+			hook(task.info(), err)
+		}
 	})
 	task.next = next
 	task.stop = stop
@@ -280,6 +493,17 @@ func SpawnTask[RetType any](ctx context.Context, coro Coroutine2[RetType]) *Task
 	return task
 }
 
+// callRecovering calls coro, converting any panic into a [*PanicError]
+// so that a panicking coroutine fails its task instead of bringing down the loop.
+func callRecovering[RetType any](ctx context.Context, coro Coroutine2[RetType]) (result RetType, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return coro(ctx)
+}
+
 // step advances the coroutine until its subsequent call to
 // [Awaitable.Await] or [EventLoop.Yield].
 func (t *Task[_]) step() (ok bool) {
@@ -329,18 +553,28 @@ func (t *Task[_]) yield(childCtx context.Context, fut Futurer) error {
 	}
 
 	// cancel the task if the caller context has been cancelled
-	if err := childCtx.Err(); err != nil {
+	if err := context.Cause(childCtx); err != nil {
 		if fut != nil {
 			fut.Cancel(err)
 		}
 		return t.Err()
 	}
 
+	if cycle := t.loop.findWaitCycle(t, fut); cycle != nil {
+		err := &DeadlockError{Cycle: cycle}
+		t.loop.log(childCtx, slog.LevelWarn, "deadlock detected", func() []slog.Attr {
+			return []slog.Attr{slog.Any("cycle", cycle)}
+		})
+		return err
+	}
+	t.loop.auditWait(childCtx, fut)
+
 	// suspend the coroutine, passing the future to Task.step
 	if !t.yielder(fut) {
-		t.resultFut.Cancel(nil)
+		t.resultFut.Cancel(ErrTaskCancelled)
 		return t.Err()
 	}
+	t.loop.enterGoroutine()
 
 	// check again if the contexts were cancelled while
 	// the coroutine was suspended
@@ -350,10 +584,15 @@ func (t *Task[_]) yield(childCtx context.Context, fut Futurer) error {
 		t.resultFut.Cancel(err)
 		return t.Err()
 	}
-	if err := childCtx.Err(); err != nil {
+	if err := context.Cause(childCtx); err != nil {
 		t.resultFut.Cancel(err)
 		return t.Err()
 	}
+	// deliver a graceful cancellation request that had nothing to cancel
+	if t.cancelRequested {
+		t.cancelRequested = false
+		return newCancelledError(ErrTaskCancelled)
+	}
 	return nil
 }
 
@@ -364,53 +603,276 @@ func (t *Task[_]) HasResult() bool {
 
 // Result implements [Awaitable].
 func (t *Task[RetType]) Result() (RetType, error) {
+	t.observe()
 	return t.resultFut.Result()
 }
 
 // Err implements [Futurer].
 func (t *Task[_]) Err() error {
+	t.observe()
 	return t.resultFut.Err()
 }
 
+// WithName assigns a name to this Task, used to identify it in diagnostics
+// such as [EventLoop.AllTasks]. Returns the Task itself. See [Future.WithName].
+func (t *Task[RetType]) WithName(name string) *Task[RetType] {
+	t.resultFut.WithName(name)
+	return t
+}
+
 // Future implements [Awaitable].
 func (t *Task[RetType]) Future() *Future[RetType] {
+	t.observe()
 	return t.resultFut
 }
 
 // Await implements [Awaitable].
 func (t *Task[RetType]) Await(ctx context.Context) (RetType, error) {
-	return t.resultFut.Await(ctx)
+	t.observe()
+	if loop, ok := RunningLoopMaybe(ctx); ok && len(loop.currentTasks) > 0 {
+		t.awaitedBy = loop.currentTask()
+	}
+	if !t.cancelOnAbandon || t.HasResult() {
+		return t.resultFut.Await(ctx)
+	}
+
+	fut := t.resultFut.Shield()
+	t.awaiters++
+	fut.AddResultCallback(func(_ RetType, err error) {
+		t.awaiters--
+		var cerr *CancelledError
+		if t.awaiters == 0 && errors.As(err, &cerr) {
+			t.Cancel(cerr.Cause)
+		}
+	})
+	return fut.Await(ctx)
+}
+
+// Join waits for the task to complete and returns its result, like [Task.Await],
+// but without cancelling the task if the wait is interrupted.
+//
+// If the task itself was cancelled, Join returns the task's [CancelledError].
+// If instead the waiting coroutine is cancelled, Join returns the cause of that cancellation,
+// and the task keeps running; check [Task.HasResult] to tell the two apart.
+func (t *Task[RetType]) Join(ctx context.Context) (RetType, error) {
+	t.observe()
+	return t.resultFut.Shield().Await(ctx)
+}
+
+// ResultChan returns a channel that receives the task's [Result] once it completes,
+// so that code running outside the loop, e.g. in a plain goroutine,
+// can wait for the task using a select statement alongside other channels.
+// The channel is buffered and receives exactly one value; it is never closed.
+// ResultChan itself must be called from the event loop.
+func (t *Task[RetType]) ResultChan() <-chan Result[RetType] {
+	ch := make(chan Result[RetType], 1)
+	t.AddResultCallback(func(result RetType, err error) {
+		ch <- Result[RetType]{Value: result, Err: err}
+	})
+	return ch
+}
+
+// CancelOnAbandon changes how cancelling a call to [Task.Await] affects the task.
+// By default, cancelling any awaiter cancels the task, even if other coroutines are still awaiting it.
+// With CancelOnAbandon, the task keeps track of its pending awaiters,
+// and is only cancelled once the last of them has been cancelled and nobody wants its result anymore.
+//
+// Only calls to Await and the methods based on it are counted;
+// e.g. [Task.Shield] and [Futurer.AddDoneCallback] do not keep the task alive.
+func (t *Task[RetType]) CancelOnAbandon() *Task[RetType] {
+	t.cancelOnAbandon = true
+	return t
 }
 
 // MustAwait implements [Awaitable].
 func (t *Task[RetType]) MustAwait(ctx context.Context) RetType {
-	return t.resultFut.MustAwait(ctx)
+	res, err := t.Await(ctx)
+	if err != nil {
+		panic(newAwaitPanic(ctx, t.describe(), err))
+	}
+	return res
+}
+
+// AwaitTimeout implements [Awaitable].
+func (t *Task[RetType]) AwaitTimeout(ctx context.Context, timeout time.Duration) (RetType, error) {
+	handle := RunningLoop(ctx).ScheduleCallback(timeout, func() {
+		t.Cancel(ErrTimeout)
+	})
+	defer handle.Cancel()
+	return t.Await(ctx)
+}
+
+// AwaitDeadline implements [Awaitable].
+func (t *Task[RetType]) AwaitDeadline(ctx context.Context, deadline time.Time) (RetType, error) {
+	return t.AwaitTimeout(ctx, time.Until(deadline))
+}
+
+// observe marks the task's result as observed, so that its error isn't reported to the loop's error handler.
+func (t *Task[_]) observe() {
+	t.observed = true
+	if t.unobserved != nil {
+		t.unobserved.observed = true
+	}
+}
+
+// unobservedError is an error that a task failed with before anyone observed it.
+// It is only referenced by its task, and reports the error once the task has been garbage collected
+// without having been observed in the meantime. See [EventLoop.SetErrorHandler].
+type unobservedError struct {
+	loop     *EventLoop
+	info     TaskInfo
+	err      error
+	observed bool
+}
+
+func newUnobservedError(loop *EventLoop, info TaskInfo, err error) *unobservedError {
+	u := &unobservedError{loop: loop, info: info, err: err}
+	runtime.SetFinalizer(u, func(u *unobservedError) {
+		// finalizers run on a separate goroutine, so hand the error over to the loop,
+		// which is also the only place observed is written
+		u.loop.tryRunCallbackThreadsafe(func() {
+			if !u.observed {
+				u.loop.handleError(u.info.Context, u.info, u.err)
+			}
+		})
+	})
+	return u
+}
+
+func (t *Task[_]) describe() string {
+	if t.resultFut.name != "" {
+		return t.resultFut.name
+	}
+	return fmt.Sprintf("task %d", t.id)
+}
+
+func (t *Task[_]) info() TaskInfo {
+	return TaskInfo{ID: t.id, Name: t.describe(), Context: t.ctx}
+}
+
+func (t *Task[_]) awaiter() tasker {
+	return t.awaitedBy
+}
+
+func (t *Task[_]) waitingOn() Futurer {
+	return t.pendingFut
+}
+
+func (t *Task[_]) resultFuture() Futurer {
+	return t.resultFut
+}
+
+// spawnedAt returns the location SpawnTask was called from,
+// skipping any SpawnTask convenience wrappers.
+func (t *Task[_]) spawnedAt() string {
+	pcs := t.spawnStack[:]
+	if n := slices.Index(pcs, 0); n >= 0 {
+		pcs = pcs[:n]
+	}
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		if !strings.HasSuffix(frame.Function, ".SpawnTask") {
+			return fmt.Sprintf("%s:%d (%s)", frame.File, frame.Line, frame.Function)
+		} else if !more {
+			return ""
+		}
+	}
 }
 
 // Shield implements [Awaitable].
 func (t *Task[RetType]) Shield() *Future[RetType] {
+	t.observe()
 	return t.resultFut.Shield()
 }
 
 // WriteResultTo implements [Awaitable].
 func (t *Task[RetType]) WriteResultTo(dst *RetType) Awaitable[RetType] {
+	t.observe()
 	t.resultFut.WriteResultTo(dst)
 	return t
 }
 
 // Cancel implements [Futurer].
+// If err is nil, the task will be cancelled with [ErrTaskCancelled].
 func (t *Task[_]) Cancel(err error) {
+	if err == nil {
+		err = ErrTaskCancelled
+	}
 	t.resultFut.Cancel(err)
 }
 
-// AddResultCallback implements [Awaitable].
-func (t *Task[RetType]) AddResultCallback(callback func(result RetType, err error)) Awaitable[RetType] {
-	t.resultFut.AddResultCallback(callback)
+// CancelGraceful asks the task to stop, giving it up to the given grace period to clean up
+// before cancelling it outright like [Task.Cancel] and running any finalizers
+// registered using [Task.WithFinalizer]. Must not be called from the task itself.
+//
+// The awaitable the task is currently waiting for is cancelled with [ErrTaskCancelled],
+// which the task should take as a signal to clean up and return. Unlike with Cancel,
+// the task remains free to await other operations while cleaning up, e.g. to flush buffered data.
+// A task that hasn't started yet is cancelled right away.
+//
+// Returns the task's error once it has completed. If the calling coroutine is cancelled while waiting,
+// the task is cancelled outright with the same cancellation without waiting out the grace period,
+// and the cancellation error is returned. The task is only cancelled with [ErrTimeout] if the grace period runs out.
+func (t *Task[RetType]) CancelGraceful(ctx context.Context, grace time.Duration) error {
+	if t.HasResult() {
+		return t.Err()
+	} else if t.yielder == nil {
+		// not started yet, so there's nothing to clean up
+		t.Cancel(nil)
+		return t.Err()
+	}
+
+	if t.pendingFut != nil {
+		t.pendingFut.Cancel(ErrTaskCancelled)
+	} else {
+		t.cancelRequested = true
+	}
+
+	_, err := t.resultFut.Shield().AwaitTimeout(ctx, grace)
+	if !t.HasResult() {
+		if errors.Is(err, ErrTimeout) {
+			t.Cancel(ErrTimeout)
+		} else {
+			// we were cancelled ourselves before the grace period ran out
+			t.Cancel(err)
+		}
+		for _, finalizer := range t.finalizers {
+			finalizer()
+		}
+		if !errors.Is(err, ErrTimeout) {
+			return err
+		}
+	}
+	return t.Err()
+}
+
+// WithFinalizer registers a function to run if the task has to be cancelled forcibly
+// after outliving the grace period given to [Task.CancelGraceful]. Returns the Task itself.
+func (t *Task[RetType]) WithFinalizer(finalizer func()) *Task[RetType] {
+	t.finalizers = append(t.finalizers, finalizer)
 	return t
 }
 
+// AddResultCallback implements [Awaitable].
+func (t *Task[RetType]) AddResultCallback(callback func(result RetType, err error)) *CallbackHandle {
+	t.observe()
+	return t.resultFut.AddResultCallback(callback)
+}
+
 // AddDoneCallback implements [Futurer].
-func (t *Task[_]) AddDoneCallback(callback func(error)) Futurer {
-	t.resultFut.AddDoneCallback(callback)
-	return t
+func (t *Task[_]) AddDoneCallback(callback func(error)) *CallbackHandle {
+	t.observe()
+	return t.resultFut.AddDoneCallback(callback)
+}
+
+// AddCompletionCallback implements [Futurer].
+func (t *Task[_]) AddCompletionCallback(ctx context.Context, callback func(Completion)) *CallbackHandle {
+	t.observe()
+	return t.resultFut.AddCompletionCallback(ctx, callback)
+}
+
+// RemoveCallback implements [Futurer].
+func (t *Task[_]) RemoveCallback(handle *CallbackHandle) bool {
+	return t.resultFut.RemoveCallback(handle)
 }
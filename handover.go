@@ -0,0 +1,24 @@
+package asyncigo
+
+import (
+	"errors"
+)
+
+// Handover holds the listeners and connections passed between processes
+// by [SendHandover] and [ReceiveHandover].
+type Handover struct {
+	Listeners []*Listener
+	Conns     []*AsyncStream
+}
+
+// Close closes all listeners and connections in the handover.
+func (h *Handover) Close() error {
+	var errs []error
+	for _, l := range h.Listeners {
+		errs = append(errs, l.Close())
+	}
+	for _, c := range h.Conns {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
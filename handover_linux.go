@@ -0,0 +1,9 @@
+//go:build linux
+
+package asyncigo
+
+import "golang.org/x/sys/unix"
+
+// handoverRecvFlags sets close-on-exec on received file descriptors as part of receiving them,
+// so that they can't leak into a child process started concurrently by another thread.
+const handoverRecvFlags = unix.MSG_CMSG_CLOEXEC
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package asyncigo
+
+import (
+	"context"
+)
+
+// SendHandover is not supported on this platform and will always return [ErrNotImplemented].
+func SendHandover(_ context.Context, _ *AsyncStream, _ *Handover) error {
+	return ErrNotImplemented
+}
+
+// ReceiveHandover is not supported on this platform and will always return [ErrNotImplemented].
+func ReceiveHandover(_ context.Context, _ *AsyncStream) (*Handover, error) {
+	return nil, ErrNotImplemented
+}
@@ -0,0 +1,187 @@
+//go:build unix
+
+package asyncigo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxHandoverFds is the maximum number of file descriptors that can be passed in a single message (SCM_MAX_FD).
+const maxHandoverFds = 253
+
+// socketAdopter is implemented by [Poller] implementations
+// that can take over sockets opened elsewhere, e.g. by another process.
+type socketAdopter interface {
+	// AdoptListener takes ownership of the given listening socket.
+	AdoptListener(fd uintptr) (AsyncListener, error)
+	// AdoptConn takes ownership of the given connected socket.
+	AdoptConn(fd uintptr) (AsyncReadWriteCloser, error)
+}
+
+// socketPathOwner is implemented by [AsyncListener] implementations
+// that remove the path of a unix socket when closed.
+type socketPathOwner interface {
+	// disownSocketPath leaves the path in place when the listener is closed,
+	// e.g. because the socket has been handed over to another process.
+	disownSocketPath()
+}
+
+// SendHandover passes the file descriptors of the given listeners and connections
+// to another process over conn, which must be a unix domain socket connection,
+// so that the other process can resume serving them using [ReceiveHandover].
+// This allows for zero-downtime upgrades: the new process takes over the listening sockets
+// without any pending connections being refused in the meantime.
+//
+// The sending process keeps its own copies of the file descriptors, and should close
+// its listeners and connections once SendHandover returns, without writing anything further to them.
+// Closing a handed over listener bound to a unix socket path leaves the path in place for the other process.
+// Data already buffered by a connection's [AsyncStream] is not passed along,
+// so connections should only be handed over between requests.
+// Returns [ErrNotImplemented] if any of the listeners or connections has no file descriptor.
+func SendHandover(ctx context.Context, conn *AsyncStream, h *Handover) error {
+	var fds []int
+	for _, l := range h.Listeners {
+		fder, ok := l.listener.(Fder)
+		if !ok {
+			return ErrNotImplemented
+		}
+		fds = append(fds, int(fder.Fd()))
+	}
+	for _, c := range h.Conns {
+		fder, ok := c.file.(Fder)
+		if !ok {
+			return ErrNotImplemented
+		}
+		fds = append(fds, int(fder.Fd()))
+	}
+	if len(fds) > maxHandoverFds {
+		return fmt.Errorf("cannot hand over more than %d file descriptors, got %d", maxHandoverFds, len(fds))
+	}
+
+	var header [8]byte
+	binary.NativeEndian.PutUint32(header[:4], uint32(len(h.Listeners)))
+	binary.NativeEndian.PutUint32(header[4:], uint32(len(h.Conns)))
+	oob := unix.UnixRights(fds...)
+
+	for {
+		var err error
+		if cerr := conn.Control(func(fd uintptr) {
+			err = unix.Sendmsg(int(fd), header[:], oob, nil, 0)
+		}); cerr != nil {
+			return cerr
+		} else if err == nil {
+			for _, l := range h.Listeners {
+				if owner, ok := l.listener.(socketPathOwner); ok {
+					owner.disownSocketPath()
+				}
+			}
+			return nil
+		} else if !errors.Is(err, syscall.EAGAIN) {
+			return err
+		}
+		if err := conn.file.WaitForReady(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// ReceiveHandover receives listeners and connections passed by another process using [SendHandover]
+// over conn, which must be a unix domain socket connection, and registers them with the running loop.
+// Nothing must have been read from conn before calling ReceiveHandover.
+// Returns [ErrNotImplemented] if the poller in use can't take over sockets;
+// currently only the epoll poller can.
+func ReceiveHandover(ctx context.Context, conn *AsyncStream) (*Handover, error) {
+	loop := RunningLoop(ctx)
+	adopter, ok := loop.poller.(socketAdopter)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+
+	var header [8]byte
+	oob := make([]byte, unix.CmsgSpace(maxHandoverFds*4))
+	var n, oobn int
+	for {
+		var err error
+		if cerr := conn.Control(func(fd uintptr) {
+			n, oobn, _, _, err = unix.Recvmsg(int(fd), header[:], oob, handoverRecvFlags)
+		}); cerr != nil {
+			return nil, cerr
+		} else if err == nil {
+			break
+		} else if !errors.Is(err, syscall.EAGAIN) {
+			return nil, err
+		}
+		if err := conn.file.WaitForReady(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	fds, err := parseUnixRights(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	closeFds := func(fds []int) {
+		for _, fd := range fds {
+			_ = unix.Close(fd)
+		}
+	}
+
+	if n == 0 && len(fds) == 0 {
+		return nil, io.EOF
+	} else if n < len(header) {
+		closeFds(fds)
+		return nil, io.ErrUnexpectedEOF
+	}
+	numListeners := int(binary.NativeEndian.Uint32(header[:4]))
+	numConns := int(binary.NativeEndian.Uint32(header[4:]))
+	if numListeners+numConns != len(fds) {
+		closeFds(fds)
+		return nil, fmt.Errorf("expected %d file descriptors, got %d", numListeners+numConns, len(fds))
+	}
+
+	h := &Handover{}
+	for i, fd := range fds {
+		if i < numListeners {
+			var l AsyncListener
+			if l, err = adopter.AdoptListener(uintptr(fd)); err == nil {
+				h.Listeners = append(h.Listeners, &Listener{loop: loop, listener: l})
+			}
+		} else {
+			var f AsyncReadWriteCloser
+			if f, err = adopter.AdoptConn(uintptr(fd)); err == nil {
+				h.Conns = append(h.Conns, loop.newStream(f, "handover"))
+			}
+		}
+		if err != nil {
+			closeFds(fds[i+1:])
+			_ = h.Close()
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// parseUnixRights extracts the file descriptors from the SCM_RIGHTS control messages in oob.
+func parseUnixRights(oob []byte) ([]int, error) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+
+	var fds []int
+	for _, msg := range msgs {
+		rights, err := unix.ParseUnixRights(&msg)
+		if err != nil {
+			continue
+		}
+		fds = append(fds, rights...)
+	}
+	return fds, nil
+}
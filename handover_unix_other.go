@@ -0,0 +1,6 @@
+//go:build unix && !linux
+
+package asyncigo
+
+// handoverRecvFlags is zero as receiving handovers is only supported on Linux.
+const handoverRecvFlags = 0
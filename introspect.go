@@ -0,0 +1,231 @@
+package asyncigo
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TaskState describes what a task is currently doing. See [EventLoop.AllTasks].
+type TaskState int
+
+const (
+	TaskPending TaskState = iota // scheduled to run on the next tick of the loop
+	TaskRunning                  // currently executing
+	TaskBlocked                  // suspended until an awaitable completes
+)
+
+// String implements [fmt.Stringer].
+func (s TaskState) String() string {
+	switch s {
+	case TaskPending:
+		return "pending"
+	case TaskRunning:
+		return "running"
+	case TaskBlocked:
+		return "blocked"
+	}
+	return fmt.Sprintf("TaskState(%d)", int(s))
+}
+
+// TaskSnapshot describes the state of a live task at the time [EventLoop.AllTasks] was called.
+type TaskSnapshot struct {
+	TaskInfo
+	State TaskState
+	// BlockedOn describes the awaitable the task is waiting for if the task is blocked:
+	// the name of the task or future if it has one, or else its type.
+	BlockedOn string
+	// SpawnedAt is the location [SpawnTask] was called from, as "file:line (function)".
+	SpawnedAt string
+}
+
+// SetTaskTracking enables or disables tracking of live tasks, intended for debugging.
+// While enabled, the loop keeps a registry of the tasks spawned on it along with where they were spawned from,
+// which is used by [EventLoop.AllTasks] and [EventLoop.DumpState] and to count blocked tasks in [EventLoop.Stats].
+//
+// Capturing the location of each spawn adds noticeable overhead to [SpawnTask].
+// Tasks spawned while tracking is disabled are never tracked,
+// so enable it before calling [EventLoop.Run] to track every task.
+// Disabling tracking discards the registry.
+func (e *EventLoop) SetTaskTracking(enabled bool) {
+	if !enabled {
+		e.tasks = nil
+	} else if e.tasks == nil {
+		e.tasks = make(map[tasker]struct{})
+	}
+}
+
+// AllTasks returns a snapshot of every tracked task on this loop that has not yet completed, ordered by ID.
+// This is intended for debugging, e.g. to find out which tasks are stuck and what they are waiting for.
+// Name tasks using [Task.WithName] to make them easier to identify.
+// Returns nil unless [EventLoop.SetTaskTracking] is enabled.
+//
+// AllTasks is not threadsafe, and must be called from the event loop.
+func (e *EventLoop) AllTasks() []TaskSnapshot {
+	if e.tasks == nil {
+		return nil
+	}
+	owners := make(map[Futurer]tasker, len(e.tasks))
+	for t := range e.tasks {
+		owners[t.resultFuture()] = t
+	}
+
+	snapshots := make([]TaskSnapshot, 0, len(e.tasks))
+	for t := range e.tasks {
+		snapshot := TaskSnapshot{TaskInfo: t.info(), SpawnedAt: t.spawnedAt()}
+		if slices.Contains(e.currentTasks, t) {
+			snapshot.State = TaskRunning
+		} else if fut := t.waitingOn(); fut != nil && !fut.HasResult() {
+			snapshot.State = TaskBlocked
+			snapshot.BlockedOn = describeFuture(fut, owners)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	slices.SortFunc(snapshots, func(a, b TaskSnapshot) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return snapshots
+}
+
+// describeFuture identifies fut in diagnostics, using the name of the task
+// responsible for completing it if it is the result of one of the given tasks.
+func describeFuture(fut Futurer, owners map[Futurer]tasker) string {
+	if owner, ok := owners[fut]; ok {
+		return owner.describe()
+	} else if named, ok := fut.(interface{ Name() string }); ok && named.Name() != "" {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", fut)
+}
+
+// LoopStats summarises the state of an [EventLoop]. See [EventLoop.Stats].
+type LoopStats struct {
+	// TasksSpawned is the total number of tasks spawned on the loop.
+	TasksSpawned uint64
+	// Tasks is the number of tasks that have not yet completed.
+	Tasks int
+	// BlockedTasks is the number of tasks suspended until an awaitable completes.
+	// It only counts tasks tracked while [EventLoop.SetTaskTracking] is enabled.
+	BlockedTasks int
+	// Callbacks is the number of scheduled callbacks, including timers.
+	Callbacks int
+	// LockWaits is the number of times a coroutine had to wait for a [Mutex] or [Semaphore],
+	// and LockWaitTime the total time spent waiting.
+	// Both are only tracked while [EventLoop.SetLockMetrics] is enabled.
+	LockWaits    uint64
+	LockWaitTime time.Duration
+}
+
+// Stats returns a summary of the current state of the loop.
+//
+// Stats is not threadsafe, and must be called from the event loop; see [EventLoop.StatsThreadsafe].
+func (e *EventLoop) Stats() LoopStats {
+	stats := LoopStats{
+		TasksSpawned: e.lastTaskID,
+		Tasks:        e.liveTasks,
+		Callbacks:    e.pendingCallbacks.Len(),
+	}
+	for t := range e.tasks {
+		if fut := t.waitingOn(); fut != nil && !fut.HasResult() && !slices.Contains(e.currentTasks, t) {
+			stats.BlockedTasks++
+		}
+	}
+	for _, m := range e.lockMetrics {
+		stats.LockWaits += m.Waits
+		stats.LockWaitTime += m.TotalWait
+	}
+	return stats
+}
+
+// DumpState writes a human-readable description of the loop's statistics and live tasks to w,
+// the latter only if [EventLoop.SetTaskTracking] is enabled,
+// followed by any lock contention recorded by [EventLoop.SetLockMetrics]
+// and any unbounded waits recorded by [EventLoop.SetWaitAudit].
+// The state is captured up front, so w may safely block.
+//
+// DumpState is not threadsafe, and must be called from the event loop; see [EventLoop.DumpStateThreadsafe].
+func (e *EventLoop) DumpState(w io.Writer) error {
+	_, err := io.WriteString(w, e.dumpState())
+	return err
+}
+
+// dumpState formats the state of the loop for [EventLoop.DumpState].
+func (e *EventLoop) dumpState() string {
+	var sb strings.Builder
+	stats := e.Stats()
+	fmt.Fprintf(&sb, "%d tasks (%d blocked, %d spawned in total), %d callbacks scheduled\n",
+		stats.Tasks, stats.BlockedTasks, stats.TasksSpawned, stats.Callbacks)
+	for _, t := range e.AllTasks() {
+		fmt.Fprintf(&sb, "%s: %s", t.Name, t.State)
+		if t.BlockedOn != "" {
+			fmt.Fprintf(&sb, " on %s", t.BlockedOn)
+		}
+		fmt.Fprintf(&sb, ", spawned at %s\n", t.SpawnedAt)
+	}
+	if metrics := e.LockMetrics(); len(metrics) > 0 {
+		fmt.Fprintf(&sb, "%d locks (%d waits, %v spent waiting):\n", len(metrics), stats.LockWaits, stats.LockWaitTime)
+		for _, m := range metrics {
+			fmt.Fprintf(&sb, "%s: %d acquisitions, %d waits, %v total, %v max", m.Name, m.Acquisitions, m.Waits, m.TotalWait, m.MaxWait)
+			if m.Holder != "" {
+				fmt.Fprintf(&sb, ", held by %s", m.Holder)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	if waits := e.UnboundedWaits(); len(waits) > 0 {
+		fmt.Fprintf(&sb, "%d locations waited without a deadline:\n", len(waits))
+		for _, w := range waits {
+			fmt.Fprintf(&sb, "%s: %d waits", w.Location, w.Count)
+			if w.Detached {
+				sb.WriteString(", some not cancellable")
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// StatsThreadsafe is like [EventLoop.Stats], but may be called from any goroutine other than the loop's,
+// e.g. by monitoring agents polling a busy loop.
+// It waits for the loop to take the snapshot, and fails with the context's error
+// if the context is cancelled first, e.g. because the loop isn't running.
+func (e *EventLoop) StatsThreadsafe(ctx context.Context) (LoopStats, error) {
+	return onLoopThreadsafe(ctx, e, e.Stats)
+}
+
+// AllTasksThreadsafe is like [EventLoop.AllTasks], but may be called from any goroutine other than the loop's.
+// See [EventLoop.StatsThreadsafe].
+func (e *EventLoop) AllTasksThreadsafe(ctx context.Context) ([]TaskSnapshot, error) {
+	return onLoopThreadsafe(ctx, e, e.AllTasks)
+}
+
+// DumpStateThreadsafe is like [EventLoop.DumpState], but may be called from any goroutine other than the loop's.
+// The state is written to w from the calling goroutine. See [EventLoop.StatsThreadsafe].
+func (e *EventLoop) DumpStateThreadsafe(ctx context.Context, w io.Writer) error {
+	state, err := onLoopThreadsafe(ctx, e, e.dumpState)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, state)
+	return err
+}
+
+// onLoopThreadsafe runs f on the loop and waits for its result.
+// Calling onLoopThreadsafe from the loop itself would deadlock.
+func onLoopThreadsafe[T any](ctx context.Context, e *EventLoop, f func() T) (T, error) {
+	result := make(chan T, 1)
+	e.RunCallbackThreadsafe(ctx, func() {
+		result <- f()
+	})
+	select {
+	case res := <-result:
+		return res, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
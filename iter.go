@@ -256,6 +256,24 @@ func (ai AsyncIterable[T]) YieldTo(yield func(T) error) error {
 	return nil
 }
 
+// WithCheckpoints returns an AsyncIterable that calls [Checkpoint] after every n values
+// yielded by this AsyncIterable, keeping the event loop responsive
+// while iterating over a source that rarely needs to wait, e.g. one with lots of buffered data.
+func (ai AsyncIterable[T]) WithCheckpoints(ctx context.Context, n int) AsyncIterable[T] {
+	return AsyncIter(func(yield func(T) error) error {
+		var count int
+		return ai.YieldTo(func(v T) error {
+			if err := yield(v); err != nil {
+				return err
+			}
+			if count++; count%max(1, n) == 0 {
+				return Checkpoint(ctx)
+			}
+			return nil
+		})
+	})
+}
+
 // AsyncIter is a helper function for constructing an [AsyncIterable].
 func AsyncIter[T any](f func(yield func(T) error) error) AsyncIterable[T] {
 	return func(yield func(T, error) bool) {
@@ -0,0 +1,203 @@
+package asyncigo
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"syscall"
+	"time"
+)
+
+// AcceptFilter is run for each incoming connection before it is handed over to a handler.
+// Returning an error rejects the connection, which will then be closed.
+// Filters may also await, e.g. to tarpit suspicious clients or to sniff the start of a handshake,
+// but as filters are run as part of accepting connections, this delays the acceptance of subsequent connections.
+type AcceptFilter func(ctx context.Context, stream *AsyncStream, remote net.Addr) error
+
+// AcceptHook is run with the raw file descriptor of each accepted connection
+// before the connection is registered with the poller and wrapped in an [AsyncStream],
+// e.g. to attach eBPF programs or add the socket to a sockmap before any data is read.
+// Returning an error rejects the connection, which will then be closed.
+// The file descriptor must not be closed by the hook, nor used after the hook returns.
+type AcceptHook func(fd uintptr, remote net.Addr) error
+
+// acceptHooker is implemented by [AsyncListener] implementations that support accept hooks.
+type acceptHooker interface {
+	SetAcceptHook(hook AcceptHook)
+}
+
+// ConnHandler handles a single connection accepted by a [Listener].
+type ConnHandler func(ctx context.Context, stream *AsyncStream, remote net.Addr) error
+
+// Listener accepts incoming connections as [AsyncStream] instances.
+type Listener struct {
+	loop     *EventLoop
+	listener AsyncListener
+	filters  []AcceptFilter
+	closed   bool
+	// idleTimeout is the time after which idle connections are closed by Serve, if positive
+	idleTimeout time.Duration
+}
+
+// Listen opens a listening socket on the given address.
+// The supported networks depend on the [Poller] implementation;
+// the epoll poller supports "tcp", "tcp4", "tcp6" and "unix".
+// Returns [ErrLoopClosed] if the loop is not running.
+func (e *EventLoop) Listen(ctx context.Context, network, address string) (*Listener, error) {
+	if e.poller == nil {
+		return nil, ErrLoopClosed
+	}
+
+	l, err := e.poller.Listen(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{loop: e, listener: l}, nil
+}
+
+// Addr returns the address the listener is listening on.
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// AddFilter registers a filter to run for each accepted connection.
+// Filters are run in the order they were added, and the first filter to return an error
+// causes the connection to be rejected.
+func (l *Listener) AddFilter(filter AcceptFilter) {
+	l.filters = append(l.filters, filter)
+}
+
+// SetAcceptHook sets a hook to run for each accepted connection; see [AcceptHook].
+// Unlike filters, the hook runs synchronously as part of accepting the connection, and can't await.
+// Returns [ErrNotImplemented] if the listener doesn't support accept hooks;
+// currently only listeners opened using the epoll poller do.
+func (l *Listener) SetAcceptHook(hook AcceptHook) error {
+	hooker, ok := l.listener.(acceptHooker)
+	if !ok {
+		return ErrNotImplemented
+	}
+	hooker.SetAcceptHook(hook)
+	return nil
+}
+
+// SetIdleTimeout makes [Listener.Serve] close connections on which no data has been
+// read or written for the given duration, cancelling their handlers with [ErrTimeout].
+// The idle timer is reset by any traffic in either direction,
+// unlike a deadline on a single operation such as [Awaitable.AwaitTimeout].
+// A timeout of 0 disables the idle timeout.
+func (l *Listener) SetIdleTimeout(timeout time.Duration) {
+	l.idleTimeout = timeout
+}
+
+// Close stops listening. Any pending calls to [Listener.Accept] will return [net.ErrClosed].
+func (l *Listener) Close() error {
+	l.closed = true
+	return l.listener.Close()
+}
+
+// Accept waits for the next incoming connection that passes all registered filters.
+// Filters are run inline, so a filter that awaits will delay the acceptance of subsequent connections.
+func (l *Listener) Accept(ctx context.Context) (*AsyncStream, net.Addr, error) {
+	for {
+		stream, remote, err := l.accept(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := l.filter(ctx, stream, remote); err != nil {
+			continue
+		}
+		return stream, remote, nil
+	}
+}
+
+// Serve accepts connections until the context is cancelled or the listener is closed,
+// spawning a task running the handler for each connection that passes all registered filters.
+// Filters are run before the task is spawned, so rejected connections cost no more than accepting them.
+// The connection is closed once the handler returns.
+func (l *Listener) Serve(ctx context.Context, handler ConnHandler) error {
+	for {
+		stream, remote, err := l.Accept(ctx)
+		if err != nil {
+			return err
+		}
+
+		var idle bool
+		conn := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			defer stream.Close()
+			if err := handler(ctx, stream, remote); idle {
+				l.loop.log(ctx, slog.LevelDebug, "closed idle connection", func() []slog.Attr {
+					return []slog.Attr{slog.Any("remote", remote)}
+				})
+			} else if err != nil {
+				l.loop.log(ctx, slog.LevelWarn, "connection handler failed", func() []slog.Attr {
+					return []slog.Attr{slog.Any("remote", remote), slog.Any("error", err)}
+				})
+			}
+			return nil, nil
+		})
+		if l.idleTimeout > 0 {
+			l.closeWhenIdle(conn, stream, l.idleTimeout, &idle)
+		}
+	}
+}
+
+// closeWhenIdle cancels the connection task once no data has been transferred
+// on the stream for the given timeout, setting idle when doing so.
+func (l *Listener) closeWhenIdle(conn *Task[any], stream *AsyncStream, timeout time.Duration, idle *bool) {
+	start := time.Now()
+	var handle *Callback
+	var check func()
+	check = func() {
+		lastActive := stream.LastActive()
+		if lastActive.Before(start) {
+			lastActive = start
+		}
+		if remaining := timeout - time.Since(lastActive); remaining > 0 {
+			handle = l.loop.ScheduleCallback(remaining, check)
+			return
+		}
+		*idle = true
+		conn.Cancel(ErrTimeout)
+	}
+	handle = l.loop.ScheduleCallback(timeout, check)
+	conn.AddDoneCallback(func(error) {
+		handle.Cancel()
+	})
+}
+
+func (l *Listener) accept(ctx context.Context) (*AsyncStream, net.Addr, error) {
+	for {
+		if l.closed {
+			return nil, nil, net.ErrClosed
+		}
+
+		conn, remote, err := l.listener.Accept()
+		if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) {
+			if err = l.listener.WaitForReady(ctx); err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			if l.closed {
+				err = net.ErrClosed
+			}
+			return nil, nil, err
+		}
+
+		return l.loop.newStream(conn, "accept", slog.Any("remote", remote)), remote, nil
+	}
+}
+
+// filter runs the registered filters for the given connection,
+// closing the connection if rejected.
+func (l *Listener) filter(ctx context.Context, stream *AsyncStream, remote net.Addr) error {
+	for _, filter := range l.filters {
+		if err := filter(ctx, stream, remote); err != nil {
+			_ = stream.Close()
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package asyncigo
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// LockMetrics describes the contention recorded for a [Mutex] or [Semaphore].
+// See [EventLoop.SetLockMetrics].
+type LockMetrics struct {
+	// Name is the name assigned using [Mutex.WithName] or [Semaphore.WithName],
+	// or else the type and address of the primitive.
+	Name string
+	// Acquisitions is the number of times the primitive was locked or acquired.
+	Acquisitions uint64
+	// Waits is the number of times a coroutine had to wait for the primitive,
+	// including waits that were cancelled or timed out.
+	Waits uint64
+	// TotalWait is the time spent waiting for the primitive, summed over all waits.
+	TotalWait time.Duration
+	// MaxWait is the longest time spent by a single wait.
+	MaxWait time.Duration
+	// Holder is the name of the task currently holding a [Mutex], if any.
+	// It is always empty for a [Semaphore], which may be held by any number of tasks.
+	Holder string
+}
+
+// meteredLock is a synchronisation primitive that can record metrics on its loop.
+type meteredLock interface {
+	// lockHolder returns the task currently holding the lock, or nil if unknown or unlocked.
+	lockHolder() tasker
+	lockName() string
+}
+
+// SetLockMetrics enables or disables contention metrics for the [Mutex] and [Semaphore]
+// instances used on this loop, intended for debugging and tuning.
+// While enabled, every lock and acquisition performed from a coroutine running on the loop is recorded,
+// along with the time spent waiting. Recorded metrics are listed by [EventLoop.LockMetrics],
+// summed up by [EventLoop.Stats], and included in [EventLoop.DumpState].
+//
+// Instrumented primitives are kept alive for as long as metrics are enabled.
+// Disabling the metrics discards everything recorded.
+func (e *EventLoop) SetLockMetrics(enabled bool) {
+	if !enabled {
+		e.lockMetrics = nil
+	} else if e.lockMetrics == nil {
+		e.lockMetrics = make(map[meteredLock]*LockMetrics)
+	}
+}
+
+// LockMetrics returns the metrics recorded since [EventLoop.SetLockMetrics] was enabled,
+// ordered by total wait time so that the most contended primitives come first.
+//
+// LockMetrics is not threadsafe, and must be called from the event loop.
+func (e *EventLoop) LockMetrics() []LockMetrics {
+	metrics := make([]LockMetrics, 0, len(e.lockMetrics))
+	for lock, m := range e.lockMetrics {
+		snapshot := *m
+		snapshot.Name = lock.lockName()
+		if holder := lock.lockHolder(); holder != nil {
+			snapshot.Holder = holder.describe()
+		}
+		metrics = append(metrics, snapshot)
+	}
+	slices.SortFunc(metrics, func(a, b LockMetrics) int {
+		return cmp.Or(cmp.Compare(b.TotalWait, a.TotalWait), cmp.Compare(a.Name, b.Name))
+	})
+	return metrics
+}
+
+// lockMetricsFor returns the metrics for lock, or nil if lock metrics are disabled.
+func (e *EventLoop) lockMetricsFor(lock meteredLock) *LockMetrics {
+	if e.lockMetrics == nil {
+		return nil
+	}
+	m := e.lockMetrics[lock]
+	if m == nil {
+		m = &LockMetrics{}
+		e.lockMetrics[lock] = m
+	}
+	return m
+}
+
+// recordLockAcquired records that lock was locked or acquired, if lock metrics are enabled.
+func (e *EventLoop) recordLockAcquired(lock meteredLock) {
+	if m := e.lockMetricsFor(lock); m != nil {
+		m.Acquisitions++
+	}
+}
+
+// recordLockWait records a wait for lock that started at the given time, if lock metrics are enabled.
+func (e *EventLoop) recordLockWait(lock meteredLock, start time.Time) {
+	if m := e.lockMetricsFor(lock); m != nil {
+		waited := time.Since(start)
+		m.Waits++
+		m.TotalWait += waited
+		m.MaxWait = max(m.MaxWait, waited)
+	}
+}
+
+// describeLock returns name if set, or else identifies lock by its type and address.
+func describeLock(lock meteredLock, name string) string {
+	if name != "" {
+		return name
+	}
+	return fmt.Sprintf("%T@%p", lock, lock)
+}
@@ -3,7 +3,10 @@ package asyncigo
 import (
 	"container/heap"
 	"context"
+	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,9 +31,64 @@ type EventLoop struct {
 	pendingCallbacks    callbackQueue
 	callbacksFromThread chan *Callback
 	callbacksDoneFut    *Future[any]
-
-	poller       Poller
+	idleFut             *Future[any]
+
+	poller Poller
+	// pollerMu guards poller against being replaced or closed while another goroutine wakes up the loop,
+	// along with preStartCallbacks and runs; the loop itself may read them without holding it
+	pollerMu sync.Mutex
+	// preStartCallbacks holds the callbacks scheduled from other goroutines while the loop isn't running,
+	// which unlike callbacksFromThread is unbounded, as nothing drains it until the loop starts
+	preStartCallbacks []*Callback
+	// runs is the number of times the loop has exited
+	runs         uint64
 	currentTasks []tasker
+	lastTaskID   uint64
+	// liveTasks is the number of tasks that have not yet completed
+	liveTasks int
+	// tasks holds all tasks that have not yet completed,
+	// and is only tracked while task tracking is enabled
+	tasks map[tasker]struct{}
+
+	// ctx is the context of the running loop, from which tasks spawned by SpawnThreadsafe are derived
+	ctx context.Context
+	// mainTask is the result of the main task while the loop is running
+	mainTask *Future[any]
+	// stopFut is set while the loop is running using RunForever, and completes once Stop is called
+	stopFut *Future[any]
+
+	logger *slog.Logger
+	reaper *childReaper
+
+	taskSpawnHooks []func(TaskInfo)
+	taskDoneHooks  []func(TaskInfo, error)
+	errorHandler   func(TaskInfo, error)
+
+	// futureOwners maps futures to the task responsible for completing them,
+	// and is only tracked while deadlock detection is enabled
+	futureOwners map[Futurer]tasker
+	// unboundedWaits maps the locations of waits without a deadline to the waits recorded there,
+	// and is only tracked while the wait audit is enabled
+	unboundedWaits map[string]*UnboundedWait
+	// lockMetrics maps instrumented synchronisation primitives to the contention recorded for them,
+	// and is only tracked while lock metrics are enabled
+	lockMetrics map[meteredLock]*LockMetrics
+
+	affinityChecks atomic.Bool
+	// goroutine is the ID of the goroutine currently running on behalf of the loop,
+	// and is only tracked while affinity checks are enabled
+	goroutine atomic.Uint64
+}
+
+// TaskInfo describes a [Task] to the hooks registered using
+// [EventLoop.OnTaskSpawn] and [EventLoop.OnTaskDone].
+type TaskInfo struct {
+	// ID is the sequence number of the task, unique within its loop.
+	ID uint64
+	// Name identifies the task in diagnostics; see [Future.WithName].
+	Name string
+	// Context is the context the task's coroutine runs with.
+	Context context.Context
 }
 
 // NewEventLoop constructs a new [EventLoop].
@@ -40,20 +98,178 @@ func NewEventLoop() *EventLoop {
 	}
 }
 
+// SetLogger sets the logger used to report the lifecycle events of this loop.
+// Starting and stopping the loop and the creation and completion of tasks and streams
+// are logged at [slog.LevelDebug], while recoverable failures are logged at [slog.LevelWarn].
+// If no logger is set, [slog.Default] is used.
+func (e *EventLoop) SetLogger(logger *slog.Logger) {
+	e.logger = logger
+}
+
+// Logger returns the logger used by this loop. See [EventLoop.SetLogger].
+func (e *EventLoop) Logger() *slog.Logger {
+	if e.logger == nil {
+		return slog.Default()
+	}
+	return e.logger
+}
+
+// OnTaskSpawn registers a hook to run whenever a task is spawned on this loop,
+// before the task's coroutine starts running.
+// Hooks run on the loop's thread in the order they were registered, and must not block.
+//
+// Together with [EventLoop.OnTaskDone], this allows frameworks built on top of the loop
+// to centrally implement e.g. metrics and request counting.
+func (e *EventLoop) OnTaskSpawn(hook func(info TaskInfo)) {
+	e.taskSpawnHooks = append(e.taskSpawnHooks, hook)
+}
+
+// OnTaskDone registers a hook to run whenever a task on this loop completes,
+// with the error the task completed with, if any. See [EventLoop.OnTaskSpawn].
+func (e *EventLoop) OnTaskDone(hook func(info TaskInfo, err error)) {
+	e.taskDoneHooks = append(e.taskDoneHooks, hook)
+}
+
+// SetErrorHandler sets the handler invoked when a task fails with an error that nobody observes,
+// i.e. a task that completes with an error other than a cancellation and is then garbage collected
+// without anyone having awaited it, registered a callback on it or otherwise asked for its result.
+// Such errors would otherwise go unnoticed. Observe the task, e.g. by awaiting it
+// or by calling [Task.AddDoneCallback], to handle its error yourself.
+//
+// Since errors are only reported once the task has been garbage collected, they may be reported
+// long after the task failed, and are never reported if the loop has stopped in the meantime.
+//
+// If no handler is set, or the handler is set to nil, unobserved errors are logged at [slog.LevelError].
+func (e *EventLoop) SetErrorHandler(handler func(info TaskInfo, err error)) {
+	e.errorHandler = handler
+}
+
+// handleError reports an error that nobody observed. See [EventLoop.SetErrorHandler].
+func (e *EventLoop) handleError(ctx context.Context, info TaskInfo, err error) {
+	if e.errorHandler != nil {
+		e.errorHandler(info, err)
+		return
+	}
+	e.log(ctx, slog.LevelError, "unhandled task error", func() []slog.Attr {
+		return []slog.Attr{slog.Uint64("task", info.ID), slog.String("name", info.Name), slog.Any("error", err)}
+	})
+}
+
+// SetDeadlockDetection enables or disables deadlock detection, intended for debugging.
+// While enabled, the loop keeps track of which task is responsible for completing
+// each task's result and each locked [Mutex], and of what each task is waiting for.
+// If awaiting would cause a cycle of tasks waiting on each other, the await fails
+// with a [*DeadlockError] describing the cycle instead of hanging forever.
+//
+// Only waits that can be attributed to a task are tracked;
+// e.g. a task waiting on a plain [Future] is never considered to be deadlocked.
+func (e *EventLoop) SetDeadlockDetection(enabled bool) {
+	if !enabled {
+		e.futureOwners = nil
+	} else if e.futureOwners == nil {
+		e.futureOwners = make(map[Futurer]tasker)
+	}
+}
+
+// setOwner records that the given task is responsible for completing fut,
+// if deadlock detection is enabled.
+func (e *EventLoop) setOwner(fut Futurer, owner tasker) {
+	if e.futureOwners == nil || owner == nil {
+		return
+	}
+	e.futureOwners[fut] = owner
+	fut.AddDoneCallback(func(error) {
+		if e.futureOwners != nil && e.futureOwners[fut] == owner {
+			delete(e.futureOwners, fut)
+		}
+	})
+}
+
+// findWaitCycle returns the cycle of tasks that would be formed if t were to wait for fut,
+// or nil if there is no such cycle.
+func (e *EventLoop) findWaitCycle(t tasker, fut Futurer) []string {
+	if e.futureOwners == nil {
+		return nil
+	}
+
+	cycle := []string{t.describe()}
+	seen := map[tasker]bool{t: true}
+	for fut != nil {
+		owner := e.futureOwners[fut]
+		if owner == nil {
+			return nil
+		} else if owner == t {
+			return append(cycle, t.describe())
+		} else if seen[owner] {
+			// a cycle that doesn't involve t, which we'll have reported already
+			return nil
+		}
+		seen[owner] = true
+		cycle = append(cycle, owner.describe())
+		fut = owner.waitingOn()
+	}
+	return nil
+}
+
+// log reports a lifecycle event using the loop's logger.
+// Attributes are only constructed if the level is enabled.
+func (e *EventLoop) log(ctx context.Context, level slog.Level, msg string, attrs func() []slog.Attr) {
+	logger := e.Logger()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	var args []slog.Attr
+	if attrs != nil {
+		args = attrs()
+	}
+	logger.LogAttrs(ctx, level, msg, args...)
+}
+
 // Run starts the event loop with the given coroutine as the main task.
 // The loop will exit once the main task has exited and there are no pending callbacks.
 func (e *EventLoop) Run(ctx context.Context, main Coroutine1) error {
 	ctx, cancel := context.WithCancelCause(ctx)
 	defer cancel(nil)
 
-	var err error
-	if e.poller, err = NewPoller(); err != nil {
+	poller, err := NewPoller()
+	if err != nil {
 		return err
 	}
-	defer e.poller.Close()
+	e.pollerMu.Lock()
+	e.poller = poller
+	for _, callback := range e.preStartCallbacks {
+		e.pendingCallbacks.Add(callback)
+	}
+	e.preStartCallbacks = nil
+	e.pollerMu.Unlock()
+	defer func() {
+		e.pollerMu.Lock()
+		defer e.pollerMu.Unlock()
+		_ = e.poller.Close()
+		e.poller = nil
+		e.runs++
+	}()
+
+	e.log(ctx, slog.LevelDebug, "event loop started", func() []slog.Attr {
+		return []slog.Attr{slog.String("poller", fmt.Sprintf("%T", e.poller))}
+	})
+	defer func() {
+		e.log(ctx, slog.LevelDebug, "event loop stopped", func() []slog.Attr {
+			return []slog.Attr{slog.Any("cause", context.Cause(ctx))}
+		})
+	}()
 
+	e.enterGoroutine()
 	ctx = context.WithValue(ctx, runningLoop{}, e)
-	mainTask := main.SpawnTask(ctx).Future().AddDoneCallback(func(err error) {
+	e.ctx = ctx
+	mainTask := main.SpawnTask(ctx).Future()
+	e.mainTask = mainTask
+	defer func() {
+		e.ctx = nil
+		e.mainTask = nil
+	}()
+	mainTask.AddDoneCallback(func(err error) {
 		if err != nil {
 			cancel(err)
 		}
@@ -69,6 +285,20 @@ func (e *EventLoop) Run(ctx context.Context, main Coroutine1) error {
 			continue
 		}
 
+		if e.idleFut != nil && e.isIdle() {
+			// process any I/O that is already ready before declaring the loop idle,
+			// as it may cause tasks to become runnable
+			if err := e.poller.Wait(0); err != nil {
+				return err
+			}
+			e.addCallbacksFromThread(ctx)
+			if e.isIdle() {
+				e.idleFut.SetResult(nil, nil)
+				e.idleFut = nil
+			}
+			continue
+		}
+
 		if ctx.Err() != nil || (mainTask.HasResult() && e.pendingCallbacks.Empty()) {
 			break
 		}
@@ -92,6 +322,60 @@ func (e *EventLoop) Run(ctx context.Context, main Coroutine1) error {
 	return context.Cause(ctx)
 }
 
+// RunForever starts the event loop without a main task, servicing spawned tasks and I/O
+// until [EventLoop.Stop] is called or the context is cancelled.
+// This is the natural mode for servers where work is driven by incoming connections
+// rather than by a main coroutine; use [EventLoop.SpawnThreadsafe] to start e.g. a [Listener],
+// either before or after starting the loop.
+//
+// Once stopped, the loop exits as soon as there are no pending callbacks, like [EventLoop.Run]
+// does once its main task has exited. Returns nil if the loop was stopped using Stop,
+// or the cause of the cancellation if the context was cancelled.
+func (e *EventLoop) RunForever(ctx context.Context) error {
+	stopFut := NewFuture[any]()
+	e.stopFut = stopFut
+	defer func() {
+		e.stopFut = nil
+	}()
+
+	return e.Run(ctx, func(ctx context.Context) error {
+		_, err := stopFut.Await(ctx)
+		return err
+	})
+}
+
+// Stop stops a loop started using [EventLoop.RunForever].
+// Stop is threadsafe; if called before the loop has first started, RunForever returns right away.
+// Has no effect on a loop started using [EventLoop.Run], nor if called after the loop has exited;
+// in particular, it doesn't stop the loop when it is next started.
+func (e *EventLoop) Stop() {
+	e.pollerMu.Lock()
+	runs, running := e.runs, e.poller != nil
+	e.pollerMu.Unlock()
+	if !running && runs > 0 {
+		return
+	}
+
+	e.RunCallbackThreadsafe(context.Background(), func() {
+		// the loop may have exited before getting to the callback, which is then run once it restarts
+		if e.stopFut != nil && e.runs == runs {
+			e.stopFut.SetResult(nil, nil)
+		}
+	})
+}
+
+// SpawnThreadsafe starts the given coroutine as a background task on the loop,
+// with a context derived from the context the loop was started with.
+// SpawnThreadsafe may be called from any goroutine, including before the loop has started,
+// in which case the task is spawned once the loop starts.
+//
+// Errors returned by the coroutine are reported to the loop's error handler; see [EventLoop.SetErrorHandler].
+func (e *EventLoop) SpawnThreadsafe(coro Coroutine1) {
+	e.RunCallbackThreadsafe(context.Background(), func() {
+		coro.SpawnTask(e.ctx)
+	})
+}
+
 func (e *EventLoop) addCallbacksFromThread(ctx context.Context) {
 	for ctx.Err() == nil {
 		select {
@@ -114,10 +398,12 @@ func (e *EventLoop) withTask(t tasker, step func()) {
 	e.currentTasks = append(e.currentTasks, t)
 
 	step()
+	e.enterGoroutine()
 
 	if e.currentTask() != t {
 		panic("context switched from unexpected task")
 	}
+	e.currentTasks[len(e.currentTasks)-1] = nil
 	e.currentTasks = e.currentTasks[:len(e.currentTasks)-1]
 }
 
@@ -125,6 +411,14 @@ func (e *EventLoop) currentTask() tasker {
 	return e.currentTasks[len(e.currentTasks)-1]
 }
 
+// currentTaskMaybe returns the currently executing task, or nil if called from outside of a task.
+func (e *EventLoop) currentTaskMaybe() tasker {
+	if len(e.currentTasks) == 0 {
+		return nil
+	}
+	return e.currentTask()
+}
+
 // Yield yields control to the event loop for one tick, allowing pending callbacks and I/O to be processed.
 func (e *EventLoop) Yield(ctx context.Context, fut Futurer) error {
 	return e.currentTask().yield(ctx, fut)
@@ -145,15 +439,46 @@ func (e *EventLoop) RunCallback(callback func()) {
 
 // RunCallbackThreadsafe schedules a callback for immediate execution on the event loop's thread.
 func (e *EventLoop) RunCallbackThreadsafe(ctx context.Context, callback func()) {
+	// if the loop isn't running, the callback is picked up once it starts
+	e.pollerMu.Lock()
+	if e.poller == nil {
+		e.preStartCallbacks = append(e.preStartCallbacks, NewCallback(0, callback))
+		e.pollerMu.Unlock()
+		return
+	}
+	e.pollerMu.Unlock()
+
 	e.callbacksFromThread <- NewCallback(0, callback)
+
+	e.pollerMu.Lock()
+	defer e.pollerMu.Unlock()
 	if e.poller != nil {
 		if err := e.poller.WakeupThreadsafe(); err != nil {
-			slog.WarnContext(ctx, "could not wake up event loop from thread", slog.Any("error", err))
+			e.log(ctx, slog.LevelWarn, "could not wake up event loop from thread", func() []slog.Attr {
+				return []slog.Attr{slog.Any("error", err)}
+			})
 		}
 	}
 }
 
-// WaitForCallbacks returns a [Future] that will complete once there are no pending callback functions.
+// tryRunCallbackThreadsafe is like [EventLoop.RunCallbackThreadsafe], but drops the callback
+// rather than blocking if the loop isn't running or is too busy to accept more callbacks.
+func (e *EventLoop) tryRunCallbackThreadsafe(callback func()) {
+	e.pollerMu.Lock()
+	defer e.pollerMu.Unlock()
+	if e.poller == nil {
+		return
+	}
+	select {
+	case e.callbacksFromThread <- NewCallback(0, callback):
+		_ = e.poller.WakeupThreadsafe()
+	default:
+	}
+}
+
+// WaitForCallbacks returns a [Future] that will complete once there are no pending callback functions,
+// including callbacks scheduled to run in the future.
+// See [EventLoop.Idle] to wait only for the callbacks that are ready to run.
 func (e *EventLoop) WaitForCallbacks() *Future[any] {
 	if e.callbacksDoneFut == nil {
 		e.callbacksDoneFut = NewFuture[any]()
@@ -161,23 +486,66 @@ func (e *EventLoop) WaitForCallbacks() *Future[any] {
 	return e.callbacksDoneFut
 }
 
+// Idle returns a [Future] that will complete once the loop is quiescent:
+// there are no callbacks ready to run, no timers due, and no tasks runnable,
+// meaning any remaining tasks are blocked on I/O, timers in the future,
+// or futures that nothing is going to resolve in the meantime.
+//
+// This is useful for tests, and for programs that want to flush any pending work before exiting.
+func (e *EventLoop) Idle() *Future[any] {
+	if e.idleFut == nil {
+		e.idleFut = NewFuture[any]()
+	}
+	return e.idleFut
+}
+
+// isIdle reports whether there are no callbacks ready to run.
+func (e *EventLoop) isIdle() bool {
+	return len(e.callbacksFromThread) == 0 &&
+		(e.pendingCallbacks.Empty() || e.pendingCallbacks.TimeUntilNext() > 0)
+}
+
 // Pipe creates two streams, where writing to w will make the written data available from r.
+// Returns [ErrLoopClosed] if the loop is not running.
 func (e *EventLoop) Pipe() (r, w *AsyncStream, err error) {
+	if e.poller == nil {
+		return nil, nil, ErrLoopClosed
+	}
+
 	rf, wf, err := e.poller.Pipe()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return NewAsyncStream(rf), NewAsyncStream(wf), nil
+	return e.newStream(rf, "pipe", slog.String("end", "read")), e.newStream(wf, "pipe", slog.String("end", "write")), nil
 }
 
 // Dial opens a new network connection.
+// The supported networks depend on the [Poller] implementation;
+// the epoll poller supports "tcp" and "unix".
+// Returns [ErrLoopClosed] if the loop is not running.
 func (e *EventLoop) Dial(ctx context.Context, network, address string) (*AsyncStream, error) {
+	if e.poller == nil {
+		return nil, ErrLoopClosed
+	}
+
 	f, err := e.poller.Dial(ctx, network, address)
 	if err != nil {
 		return nil, err
 	}
-	return NewAsyncStream(f), nil
+	return e.newStream(f, "dial", slog.String("network", network), slog.String("address", address)), nil
+}
+
+// newStream wraps the given file handle in an [AsyncStream] that reports its lifecycle
+// using the loop's logger.
+func (e *EventLoop) newStream(f AsyncReadWriteCloser, kind string, attrs ...slog.Attr) *AsyncStream {
+	stream := NewAsyncStream(f)
+	stream.loop = e
+	stream.logAttrs = append([]slog.Attr{slog.String("kind", kind)}, attrs...)
+	e.log(context.Background(), slog.LevelDebug, "stream opened", func() []slog.Attr {
+		return stream.logAttrs
+	})
+	return stream
 }
 
 // DialLines is a convenience method that calls [EventLoop.Dial] followed by [AsyncStream.Lines].
@@ -259,6 +627,8 @@ func (r *callbackQueue) Push(x any) {
 func (r *callbackQueue) Pop() (v any) {
 	n := len(*r)
 	callback := (*r)[n-1]
+	// don't keep the callback alive through the backing array
+	(*r)[n-1] = nil
 	*r = (*r)[:n-1]
 	// remove association to the queue
 	// so Remove behaves correctly if called multiple times
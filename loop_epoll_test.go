@@ -0,0 +1,753 @@
+//go:build linux && !channels
+
+package asyncigo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// newTCPSink starts a TCP server that accepts a single connection
+// and sends everything read from it to the returned channel once the connection closes.
+func newTCPSink(t *testing.T) (addr string, received <-chan []byte) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan []byte, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			ch <- nil
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		ch <- data
+	}()
+	return listener.Addr().String(), ch
+}
+
+func TestAsyncStream_SetZeroCopy(t *testing.T) {
+	testEventLoop(t, "zero copy", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		addr, received := newTCPSink(t)
+		stream, err := loop.Dial(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		if err := stream.SetZeroCopy(1024); err != nil {
+			return err
+		}
+
+		data := make([]byte, 1<<22)
+		rand.New(rand.NewSource(0)).Read(data)
+		for _, chunk := range [][]byte{data[:100], data[100 : 1<<21], data[1<<21:]} {
+			n, err := stream.Write(ctx, chunk).Await(ctx)
+			if err != nil {
+				return err
+			}
+			if n != len(chunk) {
+				t.Errorf("expected to write %d bytes, wrote: %d", len(chunk), n)
+			}
+		}
+		if err := stream.Close(); err != nil {
+			return err
+		}
+
+		if got := <-received; !bytes.Equal(got, data) {
+			t.Errorf("received data does not match written data (got %d bytes)", len(got))
+		}
+		return nil
+	})
+}
+
+func TestAsyncStream_BatchWrites(t *testing.T) {
+	testEventLoop(t, "batch writes", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		addr, received := newTCPSink(t)
+		stream, err := loop.Dial(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		// a batch without any writes returns right away
+		errEmpty := errors.New("nothing to write")
+		if err := stream.BatchWrites(ctx, func(w io.Writer) error { return nil }); err != nil {
+			t.Errorf("expected empty batch to succeed, got: %v", err)
+		}
+		if err := stream.BatchWrites(ctx, func(w io.Writer) error { return errEmpty }); err != errEmpty {
+			t.Errorf("expected %v, got: %v", errEmpty, err)
+		}
+
+		var want bytes.Buffer
+		if err := stream.BatchWrites(ctx, func(w io.Writer) error {
+			for i := range 100 {
+				line := fmt.Sprintf("line %d\n", i)
+				want.WriteString(line)
+				if _, err := io.WriteString(w, line); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := stream.Close(); err != nil {
+			return err
+		}
+
+		if got := <-received; !bytes.Equal(got, want.Bytes()) {
+			t.Errorf("unexpected data received: %q", got)
+		}
+		return nil
+	})
+}
+
+func TestAsyncStream_SockOpt(t *testing.T) {
+	testEventLoop(t, "socket options", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		addr, _ := newTCPSink(t)
+		stream, err := loop.Dial(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+
+		if err := stream.SetSockOptInt(unix.IPPROTO_TCP, unix.TCP_NODELAY, 1); err != nil {
+			return err
+		}
+		if v, err := stream.GetSockOptInt(unix.IPPROTO_TCP, unix.TCP_NODELAY); err != nil {
+			return err
+		} else if v != 1 {
+			t.Errorf("expected TCP_NODELAY to be 1, got: %d", v)
+		}
+
+		if err := stream.SetSockOptTimeval(unix.SOL_SOCKET, unix.SO_SNDTIMEO, time.Millisecond*1500); err != nil {
+			return err
+		}
+		if v, err := stream.GetSockOptTimeval(unix.SOL_SOCKET, unix.SO_SNDTIMEO); err != nil {
+			return err
+		} else if v != time.Millisecond*1500 {
+			t.Errorf("expected SO_SNDTIMEO to be 1.5s, got: %s", v)
+		}
+
+		if err := stream.SetLinger(true, time.Second*3); err != nil {
+			return err
+		}
+		if enabled, timeout, err := stream.Linger(); err != nil {
+			return err
+		} else if !enabled || timeout != time.Second*3 {
+			t.Errorf("expected linger to be enabled with 3s timeout, got: %t, %s", enabled, timeout)
+		}
+
+		if v, err := stream.GetSockOptString(unix.IPPROTO_TCP, unix.TCP_CONGESTION); err != nil {
+			return err
+		} else if v == "" {
+			t.Errorf("expected a congestion control algorithm name")
+		}
+		return nil
+	})
+}
+
+func TestAsyncStream_PeerCred(t *testing.T) {
+	testEventLoop(t, "peer credentials", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		path := filepath.Join(t.TempDir(), "sock")
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		go func() {
+			if conn, err := listener.Accept(); err == nil {
+				_, _ = io.ReadAll(conn)
+				_ = conn.Close()
+			}
+		}()
+
+		stream, err := loop.Dial(ctx, "unix", path)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+
+		cred, err := stream.PeerCred()
+		if err != nil {
+			return err
+		}
+		if cred.PID != os.Getpid() || cred.UID != os.Getuid() || cred.GID != os.Getgid() {
+			t.Errorf("unexpected peer credentials: %+v", cred)
+		}
+		return nil
+	})
+}
+
+// serveHello serves connections on the listener, greeting each client with "hello".
+func serveHello(ctx context.Context, listener *Listener) *Task[any] {
+	return SpawnTask(ctx, func(ctx context.Context) (any, error) {
+		return nil, listener.Serve(ctx, func(ctx context.Context, stream *AsyncStream, remote net.Addr) error {
+			_, err := stream.Write(ctx, []byte("hello\n")).Await(ctx)
+			return err
+		})
+	})
+}
+
+// dialHello connects to the listener and returns everything sent by the server.
+func dialHello(ctx context.Context, loop *EventLoop, listener *Listener) (string, error) {
+	stream, err := loop.Dial(ctx, "tcp", listener.Addr().String())
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	data, err := stream.ReadAll(ctx)
+	return string(data), err
+}
+
+func TestListener_Serve(t *testing.T) {
+	testEventLoop(t, "serve with filters", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		listener, err := loop.Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+
+		var seen int
+		listener.AddFilter(func(ctx context.Context, stream *AsyncStream, remote net.Addr) error {
+			if seen++; seen == 2 {
+				return errors.New("rejected")
+			}
+			return nil
+		})
+
+		server := serveHello(ctx, listener)
+
+		var replies []string
+		for range 3 {
+			reply, err := dialHello(ctx, loop, listener)
+			if err != nil {
+				return err
+			}
+			replies = append(replies, reply)
+		}
+
+		if want := []string{"hello\n", "", "hello\n"}; !reflect.DeepEqual(replies, want) {
+			t.Errorf("expected replies %q, got: %q", want, replies)
+		}
+
+		if err := listener.Close(); err != nil {
+			return err
+		}
+		if _, err := server.Await(ctx); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected Serve to return net.ErrClosed, got: %v", err)
+		}
+		return nil
+	})
+}
+
+func TestListener_SetAcceptHook(t *testing.T) {
+	testEventLoop(t, "accept hook", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		listener, err := loop.Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+
+		var seen int
+		err = listener.SetAcceptHook(func(fd uintptr, remote net.Addr) error {
+			if sotype, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_TYPE); err != nil || sotype != unix.SOCK_STREAM {
+				t.Errorf("expected stream socket, got: %v, %v", sotype, err)
+			}
+			if _, ok := remote.(*net.TCPAddr); !ok {
+				t.Errorf("expected TCP address, got: %v", remote)
+			}
+			if seen++; seen == 2 {
+				return errors.New("rejected")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		serveHello(ctx, listener)
+
+		var replies []string
+		for range 3 {
+			reply, err := dialHello(ctx, loop, listener)
+			if err != nil && !errors.Is(err, unix.ECONNRESET) {
+				return err
+			}
+			replies = append(replies, reply)
+		}
+		if want := []string{"hello\n", "", "hello\n"}; !reflect.DeepEqual(replies, want) {
+			t.Errorf("expected replies %q, got: %q", want, replies)
+		}
+		return nil
+	})
+}
+
+func TestListener_SetIdleTimeout(t *testing.T) {
+	testEventLoop(t, "idle timeout", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		listener, err := loop.Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		listener.SetIdleTimeout(time.Millisecond * 50)
+
+		var handlerErr error
+		SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, listener.Serve(ctx, func(ctx context.Context, stream *AsyncStream, remote net.Addr) error {
+				for line, err := range stream.Lines(ctx) {
+					if err != nil {
+						handlerErr = err
+						return err
+					}
+					if _, err := stream.Write(ctx, line).Await(ctx); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		})
+
+		conn, err := loop.Dial(ctx, "tcp", listener.Addr().String())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		// traffic keeps the connection open for longer than the idle timeout
+		for range 4 {
+			if _, err := conn.Write(ctx, []byte("ping\n")).Await(ctx); err != nil {
+				return err
+			}
+			if line, err := conn.ReadLine(ctx); err != nil || string(line) != "ping\n" {
+				t.Fatalf("expected echo, got: %q, %v", line, err)
+			}
+			if err := Sleep(ctx, time.Millisecond*20); err != nil {
+				return err
+			}
+		}
+
+		start := time.Now()
+		if _, err := conn.ReadLine(ctx); !errors.Is(err, io.EOF) {
+			t.Errorf("expected idle connection to be closed, got: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Millisecond*25 {
+			t.Errorf("expected connection to be closed after idling, got: %v", elapsed)
+		}
+		if !errors.Is(handlerErr, ErrTimeout) {
+			t.Errorf("expected handler to be cancelled with ErrTimeout, got: %v", handlerErr)
+		}
+		return nil
+	})
+}
+
+func TestListener_Throttle(t *testing.T) {
+	testEventLoop(t, "throttle", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		listener, err := loop.Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		throttle := listener.Throttle(2, time.Millisecond*100, time.Millisecond*300)
+		serveHello(ctx, listener)
+
+		var replies []string
+		dial := func() error {
+			reply, err := dialHello(ctx, loop, listener)
+			replies = append(replies, reply)
+			return err
+		}
+
+		for range 3 {
+			if err := dial(); err != nil {
+				return err
+			}
+		}
+		if !throttle.Banned(net.IPv4(127, 0, 0, 1)) {
+			t.Errorf("expected address to be banned")
+		}
+
+		// the connection window has expired, but the ban is still in place
+		if err := Sleep(ctx, time.Millisecond*150); err != nil {
+			return err
+		}
+		if err := dial(); err != nil {
+			return err
+		}
+
+		if err := Sleep(ctx, time.Millisecond*200); err != nil {
+			return err
+		}
+		if err := dial(); err != nil {
+			return err
+		}
+
+		if want := []string{"hello\n", "hello\n", "", "", "hello\n"}; !reflect.DeepEqual(replies, want) {
+			t.Errorf("expected replies %q, got: %q", want, replies)
+		}
+		return nil
+	})
+
+	// pending windows and bans don't keep the loop running
+	testEventLoop(t, "no timers", false, time.Millisecond*50, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		throttle := NewAcceptThrottle(1, time.Second, time.Second)
+		remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)}
+		for _, want := range []error{nil, ErrAcceptThrottled, ErrAddressBanned} {
+			if err := throttle.Filter(ctx, nil, remote); err != want {
+				t.Errorf("expected %v, got: %v", want, err)
+			}
+		}
+		return Sleep(ctx, time.Millisecond*50)
+	})
+}
+
+func TestListener_UnixSocket(t *testing.T) {
+	testEventLoop(t, "unix socket", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		path := filepath.Join(t.TempDir(), "sock")
+		listener, err := loop.Listen(ctx, "unix", path)
+		if err != nil {
+			return err
+		}
+
+		flags, err := unix.FcntlInt(listener.listener.(Fder).Fd(), unix.F_GETFD, 0)
+		if err != nil {
+			return err
+		}
+		if flags&unix.FD_CLOEXEC == 0 {
+			t.Errorf("expected listening socket to be closed on exec")
+		}
+
+		if err := listener.Close(); err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected socket path to be removed once the listener is closed, got: %v", err)
+		}
+
+		// the path can be reused straight away
+		listener, err = loop.Listen(ctx, "unix", path)
+		if err != nil {
+			return err
+		}
+		return listener.Close()
+	})
+}
+
+func TestHandover(t *testing.T) {
+	testEventLoop(t, "hand over listener and connection", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		listener, err := loop.Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		addr := listener.Addr().String()
+		client, err := loop.Dial(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		conn, _, err := listener.Accept(ctx)
+		if err != nil {
+			return err
+		}
+
+		control, err := loop.Listen(ctx, "unix", filepath.Join(t.TempDir(), "sock"))
+		if err != nil {
+			return err
+		}
+		defer control.Close()
+		sender, err := loop.Dial(ctx, "unix", control.Addr().String())
+		if err != nil {
+			return err
+		}
+		defer sender.Close()
+		receiver, _, err := control.Accept(ctx)
+		if err != nil {
+			return err
+		}
+		defer receiver.Close()
+
+		if err := SendHandover(ctx, sender, &Handover{Listeners: []*Listener{listener}, Conns: []*AsyncStream{conn}}); err != nil {
+			return err
+		}
+		_ = listener.Close()
+		_ = conn.Close()
+
+		h, err := ReceiveHandover(ctx, receiver)
+		if err != nil {
+			return err
+		}
+		defer h.Close()
+		if len(h.Listeners) != 1 || len(h.Conns) != 1 {
+			t.Fatalf("expected 1 listener and 1 connection, got %d and %d", len(h.Listeners), len(h.Conns))
+		}
+		if got := h.Listeners[0].Addr().String(); got != addr {
+			t.Errorf("expected listener address %s, got %s", addr, got)
+		}
+		for _, f := range []any{h.Listeners[0].listener, h.Conns[0].file} {
+			if flags, err := unix.FcntlInt(f.(Fder).Fd(), unix.F_GETFD, 0); err != nil {
+				return err
+			} else if flags&unix.FD_CLOEXEC == 0 {
+				t.Errorf("expected received file descriptor to be closed on exec")
+			}
+		}
+
+		// the connection accepted before the handover is still open
+		if _, err := h.Conns[0].Write(ctx, []byte("resumed\n")).Await(ctx); err != nil {
+			return err
+		}
+		_ = h.Conns[0].Close()
+		if data, err := client.ReadAll(ctx); err != nil {
+			return err
+		} else if string(data) != "resumed\n" {
+			t.Errorf("expected %q, got %q", "resumed\n", data)
+		}
+
+		serveHello(ctx, h.Listeners[0])
+		if reply, err := dialHello(ctx, loop, h.Listeners[0]); err != nil {
+			return err
+		} else if reply != "hello\n" {
+			t.Errorf("expected %q, got %q", "hello\n", reply)
+		}
+		return nil
+	})
+}
+
+func TestSocks5Server(t *testing.T) {
+	testEventLoop(t, "socks5", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		target, err := loop.Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer target.Close()
+		serveHello(ctx, target)
+
+		proxy, err := loop.Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer proxy.Close()
+		server := &Socks5Server{
+			Auth: func(ctx context.Context, username, password string) error {
+				if username != "user" || password != "secret" {
+					return errors.New("wrong password")
+				}
+				return nil
+			},
+			BandwidthLimit: 1 << 20,
+		}
+		SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, proxy.Serve(ctx, server.ServeConn)
+		})
+
+		connect := func(password string) (string, error) {
+			stream, err := loop.Dial(ctx, "tcp", proxy.Addr().String())
+			if err != nil {
+				return "", err
+			}
+			defer stream.Close()
+
+			auth := []byte{5, 1, 2, 1, 4}
+			auth = append(auth, "user"...)
+			auth = append(auth, byte(len(password)))
+			auth = append(auth, password...)
+			if _, err := stream.Write(ctx, auth).Await(ctx); err != nil {
+				return "", err
+			}
+			authReply, err := stream.ReadChunk(ctx, 4)
+			if err != nil || authReply[3] != 0 {
+				return string(authReply), err
+			}
+
+			addr := target.Addr().(*net.TCPAddr)
+			request := []byte{5, 1, 0, 1}
+			request = append(request, addr.IP.To4()...)
+			request = append(request, byte(addr.Port>>8), byte(addr.Port))
+			if _, err := stream.Write(ctx, request).Await(ctx); err != nil {
+				return "", err
+			}
+
+			data, err := stream.ReadAll(ctx)
+			return string(authReply) + string(data), err
+		}
+
+		reply, err := connect("secret")
+		if err != nil {
+			return err
+		}
+		if want := "\x05\x02\x01\x00\x05\x00\x00\x01\x00\x00\x00\x00\x00\x00hello\n"; reply != want {
+			t.Errorf("expected reply %q, got: %q", want, reply)
+		}
+
+		reply, err = connect("wrong")
+		if err != nil {
+			return err
+		}
+		if want := "\x05\x02\x01\x01"; reply != want {
+			t.Errorf("expected reply %q, got: %q", want, reply)
+		}
+		return nil
+	})
+}
+
+func TestTextProtocolServer(t *testing.T) {
+	testEventLoop(t, "text protocol", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		listener, err := loop.Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+
+		server := NewTextProtocolServer(listener, map[string]TextCommandHandler{
+			"echo": func(ctx context.Context, args []string) (string, error) {
+				return strings.Join(args, " "), nil
+			},
+			"fail": func(ctx context.Context, args []string) (string, error) {
+				return "", errors.New("oops")
+			},
+			"quit": func(ctx context.Context, args []string) (string, error) {
+				return "BYE", io.EOF
+			},
+		})
+		server.Handle("slow", func(ctx context.Context, args []string) (string, error) {
+			return "done", Sleep(ctx, time.Second)
+		}, time.Millisecond*10)
+		SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, server.Serve(ctx)
+		})
+
+		stream, err := loop.Dial(ctx, "tcp", listener.Addr().String())
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+
+		commands := "ECHO hello  world\n\nfail\nslow\nnope\nquit\n"
+		if _, err := stream.Write(ctx, []byte(commands)).Await(ctx); err != nil {
+			return err
+		}
+		data, err := stream.ReadAll(ctx)
+		if err != nil {
+			return err
+		}
+
+		want := "hello world\nERR oops\nERR operation timed out\nERR unknown command nope\nBYE\n"
+		if string(data) != want {
+			t.Errorf("expected responses %q, got: %q", want, data)
+		}
+		return nil
+	})
+}
+
+func TestTextProtocolServer_SetConnLimits(t *testing.T) {
+	testEventLoop(t, "connection limits", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		listener, err := loop.Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+
+		server := NewTextProtocolServer(listener, map[string]TextCommandHandler{
+			"echo": func(ctx context.Context, args []string) (string, error) {
+				return strings.Join(args, " "), nil
+			},
+		})
+		server.SetConnLimits(ConnLimits{MaxAge: time.Millisecond * 50, MaxRequests: 2, Goodbye: "GOAWAY"})
+		SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, server.Serve(ctx)
+		})
+
+		session := func(commands string) (string, error) {
+			stream, err := loop.Dial(ctx, "tcp", listener.Addr().String())
+			if err != nil {
+				return "", err
+			}
+			defer stream.Close()
+			if _, err := stream.Write(ctx, []byte(commands)).Await(ctx); err != nil {
+				return "", err
+			}
+			data, err := stream.ReadAll(ctx)
+			return string(data), err
+		}
+
+		// the connection should be closed after the maximum number of requests
+		reply, err := session("echo 1\necho 2\n")
+		if err != nil {
+			return err
+		}
+		if want := "1\n2\nGOAWAY\n"; reply != want {
+			t.Errorf("expected %q, got: %q", want, reply)
+		}
+
+		// an idle connection should be closed once it reaches its maximum age
+		reply, err = session("echo 1\n")
+		if err != nil {
+			return err
+		}
+		if want := "1\nGOAWAY\n"; reply != want {
+			t.Errorf("expected %q, got: %q", want, reply)
+		}
+		return nil
+	})
+}
+
+func TestCompletionToken(t *testing.T) {
+	testEventLoop(t, "completion token", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		token, err := NewCompletionToken(ctx)
+		if err != nil {
+			return err
+		}
+
+		// simulate a completion callback invoked on a foreign thread
+		go func() {
+			var buf [8]byte
+			binary.NativeEndian.PutUint64(buf[:], 42)
+			_, _ = unix.Write(int(token.Handle()), buf[:])
+		}()
+		if res, err := token.Await(ctx); err != nil || res != 42 {
+			t.Errorf("expected result 42, got: %v, %v", res, err)
+		}
+
+		cancelled, err := NewCompletionToken(ctx)
+		if err != nil {
+			return err
+		}
+		cancelled.Cancel(nil)
+		if _, err := cancelled.Await(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected token to be cancelled, got: %v", err)
+		}
+		// the foreign code may still signal the cancelled token
+		if _, err := unix.FcntlInt(cancelled.Handle(), unix.F_GETFD, 0); err != nil {
+			t.Errorf("expected handle of cancelled token to stay open, got: %v", err)
+		}
+		var buf [8]byte
+		binary.NativeEndian.PutUint64(buf[:], 1)
+		if _, err := unix.Write(int(cancelled.Handle()), buf[:]); err != nil {
+			t.Errorf("expected late signal to succeed, got: %v", err)
+		}
+		cancelled.Release()
+		if _, err := unix.FcntlInt(cancelled.Handle(), unix.F_GETFD, 0); !errors.Is(err, unix.EBADF) {
+			t.Errorf("expected handle to be closed once released, got: %v", err)
+		}
+
+		if _, err := unix.FcntlInt(token.Handle(), unix.F_GETFD, 0); !errors.Is(err, unix.EBADF) {
+			t.Errorf("expected handle of signalled token to be closed, got: %v", err)
+		}
+		token.Release()
+		return nil
+	})
+}
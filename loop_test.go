@@ -2,11 +2,13 @@ package asyncigo
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"math"
 	"net/http"
 	"net/http/httptest"
@@ -14,6 +16,13 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -399,6 +408,18 @@ func TestAsyncStream(t *testing.T) {
 				return err
 			},
 		},
+		{
+			name: "LinesBorrowed",
+			read: func(ctx context.Context, writer io.Writer, stream *AsyncStream) (err error) {
+				for line := range stream.LinesBorrowed(ctx).UntilErr(&err) {
+					if i := bytes.IndexByte(line, '\n'); i != -1 && i != len(line)-1 {
+						return fmt.Errorf("expected newline at position %d, got: %d", len(line)-1, i)
+					}
+					writer.Write(line)
+				}
+				return err
+			},
+		},
 		{
 			name: "Chunks",
 			read: func(ctx context.Context, writer io.Writer, stream *AsyncStream) (err error) {
@@ -808,3 +829,3572 @@ func TestGetFirstResult(t *testing.T) {
 		})
 	}
 }
+
+func TestEventLoop_SetLogger(t *testing.T) {
+	var buf bytes.Buffer
+	loop := NewEventLoop()
+	loop.SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	err := loop.Run(context.Background(), func(ctx context.Context) error {
+		r, w, err := loop.Pipe()
+		if err != nil {
+			return err
+		}
+		_ = r.Close()
+		_ = w.Close()
+
+		_, err = SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, errors.New("oops")
+		}).Await(ctx)
+		if err == nil {
+			t.Errorf("expected task to fail")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`msg="event loop started"`,
+		`msg="stream opened" kind=pipe end=read`,
+		`msg="stream closed" kind=pipe end=write error=<nil>`,
+		`msg="task spawned" task=2`,
+		`msg="task done" task=2 error=oops`,
+		`msg="event loop stopped"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected log to contain %s, got:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	if !errors.Is(ErrTaskCancelled, context.Canceled) {
+		t.Errorf("expected ErrTaskCancelled to match context.Canceled")
+	}
+	if !errors.Is(ErrTimeout, context.DeadlineExceeded) {
+		t.Errorf("expected ErrTimeout to match context.DeadlineExceeded")
+	}
+
+	loop := NewEventLoop()
+	if _, err := loop.Dial(context.Background(), "tcp", "localhost:80"); !errors.Is(err, ErrLoopClosed) {
+		t.Errorf("expected ErrLoopClosed when dialing before running the loop, got: %v", err)
+	}
+
+	testEventLoop(t, "sentinel errors", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		task := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return NewFuture[any]().Await(ctx)
+		})
+		if _, err := task.Result(); !errors.Is(err, ErrFuturePending) {
+			t.Errorf("expected ErrFuturePending, got: %v", err)
+		}
+
+		task.Cancel(nil)
+		if _, err := task.Result(); !errors.Is(err, ErrTaskCancelled) {
+			t.Errorf("expected ErrTaskCancelled, got: %v", err)
+		}
+
+		r, w, err := loop.Pipe()
+		if err != nil {
+			return err
+		}
+		_ = w.Close()
+		_ = r.Close()
+		if _, err := r.ReadAll(ctx); !errors.Is(err, ErrStreamClosed) {
+			t.Errorf("expected ErrStreamClosed when reading, got: %v", err)
+		}
+		if _, err := w.Write(ctx, []byte("test")).Await(ctx); !errors.Is(err, ErrStreamClosed) {
+			t.Errorf("expected ErrStreamClosed when writing, got: %v", err)
+		}
+		return nil
+	})
+}
+
+func TestCancelledError(t *testing.T) {
+	testEventLoop(t, "cancelled error", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		errShutdown := errors.New("shutting down")
+
+		fut := NewFuture[int]()
+		task := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return fut.Await(ctx)
+		})
+		if err := loop.Yield(ctx, nil); err != nil {
+			return err
+		}
+		task.Cancel(errShutdown)
+
+		// the cause should propagate both to the task and to the future it was awaiting
+		for name, err := range map[string]error{"task": task.Err(), "future": fut.Err()} {
+			var cerr *CancelledError
+			if !errors.As(err, &cerr) {
+				t.Errorf("%s: expected CancelledError, got: %v", name, err)
+			} else if cerr.Cause != errShutdown {
+				t.Errorf("%s: expected cause %v, got: %v", name, errShutdown, cerr.Cause)
+			}
+			if !errors.Is(err, context.Canceled) || !errors.Is(err, errShutdown) {
+				t.Errorf("%s: expected error to match both context.Canceled and its cause, got: %v", name, err)
+			}
+		}
+
+		// cancelling the parent context should record the context's cause
+		// once the task resumes
+		parentCtx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+		fut = NewFuture[int]()
+		task = SpawnTask(parentCtx, func(ctx context.Context) (int, error) {
+			return fut.Await(ctx)
+		})
+		if err := loop.Yield(ctx, nil); err != nil {
+			return err
+		}
+		cancel(errShutdown)
+		fut.SetResult(1, nil)
+		var cerr *CancelledError
+		if _, err := task.Await(ctx); !errors.As(err, &cerr) || cerr.Cause != errShutdown {
+			t.Errorf("expected CancelledError caused by %v, got: %v", errShutdown, err)
+		}
+
+		// awaiting with a cancelled context should record the context's cause
+		// on the awaited future
+		awaitCtx, cancelAwait := context.WithCancelCause(ctx)
+		cancelAwait(errShutdown)
+		fut = NewFuture[int]()
+		if _, err := fut.Await(awaitCtx); !errors.As(err, &cerr) || cerr.Cause != errShutdown {
+			t.Errorf("expected CancelledError caused by %v, got: %v", errShutdown, err)
+		}
+
+		// failures are not cancellations
+		fut = NewFuture[int]()
+		fut.SetResult(0, errShutdown)
+		if errors.As(fut.Err(), &cerr) {
+			t.Errorf("expected plain error, got CancelledError")
+		}
+		return nil
+	})
+}
+
+func TestAwaitPanic(t *testing.T) {
+	testEventLoop(t, "await panic", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		errFailed := errors.New("failed")
+
+		var recovered any
+		inner := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			defer func() {
+				recovered = recover()
+			}()
+			fut := NewFuture[int]().WithName("lookup")
+			loop.RunCallback(func() {
+				fut.SetResult(0, errFailed)
+			})
+			return fut.MustAwait(ctx), nil
+		})
+		outer := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return inner.Await(ctx)
+		}).Future().WithName("outer")
+		if _, err := outer.Await(ctx); err != nil {
+			return err
+		}
+
+		p, ok := recovered.(*AwaitPanic)
+		if !ok {
+			t.Fatalf("expected *AwaitPanic, got: %#v", recovered)
+		}
+		if !errors.Is(p, errFailed) {
+			t.Errorf("expected panic to wrap %v, got: %v", errFailed, p.Err)
+		}
+		if p.Awaitable != "lookup" {
+			t.Errorf("expected awaitable name %q, got: %q", "lookup", p.Awaitable)
+		}
+		// inner is awaited by outer, which is awaited through its future rather than the task
+		wantChain := []string{"task 2", "outer"}
+		if !reflect.DeepEqual(p.AwaitChain, wantChain) {
+			t.Errorf("expected await chain %v, got: %v", wantChain, p.AwaitChain)
+		}
+		if msg := p.Error(); !strings.Contains(msg, "lookup") || !strings.Contains(msg, "task 2 <- outer") {
+			t.Errorf("unexpected panic message: %s", msg)
+		}
+		return nil
+	})
+}
+
+func TestTask_Panic(t *testing.T) {
+	testEventLoop(t, "task panic", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		task := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			if err := Checkpoint(ctx); err != nil {
+				return 0, err
+			}
+			panic("boom")
+		})
+		_, err := task.Await(ctx)
+		var perr *PanicError
+		if !errors.As(err, &perr) {
+			t.Fatalf("expected PanicError, got: %v", err)
+		}
+		if perr.Value != "boom" || !bytes.Contains(perr.Stack, []byte("loop_test.go")) {
+			t.Errorf("unexpected panic: %v", perr)
+		}
+
+		// panicking with an error wraps the error
+		errFailed := errors.New("failed")
+		_, err = SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			panic(errFailed)
+		}).Await(ctx)
+		if !errors.Is(err, errFailed) {
+			t.Errorf("expected %v, got: %v", errFailed, err)
+		}
+
+		// the loop keeps running
+		return Sleep(ctx, time.Millisecond)
+	})
+}
+
+func TestTask_CancelGraceful(t *testing.T) {
+	testEventLoop(t, "cancel gracefully", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		// a cooperative task gets to await while cleaning up
+		var cleanedUp bool
+		cooperative := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			err := Sleep(ctx, time.Hour)
+			if err := Sleep(ctx, time.Millisecond*10); err != nil {
+				return nil, err
+			}
+			cleanedUp = true
+			return nil, err
+		}).WithFinalizer(func() {
+			t.Errorf("finalizer run for task that finished in time")
+		})
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+		if err := cooperative.CancelGraceful(ctx, time.Millisecond*200); !errors.Is(err, ErrTaskCancelled) {
+			t.Errorf("expected %v, got: %v", ErrTaskCancelled, err)
+		}
+		if !cleanedUp {
+			t.Errorf("expected task to clean up")
+		}
+
+		// a task that ignores the request is cut off after the grace period
+		var finalized bool
+		stubborn := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			_ = Sleep(ctx, time.Hour)
+			return nil, Sleep(ctx, time.Hour)
+		}).WithFinalizer(func() {
+			finalized = true
+		})
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+		start := time.Now()
+		err := stubborn.CancelGraceful(ctx, time.Millisecond*20)
+		if elapsed := time.Since(start); elapsed < time.Millisecond*20 || elapsed > time.Millisecond*200 {
+			t.Errorf("expected to wait out the grace period, waited %s", elapsed)
+		}
+		if !errors.Is(err, ErrTimeout) || !finalized {
+			t.Errorf("expected task to be cut off with %v and finalized, got: %v, %v", ErrTimeout, err, finalized)
+		}
+
+		// a task is cut off with the cancellation of the calling coroutine if it is cancelled while waiting
+		errShutdown := errors.New("shutdown")
+		stubborn = SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			_ = Sleep(ctx, time.Hour)
+			return nil, Sleep(ctx, time.Hour)
+		})
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+		caller := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, stubborn.CancelGraceful(ctx, time.Second)
+		})
+		loop.ScheduleCallback(time.Millisecond*5, func() {
+			caller.Cancel(errShutdown)
+		})
+		_, err = caller.Await(ctx)
+		if !errors.Is(err, errShutdown) || !errors.Is(stubborn.Err(), errShutdown) || errors.Is(stubborn.Err(), ErrTimeout) {
+			t.Errorf("expected caller and task to be cancelled with %v, got: %v, %v", errShutdown, err, stubborn.Err())
+		}
+
+		// a task busy with CPU-bound work notices the request at its next checkpoint
+		busy := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			for {
+				if err := Checkpoint(ctx); err != nil {
+					return nil, err
+				}
+			}
+		})
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+		if err := busy.CancelGraceful(ctx, time.Second); !errors.Is(err, ErrTaskCancelled) {
+			t.Errorf("expected %v, got: %v", ErrTaskCancelled, err)
+		}
+
+		// a task that hasn't started is cancelled right away
+		var started bool
+		pending := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			started = true
+			return nil, nil
+		})
+		if err := pending.CancelGraceful(ctx, time.Second); !errors.Is(err, ErrTaskCancelled) || started {
+			t.Errorf("expected task to be cancelled before starting, got: %v, %v", err, started)
+		}
+		return nil
+	})
+}
+
+func TestEventLoop_RunForever(t *testing.T) {
+	loop := NewEventLoop()
+	var ticks atomic.Int32
+	loop.SpawnThreadsafe(func(ctx context.Context) error {
+		for {
+			if err := Sleep(ctx, time.Millisecond*5); err != nil {
+				return err
+			}
+			if ticks.Add(1) == 3 {
+				loop.Stop()
+				return nil
+			}
+		}
+	})
+	if err := loop.RunForever(context.Background()); err != nil {
+		t.Errorf("expected loop to be stopped without error, got: %v", err)
+	}
+	if ticks.Load() != 3 {
+		t.Errorf("expected 3 ticks, got: %d", ticks.Load())
+	}
+
+	// stopping the loop from another goroutine
+	done := make(chan error, 1)
+	go func() {
+		done <- loop.RunForever(context.Background())
+	}()
+	time.Sleep(time.Millisecond * 20)
+	loop.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected loop to be stopped without error, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected loop to stop")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if err := loop.RunForever(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v, got: %v", context.DeadlineExceeded, err)
+	}
+
+	// stopping a loop that has already exited doesn't stop it the next time it runs
+	loop.Stop()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if err := loop.RunForever(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected stale stop to be ignored, got: %v", err)
+	}
+
+	// any number of tasks may be spawned before the loop first starts
+	loop = NewEventLoop()
+	var spawned atomic.Int32
+	queued := make(chan struct{})
+	go func() {
+		defer close(queued)
+		for range 1000 {
+			loop.SpawnThreadsafe(func(ctx context.Context) error {
+				spawned.Add(1)
+				return nil
+			})
+		}
+		loop.Stop()
+	}()
+	select {
+	case <-queued:
+	case <-time.After(time.Second):
+		t.Fatalf("expected spawning before the loop starts not to block")
+	}
+	if err := loop.RunForever(context.Background()); err != nil {
+		t.Errorf("expected loop to be stopped without error, got: %v", err)
+	}
+	if spawned.Load() != 1000 {
+		t.Errorf("expected 1000 tasks to run, got: %d", spawned.Load())
+	}
+}
+
+func TestEventLoop_Idle(t *testing.T) {
+	testEventLoop(t, "idle", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var steps int
+		busy := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			for range 10 {
+				if err := loop.Yield(ctx, nil); err != nil {
+					return nil, err
+				}
+				steps++
+			}
+			return nil, nil
+		})
+		sleeper := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, Sleep(ctx, time.Hour)
+		})
+		r, w, err := loop.Pipe()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		defer w.Close()
+		reader := SpawnTask(ctx, func(ctx context.Context) ([]byte, error) {
+			return r.ReadChunk(ctx, 1)
+		})
+
+		start := time.Now()
+		if _, err := loop.Idle().Await(ctx); err != nil {
+			return err
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected the loop to become idle immediately, took: %s", elapsed)
+		}
+		if !busy.HasResult() || steps != 10 {
+			t.Errorf("expected runnable task to finish before the loop became idle, ran %d steps", steps)
+		}
+		if sleeper.HasResult() || reader.HasResult() {
+			t.Errorf("expected blocked tasks to still be pending")
+		}
+
+		// data that is ready to be read should be processed before the loop is considered idle
+		if _, err := w.Write(ctx, []byte{1}).Await(ctx); err != nil {
+			return err
+		}
+		if _, err := loop.Idle().Await(ctx); err != nil {
+			return err
+		}
+		if !reader.HasResult() {
+			t.Errorf("expected reader to finish before the loop became idle")
+		}
+
+		sleeper.Cancel(nil)
+		return nil
+	})
+}
+
+func TestEventLoop_TaskHooks(t *testing.T) {
+	testEventLoop(t, "task hooks", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		type requestID struct{}
+		var spawned []uint64
+		done := map[uint64]error{}
+		var requests []any
+		loop.OnTaskSpawn(func(info TaskInfo) {
+			spawned = append(spawned, info.ID)
+			if id := info.Context.Value(requestID{}); id != nil {
+				requests = append(requests, id)
+			}
+		})
+		loop.OnTaskDone(func(info TaskInfo, err error) {
+			done[info.ID] = err
+		})
+
+		errFailed := errors.New("failed")
+		ok := SpawnTask(context.WithValue(ctx, requestID{}, "req-1"), func(ctx context.Context) (int, error) {
+			return 1, nil
+		})
+		failed := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return 0, errFailed
+		})
+		if err := Wait(ctx, WaitAll, ok, failed); !errors.Is(err, errFailed) {
+			t.Errorf("expected %v, got: %v", errFailed, err)
+		}
+
+		if !reflect.DeepEqual(spawned, []uint64{ok.id, failed.id}) {
+			t.Errorf("expected spawn hook to run for tasks %d and %d, got: %v", ok.id, failed.id, spawned)
+		}
+		if err, found := done[ok.id]; !found || err != nil {
+			t.Errorf("expected done hook to run for successful task without error, got: %v (found: %v)", err, found)
+		}
+		if err := done[failed.id]; err != errFailed {
+			t.Errorf("expected done hook to receive %v, got: %v", errFailed, err)
+		}
+		if !reflect.DeepEqual(requests, []any{"req-1"}) {
+			t.Errorf("expected hook to see the task context, got: %v", requests)
+		}
+		return nil
+	})
+}
+
+func TestWaitAllTyped(t *testing.T) {
+	testEventLoop(t, "wait all typed", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		errFailed := errors.New("failed")
+		results := WaitAllTyped(ctx,
+			SpawnTask(ctx, func(ctx context.Context) (int, error) {
+				if err := Sleep(ctx, time.Millisecond*20); err != nil {
+					return 0, err
+				}
+				return 1, nil
+			}),
+			SpawnTask(ctx, func(ctx context.Context) (int, error) {
+				return 0, errFailed
+			}),
+		)
+		want := []Result[int]{{Value: 1}, {Err: errFailed}}
+		if !reflect.DeepEqual(results, want) {
+			t.Errorf("expected %v, got: %v", want, results)
+		}
+
+		// interrupting the wait should leave pending awaitables unaffected
+		pending := NewFuture[int]()
+		done := NewFuture[int]()
+		done.SetResult(2, nil)
+		var interrupted []Result[int]
+		waiter := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			interrupted = WaitAllTyped[int](ctx, done, pending)
+			return nil, nil
+		})
+		if err := loop.Yield(ctx, nil); err != nil {
+			return err
+		}
+		waiter.Cancel(nil)
+		if err := Wait(ctx, WaitAll, waiter); !errors.Is(err, ErrTaskCancelled) {
+			t.Errorf("expected waiter to be cancelled, got: %v", err)
+		}
+
+		if len(interrupted) != 2 || interrupted[0] != (Result[int]{Value: 2}) || !errors.Is(interrupted[1].Err, ErrTaskCancelled) {
+			t.Errorf("expected pending result to hold the cancellation error, got: %v", interrupted)
+		}
+		if pending.HasResult() {
+			t.Errorf("expected pending future to not be cancelled")
+		}
+		return nil
+	})
+}
+
+func TestWaitFor(t *testing.T) {
+	testEventLoop(t, "wait for", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		slow := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return 1, Sleep(ctx, time.Second)
+		})
+		if res, err := WaitFor[int](ctx, time.Millisecond*10, slow); err != ErrTimeout || res != 0 {
+			t.Errorf("expected ErrTimeout, got: %v, %v", res, err)
+		}
+		if !errors.Is(slow.Err(), ErrTimeout) {
+			t.Errorf("expected slow task to be cancelled, got: %v", slow.Err())
+		}
+
+		fast := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return 2, Sleep(ctx, time.Millisecond)
+		})
+		if res, err := WaitFor[int](ctx, time.Second, fast); err != nil || res != 2 {
+			t.Errorf("expected result 2, got: %v, %v", res, err)
+		}
+
+		errFailed := errors.New("failed")
+		failed := NewFuture[int]()
+		failed.SetResult(0, errFailed)
+		if _, err := WaitFor[int](ctx, time.Second, failed); err != errFailed {
+			t.Errorf("expected %v, got: %v", errFailed, err)
+		}
+		return nil
+	})
+}
+
+func TestShield(t *testing.T) {
+	testEventLoop(t, "shield", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		op := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return 1, Sleep(ctx, time.Millisecond*20)
+		})
+		waiter := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return Shield[int](ctx, op)
+		})
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+
+		// cancelling the waiter leaves the shielded operation running
+		waiter.Cancel(nil)
+		if _, err := waiter.Await(ctx); !errors.Is(err, ErrTaskCancelled) {
+			t.Errorf("expected waiter to be cancelled, got: %v", err)
+		}
+		if op.HasResult() {
+			t.Errorf("expected shielded operation to keep running")
+		}
+		if res, err := op.Await(ctx); err != nil || res != 1 {
+			t.Errorf("expected result 1, got: %v, %v", res, err)
+		}
+
+		// cancelling the operation itself is still reported
+		fut := NewFuture[int]()
+		fut.Cancel(nil)
+		if _, err := Shield[int](ctx, fut); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected cancellation, got: %v", err)
+		}
+		return nil
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	testEventLoop(t, "timeout", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		pending := NewFuture[any]()
+		err := WithTimeout(ctx, time.Millisecond*10, func(ctx context.Context) error {
+			_, err := pending.Await(ctx)
+			return err
+		})
+		if err != ErrTimeout {
+			t.Errorf("expected ErrTimeout, got: %v", err)
+		}
+		if !errors.Is(pending.Err(), ErrTimeout) {
+			t.Errorf("expected awaited future to be cancelled, got: %v", pending.Err())
+		}
+
+		// extending the deadline while making progress
+		timeout := NewTimeout(time.Millisecond * 30)
+		err = timeout.Run(ctx, func(ctx context.Context) error {
+			for range 3 {
+				if err := Sleep(ctx, time.Millisecond*20); err != nil {
+					return err
+				}
+				timeout.Reschedule(time.Millisecond * 30)
+			}
+			return nil
+		})
+		if err != nil || timeout.Expired() {
+			t.Errorf("expected rescheduled scope to complete, got: %v", err)
+		}
+
+		// errors other than the timeout are passed through
+		errFailed := errors.New("failed")
+		if err := WithTimeout(ctx, time.Second, func(ctx context.Context) error {
+			return errFailed
+		}); err != errFailed {
+			t.Errorf("expected %v, got: %v", errFailed, err)
+		}
+		return nil
+	})
+
+	// the rescheduled timer is cancelled when returning early, rather than keeping the loop alive
+	testEventLoop(t, "reschedule and return early", false, time.Millisecond*100, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		timeout := NewTimeout(time.Millisecond * 50)
+		if err := timeout.Run(ctx, func(ctx context.Context) error {
+			timeout.Reschedule(time.Second * 2)
+			return nil
+		}); err != nil {
+			return err
+		}
+		deadline := timeout.Deadline()
+
+		if err := Sleep(ctx, time.Millisecond*100); err != nil {
+			return err
+		}
+		if timeout.Expired() {
+			t.Errorf("expected timeout not to expire after Run returned")
+		}
+		timeout.Reschedule(time.Millisecond)
+		if !timeout.Deadline().Equal(deadline) {
+			t.Errorf("expected rescheduling after Run returned to have no effect")
+		}
+		return nil
+	})
+}
+
+func TestAsyncStream_ReadN(t *testing.T) {
+	testEventLoop(t, "read n", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		data := bytes.Repeat([]byte("0123456789"), 10_000)
+		stream, closeStream, err := newPipeStream(ctx, loop, data, 0, 0)
+		if err != nil {
+			return err
+		}
+		defer closeStream()
+
+		// leave some data buffered that should be copied first
+		head, err := stream.ReadChunk(ctx, 10)
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		var lastProgress int64
+		copied, err := stream.ReadN(ctx, &buf, 70_000, func(copied int64) {
+			if copied <= lastProgress {
+				t.Errorf("expected progress to increase, got %d after %d", copied, lastProgress)
+			}
+			lastProgress = copied
+		})
+		if err != nil {
+			return err
+		}
+		if copied != 70_000 || lastProgress != copied {
+			t.Errorf("expected to copy 70000 bytes, copied %d (last progress: %d)", copied, lastProgress)
+		}
+		if got := append(head, buf.Bytes()...); !bytes.Equal(got, data[:len(got)]) {
+			t.Errorf("copied data did not match")
+		}
+
+		// asking for more than the remaining data should fail once the stream ends
+		buf.Reset()
+		copied, err = stream.ReadN(ctx, &buf, int64(len(data)))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected %v, got: %v", io.ErrUnexpectedEOF, err)
+		}
+		if want := int64(len(data) - 70_010); copied != want || !bytes.Equal(buf.Bytes(), data[70_010:]) {
+			t.Errorf("expected to copy the remaining %d bytes, copied: %d", want, copied)
+		}
+		return nil
+	})
+}
+
+func TestAsyncStream_ReadNProgress(t *testing.T) {
+	testEventLoop(t, "read n progress", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		data := bytes.Repeat([]byte("0123456789"), 10_000)
+		stream, closeStream, err := newPipeStream(ctx, loop, data, 0, 0)
+		if err != nil {
+			return err
+		}
+		defer closeStream()
+
+		var buf bytes.Buffer
+		progress := stream.ReadNProgress(ctx, &buf, int64(len(data)))
+		var updates []ProgressUpdate
+		for update, err := range progress.Updates(ctx) {
+			if err != nil {
+				return err
+			}
+			updates = append(updates, update)
+		}
+
+		copied, err := progress.Await(ctx)
+		if err != nil {
+			return err
+		} else if copied != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("expected %d bytes to be copied, got: %d", len(data), copied)
+		}
+		if len(updates) == 0 || updates[len(updates)-1].Percent() != 100 {
+			t.Errorf("expected progress to reach 100%%, got: %v", updates)
+		}
+		for i := 1; i < len(updates); i++ {
+			if updates[i].Done <= updates[i-1].Done {
+				t.Errorf("expected progress to increase, got: %v", updates)
+			}
+		}
+		return nil
+	})
+}
+
+func TestAsyncStream_SendFileProgress(t *testing.T) {
+	testEventLoop(t, "send file progress", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		path := filepath.Join("tests", "stream_1.txt")
+		want, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		r, w, err := loop.Pipe()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		progress := w.SendFileProgress(ctx, path, 100, 3)
+		progress.AddDoneCallback(func(error) {
+			_ = w.Close()
+		})
+		received := SpawnTask(ctx, r.ReadAll)
+		var updates []ProgressUpdate
+		for update, err := range progress.Updates(ctx) {
+			if err != nil {
+				return err
+			}
+			updates = append(updates, update)
+		}
+
+		sent, err := progress.Await(ctx)
+		if err != nil {
+			return err
+		} else if sent != int64(len(want)) {
+			t.Errorf("expected %d bytes to be sent, got: %d", len(want), sent)
+		}
+		if got, err := received.Await(ctx); err != nil {
+			return err
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("sent data did not match")
+		}
+		if len(updates) == 0 || updates[len(updates)-1].Percent() != 100 {
+			t.Errorf("expected progress to reach 100%%, got: %v", updates)
+		}
+
+		missing := w.SendFileProgress(ctx, filepath.Join("tests", "missing.txt"), 100, 3)
+		if _, err := missing.Await(ctx); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %v, got: %v", os.ErrNotExist, err)
+		}
+		return nil
+	})
+}
+
+// alwaysReadyFile is a file handle that never blocks, reading endless zeroes
+// and accepting at most maxWrite bytes per write.
+type alwaysReadyFile struct {
+	maxWrite int
+}
+
+func (f *alwaysReadyFile) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func (f *alwaysReadyFile) Write(p []byte) (int, error) {
+	return min(len(p), f.maxWrite), nil
+}
+
+func (f *alwaysReadyFile) Close() error {
+	return nil
+}
+
+func (f *alwaysReadyFile) WaitForReady(ctx context.Context) error {
+	return nil
+}
+
+func TestAsyncStream_YieldEvery(t *testing.T) {
+	testEventLoop(t, "yield during bulk transfers", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var ticks int
+		var stopped bool
+		defer func() {
+			stopped = true
+		}()
+		var tick func()
+		tick = func() {
+			ticks++
+			if !stopped {
+				loop.RunCallback(tick)
+			}
+		}
+		loop.RunCallback(tick)
+
+		stream := NewAsyncStream(&alwaysReadyFile{maxWrite: 1})
+		for _, tc := range []struct {
+			yieldEvery int
+			minTicks   int
+			maxTicks   int
+		}{
+			{yieldEvery: 0, minTicks: 6, maxTicks: 8},
+			{yieldEvery: 10, minTicks: 10, maxTicks: 12},
+			{yieldEvery: -1, minTicks: 1, maxTicks: 2},
+		} {
+			stream.SetOptions(StreamOptions{YieldEvery: tc.yieldEvery})
+			ticks = 0
+			if _, err := stream.Write(ctx, make([]byte, 100)).Await(ctx); err != nil {
+				return err
+			}
+			if ticks < tc.minTicks || ticks > tc.maxTicks {
+				t.Errorf("expected writing 100 bytes with YieldEvery %d to take %d to %d ticks, got: %d", tc.yieldEvery, tc.minTicks, tc.maxTicks, ticks)
+			}
+		}
+
+		// an endless read from a file that is always ready can still be cancelled
+		stream.SetOptions(StreamOptions{})
+		reader := SpawnTask(ctx, func(ctx context.Context) (int64, error) {
+			return stream.ReadN(ctx, io.Discard, math.MaxInt64)
+		})
+		for range 5 {
+			if err := Checkpoint(ctx); err != nil {
+				return err
+			}
+		}
+		reader.Cancel(nil)
+		if _, err := reader.Await(ctx); !errors.Is(err, ErrTaskCancelled) {
+			t.Errorf("expected %v, got: %v", ErrTaskCancelled, err)
+		}
+
+		cancelledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := stream.ReadChunk(cancelledCtx, 10); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected read with cancelled context to fail, got: %v", err)
+		}
+		return nil
+	})
+}
+
+func TestAsyncStream_PauseReading(t *testing.T) {
+	testEventLoop(t, "pause reading", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		r, w, err := loop.Pipe()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		defer w.Close()
+
+		if err := r.PauseReading(); err != nil {
+			return err
+		}
+		reader := SpawnTask(ctx, func(ctx context.Context) ([]byte, error) {
+			return r.ReadChunk(ctx, 5)
+		})
+		if _, err := w.Write(ctx, []byte("hello")).Await(ctx); err != nil {
+			return err
+		}
+		if err := Sleep(ctx, time.Millisecond*20); err != nil {
+			return err
+		}
+		if reader.HasResult() {
+			t.Errorf("expected read to be suspended while paused")
+		}
+
+		if err := r.ResumeReading(); err != nil {
+			return err
+		}
+		data, err := reader.Await(ctx)
+		if err != nil {
+			return err
+		}
+		if string(data) != "hello" {
+			t.Errorf("expected %q, got: %q", "hello", data)
+		}
+
+		// closing the stream should wake up paused reads
+		if err := r.PauseReading(); err != nil {
+			return err
+		}
+		reader = SpawnTask(ctx, func(ctx context.Context) ([]byte, error) {
+			return r.ReadChunk(ctx, 5)
+		})
+		if err := loop.Yield(ctx, nil); err != nil {
+			return err
+		}
+		if err := r.Close(); err != nil {
+			return err
+		}
+		if _, err := reader.Await(ctx); !errors.Is(err, ErrStreamClosed) {
+			t.Errorf("expected %v, got: %v", ErrStreamClosed, err)
+		}
+		return nil
+	})
+}
+
+func TestStreamGroup_Drained(t *testing.T) {
+	testEventLoop(t, "stream group drained", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		r, w, err := loop.Pipe()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		defer w.Close()
+
+		group := NewStreamGroup()
+		group.Add(r, w)
+		if err := group.Drained(ctx); err != nil {
+			return err
+		}
+
+		write := w.Write(ctx, []byte("hello\nworld"))
+		if err := group.Drained(ctx); err != nil {
+			return err
+		}
+		if !write.HasResult() {
+			t.Errorf("expected write to have completed")
+		}
+
+		if line, err := r.ReadLine(ctx); err != nil {
+			return err
+		} else if string(line) != "hello\n" {
+			t.Errorf("expected %q, got: %q", "hello\n", line)
+		}
+		drained := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, group.Drained(ctx)
+		})
+		if err := Sleep(ctx, time.Millisecond*10); err != nil {
+			return err
+		}
+		if drained.HasResult() {
+			t.Errorf("expected group not to be drained while data is buffered")
+		}
+
+		if _, err := r.ReadChunk(ctx, 5); err != nil {
+			return err
+		}
+		if _, err := drained.AwaitTimeout(ctx, time.Millisecond*100); err != nil {
+			t.Errorf("expected group to be drained, got: %v", err)
+		}
+
+		// removing a busy stream from the group stops it from being waited for
+		w.Send(ctx, []byte("unread"))
+		if err := Sleep(ctx, time.Millisecond*10); err != nil {
+			return err
+		}
+		drained = SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, group.Drained(ctx)
+		})
+		if _, err := r.ReadChunk(ctx, 2); err != nil {
+			return err
+		}
+		group.Remove(r)
+		if _, err := drained.AwaitTimeout(ctx, time.Millisecond*100); err != nil {
+			t.Errorf("expected group to be drained once the busy stream was removed, got: %v", err)
+		}
+		return nil
+	})
+}
+
+func TestAwaitable_AwaitTimeout(t *testing.T) {
+	testEventLoop(t, "await timeout", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		fut := NewFuture[int]()
+		if _, err := fut.AwaitTimeout(ctx, time.Millisecond*50); !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected %v, got: %v", ErrTimeout, err)
+		}
+		var cerr *CancelledError
+		if !errors.As(fut.Err(), &cerr) {
+			t.Errorf("expected future to be cancelled, got: %v", fut.Err())
+		}
+
+		task := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return 1, Sleep(ctx, time.Millisecond*50)
+		})
+		if res, err := task.AwaitDeadline(ctx, time.Now().Add(time.Second)); err != nil || res != 1 {
+			t.Errorf("expected (1, nil), got: (%d, %v)", res, err)
+		}
+		return nil
+	})
+}
+
+func TestFuture_AddCompletionCallback(t *testing.T) {
+	testEventLoop(t, "completion callback", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		start := time.Now()
+		var completions []Completion
+		record := func(c Completion) {
+			completions = append(completions, c)
+		}
+
+		fromTask := NewFuture[int]()
+		fromTask.AddCompletionCallback(ctx, record)
+		producer := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			if err := Sleep(ctx, time.Millisecond*10); err != nil {
+				return nil, err
+			}
+			fromTask.SetResult(1, nil)
+			return nil, nil
+		}).Future().WithName("producer")
+
+		errFailed := errors.New("failed")
+		fromCallback := NewFuture[int]()
+		fromCallback.AddCompletionCallback(ctx, record)
+		loop.RunCallback(func() {
+			fromCallback.SetResult(0, errFailed)
+		})
+
+		if err := Wait(ctx, WaitAll, producer, fromTask, fromCallback); !errors.Is(err, errFailed) {
+			t.Errorf("expected %v, got: %v", errFailed, err)
+		}
+		if len(completions) != 2 {
+			t.Fatalf("expected 2 completions, got: %d", len(completions))
+		}
+
+		byCallback, byTask := completions[0], completions[1]
+		if byTask.Task == nil || byTask.Task.Name != "producer" || byTask.Err != nil {
+			t.Errorf("expected future to be completed by the producer task, got: %+v", byTask)
+		}
+		if byCallback.Task != nil || byCallback.Err != errFailed {
+			t.Errorf("expected future to be completed outside of a task with an error, got: %+v", byCallback)
+		}
+		for _, c := range completions {
+			if c.Time.Before(start) || c.Time.After(time.Now()) {
+				t.Errorf("unexpected completion time: %s", c.Time)
+			}
+		}
+		return nil
+	})
+}
+
+func TestFuture_RemoveCallback(t *testing.T) {
+	var calls []string
+	fut := NewFuture[int]()
+	fut.AddDoneCallback(func(error) { calls = append(calls, "first") })
+	removed := fut.AddResultCallback(func(int, error) { calls = append(calls, "removed") })
+	fut.AddDoneCallback(func(error) { calls = append(calls, "last") })
+
+	other := NewFuture[int]()
+	if other.RemoveCallback(removed) {
+		t.Errorf("expected removing a callback from the wrong future to fail")
+	}
+	if !fut.RemoveCallback(removed) {
+		t.Errorf("expected callback to be removed")
+	}
+	if fut.RemoveCallback(removed) {
+		t.Errorf("expected removing a callback twice to fail")
+	}
+
+	fut.SetResult(1, nil)
+	if !slices.Equal(calls, []string{"first", "last"}) {
+		t.Errorf("unexpected callbacks run: %v", calls)
+	}
+	if handle := fut.AddDoneCallback(func(error) {}); fut.RemoveCallback(handle) {
+		t.Errorf("expected callback of a completed future not to be removable")
+	}
+}
+
+func TestWait_RemovesCallbacks(t *testing.T) {
+	testEventLoop(t, "wait removes callbacks", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		pending := NewFuture[int]()
+		done := NewFuture[int]()
+		done.SetResult(1, nil)
+
+		// a long-lived future waited on repeatedly doesn't accumulate callbacks
+		for range 3 {
+			if err := Wait(ctx, WaitFirstResult, pending, done); err != nil {
+				return err
+			}
+			for res, err := range AsCompleted[int](ctx, pending, done) {
+				if err != nil || res.Value != 1 {
+					t.Errorf("unexpected result: %v, %v", res, err)
+				}
+				break
+			}
+		}
+		if len(pending.callbacks) != 0 {
+			t.Errorf("expected all callbacks to be removed, got %d", len(pending.callbacks))
+		}
+		return nil
+	})
+}
+
+func TestTask_CancelOnAbandon(t *testing.T) {
+	testEventLoop(t, "cancel on abandon", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		release := NewFuture[int]()
+		task := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return release.Shield().Await(ctx)
+		}).CancelOnAbandon()
+
+		awaiter := func() *Task[int] {
+			return SpawnTask(ctx, func(ctx context.Context) (int, error) {
+				return task.Await(ctx)
+			})
+		}
+		first, second := awaiter(), awaiter()
+		if err := loop.Yield(ctx, nil); err != nil {
+			return err
+		}
+
+		// the task should keep running as long as someone is still waiting for it
+		first.Cancel(nil)
+		if err := loop.Yield(ctx, nil); err != nil {
+			return err
+		}
+		if task.HasResult() {
+			t.Errorf("expected task to keep running while it has an awaiter, got: %v", task.Err())
+		}
+
+		// once the last awaiter is gone, the task should be cancelled as well
+		second.Cancel(nil)
+		if _, err := task.Shield().Await(ctx); !errors.Is(err, ErrTaskCancelled) {
+			t.Errorf("expected abandoned task to be cancelled, got: %v", err)
+		}
+
+		// awaiters that complete normally don't cancel the task
+		task = SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return 1, nil
+		}).CancelOnAbandon()
+		if res, err := awaiter().Await(ctx); err != nil || res != 1 {
+			t.Errorf("expected (1, nil), got: (%d, %v)", res, err)
+		}
+		return nil
+	})
+}
+
+func TestTask_Join(t *testing.T) {
+	testEventLoop(t, "join", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		release := NewFuture[int]()
+		task := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return release.Await(ctx)
+		})
+
+		// cancelling the waiter shouldn't affect the task
+		errStop := errors.New("stop waiting")
+		waiter := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return task.Join(ctx)
+		})
+		if err := loop.Yield(ctx, nil); err != nil {
+			return err
+		}
+		waiter.Cancel(errStop)
+		if _, err := waiter.Await(ctx); !errors.Is(err, errStop) {
+			t.Errorf("expected waiter to be cancelled with %v, got: %v", errStop, err)
+		}
+		if task.HasResult() {
+			t.Errorf("expected task to keep running, got: %v", task.Err())
+		}
+
+		// cancelling the task should be reported to the waiter
+		waiter = SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return task.Join(ctx)
+		})
+		if err := loop.Yield(ctx, nil); err != nil {
+			return err
+		}
+		task.Cancel(nil)
+		var cerr *CancelledError
+		if _, err := waiter.Await(ctx); !errors.As(err, &cerr) || cerr.Cause != ErrTaskCancelled {
+			t.Errorf("expected task cancellation, got: %v", err)
+		}
+		return nil
+	})
+}
+
+func TestAsyncStream_SetOptions(t *testing.T) {
+	testEventLoop(t, "stream options", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		line := append(bytes.Repeat([]byte("a"), 100), '\n')
+		data := append(slices.Clone(line), bytes.Repeat([]byte("b"), 1000)...)
+		stream, closeStream, err := newPipeStream(ctx, loop, append(data, '\n'), 0, 0)
+		if err != nil {
+			return err
+		}
+		defer closeStream()
+
+		stream.SetOptions(StreamOptions{InitialBufferSize: 16, GrowthFactor: 1.5, MaxBufferSize: 512})
+		got, err := stream.ReadLine(ctx)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, line) {
+			t.Errorf("expected %q, got: %q", line, got)
+		}
+
+		// the second line doesn't fit in the buffer
+		if _, err := stream.ReadLine(ctx); !errors.Is(err, ErrBufferFull) {
+			t.Errorf("expected %v, got: %v", ErrBufferFull, err)
+		}
+		return nil
+	})
+}
+
+func TestBudget(t *testing.T) {
+	testEventLoop(t, "budget", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		budget := NewBudget(time.Millisecond * 100)
+
+		// each step only gets what's left of the budget
+		if err := Sleep(ctx, time.Millisecond*60); err != nil {
+			return err
+		}
+		if remaining := budget.Remaining(); remaining > time.Millisecond*40 || remaining <= 0 {
+			t.Errorf("expected at most 40ms to remain, got: %s", remaining)
+		}
+
+		if _, err := NewFuture[int]().AwaitDeadline(ctx, budget.Deadline()); !errors.Is(err, ErrTimeout) {
+			t.Errorf("expected %v, got: %v", ErrTimeout, err)
+		}
+		if !budget.Expired() {
+			t.Errorf("expected budget to have expired")
+		}
+
+		budgetCtx, cancel := budget.Context(ctx)
+		defer cancel()
+		if err := context.Cause(budgetCtx); err != ErrTimeout {
+			t.Errorf("expected context to have expired with %v, got: %v", ErrTimeout, err)
+		}
+		return nil
+	})
+}
+
+func TestBulkhead(t *testing.T) {
+	testEventLoop(t, "bulkhead", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		bulkhead := NewBulkhead(2, 1)
+
+		var running, maxRunning int
+		call := WithBulkhead(bulkhead, func(ctx context.Context) (int, error) {
+			running++
+			maxRunning = max(maxRunning, running)
+			defer func() {
+				running--
+			}()
+			return 1, Sleep(ctx, time.Millisecond*50)
+		})
+
+		var tasks []Awaitable[int]
+		for range 4 {
+			tasks = append(tasks, SpawnTask(ctx, call))
+		}
+		results := WaitAllTyped(ctx, tasks...)
+
+		var succeeded, rejected int
+		for _, res := range results {
+			if res.Err == nil {
+				succeeded++
+			} else if errors.Is(res.Err, ErrBulkheadFull) {
+				rejected++
+			} else {
+				t.Errorf("unexpected error: %v", res.Err)
+			}
+		}
+		if succeeded != 3 || rejected != 1 {
+			t.Errorf("expected 3 calls to succeed and 1 to be rejected, got: %d and %d", succeeded, rejected)
+		}
+		if maxRunning != 2 {
+			t.Errorf("expected at most 2 concurrent calls, got: %d", maxRunning)
+		}
+		if bulkhead.Running() != 0 || bulkhead.Queued() != 0 {
+			t.Errorf("expected bulkhead to be empty, got: %d running, %d queued", bulkhead.Running(), bulkhead.Queued())
+		}
+		return nil
+	})
+}
+
+func TestRequestCache(t *testing.T) {
+	testEventLoop(t, "request cache", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var fetches int
+		cache := NewRequestCache(func(ctx context.Context, key string) (int, error) {
+			fetches++
+			if err := Sleep(ctx, time.Millisecond*10); err != nil {
+				return 0, err
+			}
+			return fetches, nil
+		}, time.Millisecond*50, time.Millisecond*50)
+
+		get := func(ctx context.Context) (int, error) {
+			return cache.Get(ctx, "key")
+		}
+		results := WaitAllTyped[int](ctx, SpawnTask(ctx, get), SpawnTask(ctx, get), SpawnTask(ctx, get))
+		for _, res := range results {
+			if res.Err != nil || res.Value != 1 {
+				t.Errorf("expected concurrent calls to share the first fetch, got: %+v", res)
+			}
+		}
+
+		// stale results are served while being refreshed in the background
+		if err := Sleep(ctx, time.Millisecond*60); err != nil {
+			return err
+		}
+		if value, err := cache.Get(ctx, "key"); err != nil || value != 1 {
+			t.Errorf("expected stale value 1, got: %d, %v", value, err)
+		}
+		if err := Sleep(ctx, time.Millisecond*20); err != nil {
+			return err
+		}
+		if value, err := cache.Get(ctx, "key"); err != nil || value != 2 {
+			t.Errorf("expected refreshed value 2, got: %d, %v", value, err)
+		}
+
+		// results are evicted once they have been stale for too long
+		if err := Sleep(ctx, time.Millisecond*120); err != nil {
+			return err
+		}
+		if cache.Len() != 0 {
+			t.Errorf("expected cache to be empty, got: %d entries", cache.Len())
+		}
+
+		cache.Get(ctx, "key")
+		cache.Invalidate("key")
+		if value, err := cache.Get(ctx, "key"); err != nil || value != 4 {
+			t.Errorf("expected invalidated value to be fetched again, got: %d, %v", value, err)
+		}
+		if fetches != 4 {
+			t.Errorf("expected 4 fetches, got: %d", fetches)
+		}
+		return nil
+	})
+
+	// cached results don't keep the loop running
+	testEventLoop(t, "no timers", false, time.Millisecond*50, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		cache := NewRequestCache(func(ctx context.Context, key string) (string, error) {
+			return key, nil
+		}, time.Second, time.Second)
+		if value, err := cache.Get(ctx, "key"); err != nil || value != "key" {
+			t.Errorf("unexpected result: %v, %v", value, err)
+		}
+		return Sleep(ctx, time.Millisecond*50)
+	})
+}
+
+func TestSupervisor(t *testing.T) {
+	testEventLoop(t, "supervisor", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var unhandled []string
+		loop.SetErrorHandler(func(info TaskInfo, err error) {
+			unhandled = append(unhandled, info.Name+": "+err.Error())
+		})
+
+		sup := NewSupervisor()
+		defer sup.Shutdown()
+		events := make(map[string][]string)
+		collector := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			for event, err := range sup.Events(ctx) {
+				if err != nil {
+					return nil, err
+				}
+				events[event.Child] = append(events[event.Child], fmt.Sprintf("%s %d", event.Kind, event.Restarts))
+			}
+			return nil, nil
+		})
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+
+		errFailed := errors.New("failed")
+		var flakyRuns int
+		specs := []ChildSpec{
+			{Name: "flaky", Restart: RestartOnFailure, MinBackoff: time.Millisecond, Run: func(ctx context.Context) error {
+				if flakyRuns++; flakyRuns < 3 {
+					return errFailed
+				}
+				return nil
+			}},
+			{Name: "limited", Restart: RestartAlways, MaxRestarts: 1, MinBackoff: time.Millisecond, Run: func(ctx context.Context) error {
+				return errFailed
+			}},
+			{Name: "forever", Restart: RestartAlways, Run: func(ctx context.Context) error {
+				return Sleep(ctx, time.Hour)
+			}},
+		}
+		for _, spec := range specs {
+			if err := sup.Start(ctx, spec); err != nil {
+				return err
+			}
+		}
+		if err := sup.Start(ctx, specs[0]); err == nil {
+			t.Errorf("expected starting a child with a duplicate name to fail")
+		}
+
+		if err := Sleep(ctx, time.Millisecond*50); err != nil {
+			return err
+		}
+		if got := sup.Restarts("flaky"); got != -1 {
+			t.Errorf("expected finished child to be removed, got %d restarts", got)
+		}
+		sup.Shutdown()
+		if err := sup.Start(ctx, specs[0]); !errors.Is(err, ErrSupervisorShutdown) {
+			t.Errorf("expected %v, got: %v", ErrSupervisorShutdown, err)
+		}
+		if _, err := collector.Await(ctx); err != nil {
+			return err
+		}
+
+		want := map[string][]string{
+			"flaky": {
+				"started 0", "exited 0", "restarting 0",
+				"started 1", "exited 1", "restarting 1",
+				"started 2", "exited 2", "finished 2",
+			},
+			"limited": {
+				"started 0", "exited 0", "restarting 0",
+				"started 1", "exited 1", "finished 1",
+			},
+			"forever": {"started 0", "stopped 0"},
+		}
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("expected events %v, got: %v", want, events)
+		}
+		// unobserved errors are reported once the failed tasks have been garbage collected
+		for range 10 {
+			runtime.GC()
+			if err := Sleep(ctx, time.Millisecond); err != nil {
+				return err
+			}
+		}
+		if want := []string{"supervisor: limited: failed"}; !slices.Equal(unhandled, want) {
+			t.Errorf("expected %v, got: %v", want, unhandled)
+		}
+		return nil
+	})
+
+	// children are stopped once the main task exits, rather than keeping the loop running
+	var childErr error
+	testEventLoop(t, "shutdown on exit", false, time.Millisecond*50, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		sup := NewSupervisor()
+		if err := sup.Start(ctx, ChildSpec{Name: "forever", Run: func(ctx context.Context) error {
+			childErr = Sleep(ctx, time.Hour)
+			return childErr
+		}}); err != nil {
+			return err
+		}
+		return Sleep(ctx, time.Millisecond*50)
+	})
+	if !errors.Is(childErr, ErrSupervisorShutdown) {
+		t.Errorf("expected child to be stopped with %v, got: %v", ErrSupervisorShutdown, childErr)
+	}
+}
+
+func TestQueue_Iter(t *testing.T) {
+	testEventLoop(t, "queue iter", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var queue Queue[int]
+		queue.Push(1)
+		SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			for i := 2; i <= 4; i++ {
+				if err := loop.Yield(ctx, nil); err != nil {
+					return nil, err
+				}
+				queue.Push(i)
+			}
+			queue.Push(5)
+			queue.Close()
+			if err := queue.Push(6); !errors.Is(err, ErrQueueClosed) {
+				t.Errorf("expected push after close to fail with %v, got: %v", ErrQueueClosed, err)
+			}
+			return nil, nil
+		})
+
+		var got []int
+		var err error
+		for item := range queue.Iter(ctx).UntilErr(&err) {
+			got = append(got, item)
+		}
+		if err != nil {
+			return err
+		}
+		if want := []int{1, 2, 3, 4, 5}; !slices.Equal(got, want) {
+			t.Errorf("expected %v, got: %v", want, got)
+		}
+		if _, err := queue.Get().Await(ctx); !errors.Is(err, ErrQueueClosed) {
+			t.Errorf("expected %v, got: %v", ErrQueueClosed, err)
+		}
+		return nil
+	})
+}
+
+func TestQueue_Bounded(t *testing.T) {
+	testEventLoop(t, "bounded queue", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		queue := NewBoundedQueue[int](2)
+		for i := range 2 {
+			if err := queue.PutNoWait(i); err != nil {
+				return err
+			}
+		}
+		if err := queue.PutNoWait(2); !errors.Is(err, ErrQueueFull) {
+			t.Errorf("expected %v, got: %v", ErrQueueFull, err)
+		}
+
+		var produced int
+		producer := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			for i := 2; i < 6; i++ {
+				if _, err := queue.Put(i).Await(ctx); err != nil {
+					return nil, err
+				}
+				produced = i
+			}
+			queue.Close()
+			return nil, nil
+		})
+
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+		if produced != 0 {
+			t.Errorf("expected producer to wait for space, but it produced %d", produced)
+		}
+
+		var got []int
+		var err error
+		for item := range queue.Iter(ctx).UntilErr(&err) {
+			got = append(got, item)
+			if item+2 < 6 && produced > item+2 {
+				t.Errorf("expected producer to stay within capacity, got item %d after consuming %d", produced, item)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if want := []int{0, 1, 2, 3, 4, 5}; !slices.Equal(got, want) {
+			t.Errorf("expected %v, got: %v", want, got)
+		}
+
+		if _, err := queue.Put(6).Await(ctx); !errors.Is(err, ErrQueueClosed) {
+			t.Errorf("expected %v, got: %v", ErrQueueClosed, err)
+		}
+		_, err = producer.Await(ctx)
+		return err
+	})
+}
+
+func TestQueue_Join(t *testing.T) {
+	testEventLoop(t, "queue join", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var queue Queue[int]
+		if err := queue.Join(ctx); err != nil {
+			t.Errorf("expected join on empty queue to succeed immediately, got: %v", err)
+		}
+
+		var processed []int
+		for range 3 {
+			SpawnTask(ctx, func(ctx context.Context) (any, error) {
+				for item, err := range queue.Iter(ctx) {
+					if err != nil {
+						return nil, err
+					}
+					if err := Sleep(ctx, time.Millisecond*time.Duration(item)); err != nil {
+						return nil, err
+					}
+					processed = append(processed, item)
+					queue.TaskDone()
+				}
+				return nil, nil
+			})
+		}
+
+		for i := range 10 {
+			queue.Push(i)
+		}
+		if err := queue.Join(ctx); err != nil {
+			return err
+		}
+		if len(processed) != 10 {
+			t.Errorf("expected all 10 items to be processed, got: %v", processed)
+		}
+		queue.Close()
+		return nil
+	})
+}
+
+func TestQueue_Ordering(t *testing.T) {
+	testEventLoop(t, "queue ordering", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		type job struct {
+			name     string
+			priority int
+		}
+		queues := []struct {
+			name  string
+			queue interface {
+				Push(item job) error
+				Get() *Future[job]
+			}
+			want []string
+		}{
+			{"fifo", &Queue[job]{}, []string{"a", "b", "c", "d"}},
+			{"lifo", NewLIFOQueue[job](0), []string{"a", "d", "c", "b"}},
+			{"priority", NewPriorityQueue(0, func(a, b job) bool {
+				return a.priority > b.priority
+			}), []string{"a", "c", "d", "b"}},
+		}
+
+		for _, tt := range queues {
+			// the first item should go straight to the waiting getter
+			first := tt.queue.Get()
+			for _, j := range []job{{"a", 3}, {"b", 1}, {"c", 4}, {"d", 2}} {
+				tt.queue.Push(j)
+			}
+
+			got := []string{first.MustAwait(ctx).name}
+			for range 3 {
+				item, err := tt.queue.Get().Await(ctx)
+				if err != nil {
+					return err
+				}
+				got = append(got, item.name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%s: expected %v, got: %v", tt.name, tt.want, got)
+			}
+		}
+
+		// a bounded priority queue should make producers wait, but still pop in priority order
+		queue := NewPriorityQueue(2, func(a, b int) bool { return a < b })
+		for _, i := range []int{5, 3} {
+			queue.Put(i)
+		}
+		blocked := queue.Put(1)
+		if blocked.HasResult() {
+			t.Errorf("expected put to full queue to wait")
+		}
+		var got []int
+		for range 3 {
+			got = append(got, queue.Get().MustAwait(ctx))
+		}
+		if want := []int{3, 1, 5}; !slices.Equal(got, want) {
+			t.Errorf("expected %v, got: %v", want, got)
+		}
+		return nil
+	})
+}
+
+func TestThreadsafeQueue(t *testing.T) {
+	testEventLoop(t, "threadsafe queue", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		queue := NewThreadsafeQueue[int](loop)
+
+		var wg sync.WaitGroup
+		for producer := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 25 {
+					queue.Push(producer*25 + i)
+				}
+			}()
+		}
+		go func() {
+			wg.Wait()
+			queue.Close()
+		}()
+
+		var got []int
+		var err error
+		for item := range queue.Iter(ctx).UntilErr(&err) {
+			got = append(got, item)
+		}
+		if err != nil {
+			return err
+		}
+
+		slices.Sort(got)
+		if len(got) != 100 || got[0] != 0 || got[99] != 99 {
+			t.Errorf("expected to receive items 0 to 99, got: %v", got)
+		}
+		return nil
+	})
+}
+
+func TestThreadsafeFuture(t *testing.T) {
+	testEventLoop(t, "threadsafe future", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		fut := NewThreadsafeFuture[int](loop)
+		go fut.SetResult(1, nil)
+		if res, err := fut.Await(ctx); err != nil || res != 1 {
+			t.Errorf("expected result 1, got: %v, %v", res, err)
+		}
+
+		cancelled := NewThreadsafeFuture[int](loop)
+		go cancelled.Cancel(nil)
+		if _, err := cancelled.Await(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected future to be cancelled, got: %v", err)
+		}
+		return nil
+	})
+}
+
+func TestTask_ResultChan(t *testing.T) {
+	testEventLoop(t, "result channel", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		errFailed := errors.New("failed")
+		succeeding := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return 42, Sleep(ctx, time.Millisecond*10)
+		})
+		failing := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			return 0, errFailed
+		})
+
+		// wait for both tasks from a plain goroutine, handing the results back to the loop
+		results := NewThreadsafeFuture[[]Result[int]](loop)
+		succeededCh, failedCh := succeeding.ResultChan(), failing.ResultChan()
+		go func() {
+			var got []Result[int]
+			timeout := time.After(time.Second)
+			for len(got) < 2 {
+				select {
+				case res := <-succeededCh:
+					got = append(got, res)
+				case res := <-failedCh:
+					got = append(got, res)
+				case <-timeout:
+					results.SetResult(got, ErrTimeout)
+					return
+				}
+			}
+			results.SetResult(got, nil)
+		}()
+
+		got, err := results.Await(ctx)
+		if err != nil {
+			return err
+		}
+		if len(got) != 2 || got[0].Err != errFailed || got[1].Value != 42 || got[1].Err != nil {
+			t.Errorf("expected the failing task to complete first, then the succeeding one, got: %v", got)
+		}
+		return nil
+	})
+}
+
+func TestQueue_Overflow(t *testing.T) {
+	testEventLoop(t, "queue overflow", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		for _, tt := range []struct {
+			name    string
+			policy  OverflowPolicy
+			want    []int
+			dropped int
+		}{
+			{"drop oldest", OverflowDropOldest, []int{3, 4, 5}, 2},
+			{"drop newest", OverflowDropNewest, []int{1, 2, 3}, 2},
+			{"conflate", OverflowConflate, []int{5}, 4},
+		} {
+			queue := NewDroppingQueue[int](3, tt.policy)
+			for i := 1; i <= 5; i++ {
+				if _, err := queue.Put(i).Await(ctx); err != nil {
+					return err
+				}
+			}
+			queue.Close()
+
+			var got []int
+			var err error
+			for item := range queue.Iter(ctx).UntilErr(&err) {
+				got = append(got, item)
+				queue.TaskDone()
+			}
+			if err != nil {
+				return err
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%s: expected %v, got: %v", tt.name, tt.want, got)
+			}
+			if queue.Dropped() != tt.dropped {
+				t.Errorf("%s: expected %d dropped items, got: %d", tt.name, tt.dropped, queue.Dropped())
+			}
+			if err := queue.Join(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func TestQueue_GetBatch(t *testing.T) {
+	testEventLoop(t, "get batch", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		queue := NewPriorityQueue(0, func(a, b int) bool { return a < b })
+		for _, i := range []int{5, 2, 7, 1, 4, 3} {
+			queue.Push(i)
+		}
+
+		// enough items are available, so the batch should be returned immediately
+		batch, err := queue.GetBatch(ctx, 4, time.Hour)
+		if err != nil {
+			return err
+		}
+		if want := []int{1, 2, 3, 4}; !slices.Equal(batch, want) {
+			t.Errorf("expected %v, got: %v", want, batch)
+		}
+
+		// wait for more items until the maximum wait time has elapsed
+		SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			if err := Sleep(ctx, time.Millisecond*10); err != nil {
+				return nil, err
+			}
+			queue.Push(6)
+			return nil, nil
+		})
+		batch, err = queue.GetBatch(ctx, 4, time.Millisecond*50)
+		if err != nil {
+			return err
+		}
+		if want := []int{5, 7, 6}; !slices.Equal(batch, want) {
+			t.Errorf("expected %v, got: %v", want, batch)
+		}
+
+		batch, err = queue.GetBatch(ctx, 4, time.Millisecond)
+		if err != nil || len(batch) != 0 {
+			t.Errorf("expected empty batch, got: (%v, %v)", batch, err)
+		}
+
+		// a non-positive batch size is treated as 1
+		queue.Push(10)
+		queue.Push(9)
+		batch, err = queue.GetBatch(ctx, -1, time.Hour)
+		if err != nil || !slices.Equal(batch, []int{9}) {
+			t.Errorf("expected [9], got: (%v, %v)", batch, err)
+		}
+		_, _ = queue.Get().Await(ctx)
+
+		queue.Push(8)
+		queue.Close()
+		batch, err = queue.GetBatch(ctx, 4, time.Hour)
+		if err != nil || !slices.Equal(batch, []int{8}) {
+			t.Errorf("expected remaining items [8], got: (%v, %v)", batch, err)
+		}
+		if _, err := queue.GetBatch(ctx, 4, time.Hour); !errors.Is(err, ErrQueueClosed) {
+			t.Errorf("expected %v, got: %v", ErrQueueClosed, err)
+		}
+		return nil
+	})
+}
+
+func TestSemaphore(t *testing.T) {
+	testEventLoop(t, "semaphore", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		sem := NewBoundedSemaphore(2)
+
+		var running, maxRunning int
+		var futs []Futurer
+		for range 5 {
+			futs = append(futs, SpawnTask(ctx, func(ctx context.Context) (any, error) {
+				return WithSemaphore(ctx, sem, func(ctx context.Context) (any, error) {
+					running++
+					maxRunning = max(maxRunning, running)
+					defer func() {
+						running--
+					}()
+					return nil, Sleep(ctx, time.Millisecond*50)
+				})
+			}))
+		}
+		if err := Wait(ctx, WaitAll, futs...); err != nil {
+			return err
+		}
+		if maxRunning != 2 {
+			t.Errorf("expected at most 2 concurrent holders, got: %d", maxRunning)
+		}
+
+		if !sem.TryAcquire() || !sem.TryAcquire() || sem.TryAcquire() {
+			t.Errorf("expected semaphore to be acquirable exactly twice")
+		}
+		sem.Release()
+		sem.Release()
+
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected over-release of bounded semaphore to panic")
+			}
+		}()
+		sem.Release()
+		return nil
+	})
+}
+
+func TestDistribute(t *testing.T) {
+	testEventLoop(t, "distribute", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		source := AsyncIter(func(yield func(int) error) error {
+			for i := range 20 {
+				if err := yield(i); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		errFailed := errors.New("failed")
+		var running, maxRunning int
+		processed := map[int]int{}
+		var deadLetters []int
+		err := Distribute(ctx, source, 3, func(ctx context.Context, item int) error {
+			running++
+			maxRunning = max(maxRunning, running)
+			defer func() {
+				running--
+			}()
+			if err := Sleep(ctx, time.Millisecond); err != nil {
+				return err
+			}
+
+			processed[item]++
+			// odd items succeed on their second attempt, multiples of 5 never succeed
+			if item%5 == 0 || (item%2 == 1 && processed[item] == 1) {
+				return errFailed
+			}
+			return nil
+		}, DistributeOptions[int]{
+			Retry: func(item int, attempt int, err error) bool {
+				return attempt < 2
+			},
+			DeadLetter: func(item int, err error) {
+				deadLetters = append(deadLetters, item)
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		if maxRunning != 3 {
+			t.Errorf("expected 3 concurrent workers, got: %d", maxRunning)
+		}
+		for i := range 20 {
+			want := 1
+			if i%5 == 0 || i%2 == 1 {
+				want = 2
+			}
+			if processed[i] != want {
+				t.Errorf("expected item %d to be processed %d time(s), got: %d", i, want, processed[i])
+			}
+		}
+		slices.Sort(deadLetters)
+		if want := []int{0, 5, 10, 15}; !slices.Equal(deadLetters, want) {
+			t.Errorf("expected dead letters %v, got: %v", want, deadLetters)
+		}
+
+		// without a dead letter callback, a terminal failure stops processing
+		err = Distribute(ctx, source, 3, func(ctx context.Context, item int) error {
+			return errFailed
+		}, DistributeOptions[int]{})
+		if !errors.Is(err, errFailed) {
+			t.Errorf("expected %v, got: %v", errFailed, err)
+		}
+		return nil
+	})
+}
+
+func TestPartitionBy(t *testing.T) {
+	testEventLoop(t, "partition by key", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		type item struct {
+			key string
+			seq int
+		}
+		errSource := errors.New("source failed")
+		source := func(fail bool) AsyncIterable[item] {
+			return AsyncIter(func(yield func(item) error) error {
+				for seq := range 10 {
+					for _, key := range []string{"a", "b", "c", "d", "e"} {
+						if err := yield(item{key, seq}); err != nil {
+							return err
+						}
+					}
+				}
+				if fail {
+					return errSource
+				}
+				return nil
+			})
+		}
+		consume := func(partitions []AsyncIterable[item], limit int) ([][]item, []error) {
+			got := make([][]item, len(partitions))
+			var coros []Coroutine1
+			for i, partition := range partitions {
+				coros = append(coros, func(ctx context.Context) error {
+					for it, err := range partition {
+						if err != nil {
+							return err
+						}
+						got[i] = append(got[i], it)
+						if len(got[i]) == limit {
+							break
+						}
+						// process the items slowly, interleaving the partitions
+						if err := Checkpoint(ctx); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			}
+			var errs []error
+			for _, res := range GatherResults(ctx, Map(AsIterator(coros), func(coro Coroutine1) Coroutine2[any] {
+				return func(ctx context.Context) (any, error) {
+					return nil, coro(ctx)
+				}
+			}).Collect()...) {
+				errs = append(errs, res.Err)
+			}
+			return got, errs
+		}
+
+		got, errs := consume(PartitionBy(ctx, source(false), func(it item) string { return it.key }, 3), -1)
+		partitionOf := make(map[string]int)
+		var total int
+		for i, items := range got {
+			if errs[i] != nil {
+				t.Errorf("partition %d failed: %v", i, errs[i])
+			}
+			lastSeq := make(map[string]int)
+			for _, it := range items {
+				if p, ok := partitionOf[it.key]; ok && p != i {
+					t.Errorf("key %s seen in partitions %d and %d", it.key, p, i)
+				}
+				partitionOf[it.key] = i
+				if last, ok := lastSeq[it.key]; ok && it.seq != last+1 {
+					t.Errorf("expected items for key %s in order, got %d after %d", it.key, it.seq, last)
+				}
+				lastSeq[it.key] = it.seq
+				total++
+			}
+		}
+		if total != 50 {
+			t.Errorf("expected 50 items, got: %d", total)
+		}
+
+		// a partition stopping early doesn't hold up the others, and source errors reach every partition
+		// keys are hashed using a random seed, so every key may end up in the same partition
+		got, errs = consume(PartitionBy(ctx, source(true), func(it item) string { return it.key }, 2), 1)
+		var stopped int
+		for i := range got {
+			if len(got[i]) == 0 {
+				continue
+			}
+			stopped++
+			if len(got[i]) != 1 || errs[i] != nil {
+				t.Errorf("expected partition %d to stop after 1 item, got: %v, %v", i, got[i], errs[i])
+			}
+		}
+		if stopped == 0 {
+			t.Errorf("expected at least one partition to receive items")
+		}
+		got, errs = consume(PartitionBy(ctx, source(true), func(it item) string { return it.key }, 2), -1)
+		for i := range got {
+			if !errors.Is(errs[i], errSource) {
+				t.Errorf("expected partition %d to fail with %v, got: %v", i, errSource, errs[i])
+			}
+		}
+		return nil
+	})
+}
+
+func TestCheckpoint(t *testing.T) {
+	testEventLoop(t, "checkpoint", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		// count the number of loop ticks using a callback that keeps rescheduling itself
+		var ticks int
+		var stopped bool
+		defer func() {
+			stopped = true
+		}()
+		var tick func()
+		tick = func() {
+			ticks++
+			if !stopped {
+				loop.RunCallback(tick)
+			}
+		}
+		loop.RunCallback(tick)
+
+		for range 10 {
+			if err := Checkpoint(ctx); err != nil {
+				return err
+			}
+		}
+		if ticks < 10 {
+			t.Errorf("expected the loop to run at least 10 ticks, got: %d", ticks)
+		}
+
+		ticks = 0
+		source := AsyncIter(func(yield func(int) error) error {
+			for i := range 100 {
+				if err := yield(i); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		var err error
+		for range source.WithCheckpoints(ctx, 10).UntilErr(&err) {
+		}
+		if err != nil {
+			return err
+		}
+		if ticks < 10 {
+			t.Errorf("expected the loop to run at least 10 ticks while iterating, got: %d", ticks)
+		}
+
+		// a CPU-bound loop notices cancellation at its next checkpoint
+		var iterations int
+		var checkpointErr error
+		busy := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			for {
+				iterations++
+				if checkpointErr = Checkpoint(ctx); checkpointErr != nil {
+					return nil, checkpointErr
+				}
+			}
+		})
+		for range 5 {
+			if err := Checkpoint(ctx); err != nil {
+				return err
+			}
+		}
+		busy.Cancel(nil)
+		if _, err := busy.Await(ctx); !errors.Is(err, ErrTaskCancelled) {
+			t.Errorf("expected %v, got: %v", ErrTaskCancelled, err)
+		}
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+		if !errors.Is(checkpointErr, ErrTaskCancelled) || iterations == 0 {
+			t.Errorf("expected checkpoint to return %v after some iterations, got %v after %d", ErrTaskCancelled, checkpointErr, iterations)
+		}
+
+		errStop := errors.New("stop")
+		cancelledCtx, cancel := context.WithCancelCause(ctx)
+		cancel(errStop)
+		var cerr *CancelledError
+		if err := Checkpoint(cancelledCtx); !errors.As(err, &cerr) || cerr.Cause != errStop {
+			t.Errorf("expected cancellation with cause %v, got: %v", errStop, err)
+		}
+		return nil
+	})
+}
+
+func TestReassemble(t *testing.T) {
+	testEventLoop(t, "fragment and reassemble", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		if _, err := Fragment(1, []byte("hello"), 8); err == nil {
+			t.Errorf("expected an mtu too small for the header to be rejected")
+		}
+
+		payloads := map[uint32]string{
+			1: "the quick brown fox jumps over the lazy dog",
+			2: "",
+			3: "short",
+		}
+		var fragments [][][]byte
+		for id := range uint32(len(payloads)) {
+			it, err := Fragment(id+1, []byte(payloads[id+1]), 16)
+			if err != nil {
+				return err
+			}
+			fragments = append(fragments, it.Collect())
+		}
+		if len(fragments[0]) != 6 || len(fragments[1]) != 1 || len(fragments[2]) != 1 {
+			t.Fatalf("unexpected fragment counts: %d, %d, %d", len(fragments[0]), len(fragments[1]), len(fragments[2]))
+		}
+
+		// interleave the messages, reverse the first one, and throw in a duplicate and some garbage
+		datagrams := [][]byte{fragments[0][5], fragments[0][4], fragments[1][0], fragments[0][3], fragments[0][3], []byte("junk")}
+		datagrams = append(datagrams, fragments[0][2], fragments[2][0], fragments[0][1], fragments[0][0])
+		var got []Message
+		source := AsyncIter(func(yield func([]byte) error) error {
+			for _, datagram := range datagrams {
+				if err := yield(datagram); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		for msg, err := range Reassemble(ctx, source, time.Second) {
+			if err != nil {
+				return err
+			}
+			got = append(got, msg)
+		}
+		want := []Message{{ID: 2, Payload: []byte{}}, {ID: 3, Payload: []byte("short")}, {ID: 1, Payload: []byte(payloads[1])}}
+		if len(got) != len(want) {
+			t.Fatalf("expected %d messages, got: %v", len(want), got)
+		}
+		for i := range want {
+			if got[i].ID != want[i].ID || string(got[i].Payload) != string(want[i].Payload) {
+				t.Errorf("expected message %d to be %d %q, got: %d %q", i, want[i].ID, want[i].Payload, got[i].ID, got[i].Payload)
+			}
+		}
+
+		// incomplete messages are discarded after the timeout
+		r := NewReassembler(time.Millisecond * 20)
+		defer r.Close()
+		if _, ok, err := r.Add(ctx, fragments[0][0]); ok || err != nil {
+			t.Errorf("expected first fragment to be accepted, got: %v, %v", ok, err)
+		}
+		if _, _, err := r.Add(ctx, []byte("junk")); !errors.Is(err, ErrMalformedFragment) {
+			t.Errorf("expected %v, got: %v", ErrMalformedFragment, err)
+		}
+		if err := Sleep(ctx, time.Millisecond*40); err != nil {
+			return err
+		}
+		if r.Pending() != 0 || r.Expired() != 1 {
+			t.Errorf("expected 0 pending and 1 expired message, got: %d and %d", r.Pending(), r.Expired())
+		}
+		return nil
+	})
+}
+
+func TestBarrier(t *testing.T) {
+	testEventLoop(t, "barrier", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		barrier := NewBarrier(3)
+
+		// run two phases, making sure nobody starts the second phase before everybody finishes the first
+		var phase1, phase2 int
+		var futs []Futurer
+		for i := range 3 {
+			futs = append(futs, SpawnTask(ctx, func(ctx context.Context) (any, error) {
+				if err := Sleep(ctx, time.Millisecond*time.Duration(i*10)); err != nil {
+					return nil, err
+				}
+				phase1++
+				if err := barrier.Wait(ctx); err != nil {
+					return nil, err
+				}
+				if phase1 != 3 {
+					t.Errorf("expected all parties to finish phase 1, got: %d", phase1)
+				}
+				phase2++
+				return nil, barrier.Wait(ctx)
+			}))
+		}
+		if err := Wait(ctx, WaitAll, futs...); err != nil {
+			return err
+		}
+		if phase2 != 3 {
+			t.Errorf("expected all parties to finish phase 2, got: %d", phase2)
+		}
+
+		// cancelling a waiter should break the barrier for the others
+		first := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, barrier.Wait(ctx)
+		})
+		second := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, barrier.Wait(ctx)
+		})
+		if err := loop.Yield(ctx, nil); err != nil {
+			return err
+		}
+		first.Cancel(nil)
+		if _, err := second.Await(ctx); !errors.Is(err, ErrBarrierBroken) {
+			t.Errorf("expected %v, got: %v", ErrBarrierBroken, err)
+		}
+		if err := barrier.Wait(ctx); !errors.Is(err, ErrBarrierBroken) {
+			t.Errorf("expected %v, got: %v", ErrBarrierBroken, err)
+		}
+
+		barrier.Reset()
+		if barrier.Broken() {
+			t.Errorf("expected barrier to no longer be broken after reset")
+		}
+		return nil
+	})
+}
+
+func TestEventLoop_ProfileFor(t *testing.T) {
+	testEventLoop(t, "profile for", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var profile, execTrace bytes.Buffer
+		if err := loop.ProfileFor(ctx, time.Millisecond*100, &profile, &execTrace); err != nil {
+			return err
+		}
+		if profile.Len() == 0 || execTrace.Len() == 0 {
+			t.Errorf("expected profile and trace to be written, got %d and %d bytes", profile.Len(), execTrace.Len())
+		}
+		return nil
+	})
+}
+
+func TestGoWithOptions(t *testing.T) {
+	testEventLoop(t, "go with options", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		type key struct{}
+		deadline := time.Now().Add(time.Hour)
+		callerCtx, cancel := context.WithDeadline(context.WithValue(ctx, key{}, "value"), deadline)
+		cancel()
+
+		type observed struct {
+			value       any
+			err         error
+			deadline    time.Time
+			hasDeadline bool
+		}
+		observe := func(ctx context.Context) (observed, error) {
+			d, ok := ctx.Deadline()
+			return observed{value: ctx.Value(key{}), err: ctx.Err(), deadline: d, hasDeadline: ok}, nil
+		}
+
+		// detached goroutines keep the context values, but not the cancellation
+		got, err := GoWithOptions(callerCtx, GoOptions{Detach: true}, observe).Await(ctx)
+		if err != nil {
+			return err
+		}
+		if got.value != "value" || got.err != nil || got.hasDeadline {
+			t.Errorf("expected detached context with values only, got: %+v", got)
+		}
+
+		got, err = GoWithOptions(callerCtx, GoOptions{Detach: true, KeepDeadline: true}, observe).Await(ctx)
+		if err != nil {
+			return err
+		}
+		if got.err != nil || !got.deadline.Equal(deadline) {
+			t.Errorf("expected detached context with the caller's deadline, got: %+v", got)
+		}
+
+		_, err = GoWithOptions(ctx, GoOptions{Timeout: time.Millisecond * 10}, func(ctx context.Context) (any, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}).Await(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected %v, got: %v", context.DeadlineExceeded, err)
+		}
+		return nil
+	})
+}
+
+func TestOffloadIfSlow(t *testing.T) {
+	testEventLoop(t, "offload if slow", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var ticks atomic.Int64
+		ticker := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			for {
+				ticks.Add(1)
+				if err := Sleep(ctx, time.Millisecond); err != nil {
+					return nil, err
+				}
+			}
+		})
+		defer ticker.Cancel(nil)
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+
+		// fast work blocks the loop like inline code
+		before := ticks.Load()
+		res, err := OffloadIfSlow(ctx, time.Second, func(ctx context.Context, checkpoint func()) (int, error) {
+			for range 4 {
+				time.Sleep(time.Millisecond * 5)
+				checkpoint()
+			}
+			return 1, nil
+		})
+		if err != nil || res != 1 {
+			t.Errorf("expected result 1, got: %v, %v", res, err)
+		} else if ticks.Load() != before {
+			t.Errorf("expected the loop to be blocked, got %d ticks", ticks.Load()-before)
+		}
+
+		// slow work is moved off the loop at the first checkpoint past the threshold
+		before = ticks.Load()
+		var inlineSteps, steps int
+		res, err = OffloadIfSlow(ctx, time.Millisecond*10, func(ctx context.Context, checkpoint func()) (int, error) {
+			for range 20 {
+				time.Sleep(time.Millisecond * 5)
+				if ticks.Load() == before {
+					inlineSteps++
+				}
+				steps++
+				checkpoint()
+			}
+			return 2, nil
+		})
+		if err != nil || res != 2 || steps != 20 {
+			t.Errorf("expected result 2 after 20 steps, got: %v, %v after %d steps", res, err, steps)
+		} else if ticks.Load() == before {
+			t.Errorf("expected the loop to keep running")
+		} else if inlineSteps < 2 || inlineSteps > 10 {
+			t.Errorf("expected the first few steps to run inline, got %d inline steps", inlineSteps)
+		}
+		return nil
+	})
+}
+
+func TestEventLoop_SetWaitAudit(t *testing.T) {
+	testEventLoop(t, "wait audit", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		loop.SetWaitAudit(true)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if err := Sleep(timeoutCtx, time.Millisecond); err != nil {
+			return err
+		}
+		if len(loop.UnboundedWaits()) != 0 {
+			t.Errorf("expected waits with a deadline not to be recorded, got: %+v", loop.UnboundedWaits())
+		}
+
+		for range 2 {
+			if err := Sleep(ctx, time.Millisecond); err != nil {
+				return err
+			}
+		}
+		if err := Sleep(context.WithoutCancel(ctx), time.Millisecond); err != nil {
+			return err
+		}
+
+		waits := loop.UnboundedWaits()
+		if len(waits) != 2 {
+			t.Fatalf("expected 2 unbounded wait locations, got: %+v", waits)
+		}
+		slices.SortFunc(waits, func(a, b UnboundedWait) int { return cmp.Compare(a.Count, b.Count) })
+		if waits[0].Count != 1 || !waits[0].Detached || waits[1].Count != 2 || waits[1].Detached {
+			t.Errorf("unexpected unbounded waits: %+v", waits)
+		}
+		for _, w := range waits {
+			if !strings.Contains(w.Location, "loop_test.go") {
+				t.Errorf("expected wait to be attributed to the test, got: %s", w.Location)
+			}
+		}
+
+		var dump strings.Builder
+		if err := loop.DumpState(&dump); err != nil {
+			return err
+		}
+		if !strings.Contains(dump.String(), "2 locations waited without a deadline") {
+			t.Errorf("expected unbounded waits in dump, got:\n%s", dump.String())
+		}
+
+		loop.SetWaitAudit(false)
+		if len(loop.UnboundedWaits()) != 0 {
+			t.Errorf("expected disabling the audit to discard recorded waits")
+		}
+		return nil
+	})
+}
+
+func TestEventLoop_SetLockMetrics(t *testing.T) {
+	testEventLoop(t, "lock metrics", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		loop.SetLockMetrics(true)
+
+		mu := (&Mutex{}).WithName("mu")
+		sem := NewSemaphore(1).WithName("sem")
+		holder := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			if err := mu.Lock(ctx); err != nil {
+				return nil, err
+			}
+			defer mu.Unlock()
+			if err := sem.Acquire(ctx); err != nil {
+				return nil, err
+			}
+			defer sem.Release()
+			return nil, Sleep(ctx, time.Millisecond*20)
+		}).WithName("holder")
+		if err := Sleep(ctx, time.Millisecond); err != nil {
+			return err
+		}
+
+		metrics := loop.LockMetrics()
+		if len(metrics) != 2 {
+			t.Fatalf("expected metrics for 2 locks, got: %+v", metrics)
+		}
+		for _, m := range metrics {
+			if m.Acquisitions != 1 || m.Waits != 0 {
+				t.Errorf("unexpected metrics for %s: %+v", m.Name, m)
+			}
+			if wantHolder := map[string]string{"mu": "holder", "sem": ""}[m.Name]; m.Holder != wantHolder {
+				t.Errorf("expected %s to be held by %q, got %q", m.Name, wantHolder, m.Holder)
+			}
+		}
+
+		if err := mu.Lock(ctx); err != nil {
+			return err
+		}
+		mu.Unlock()
+		if err := sem.Acquire(ctx); err != nil {
+			return err
+		}
+		sem.Release()
+		if _, err := holder.Await(ctx); err != nil {
+			return err
+		}
+
+		metrics = loop.LockMetrics()
+		if metrics[0].Name != "mu" || metrics[0].Waits != 1 || metrics[0].Acquisitions != 2 || metrics[0].Holder != "" {
+			t.Errorf("unexpected mutex metrics: %+v", metrics[0])
+		}
+		if metrics[0].MaxWait < time.Millisecond*10 || metrics[0].TotalWait != metrics[0].MaxWait {
+			t.Errorf("unexpected mutex wait times: %+v", metrics[0])
+		}
+		if metrics[1].Name != "sem" || metrics[1].Waits != 0 || metrics[1].Acquisitions != 2 {
+			t.Errorf("unexpected semaphore metrics: %+v", metrics[1])
+		}
+
+		stats := loop.Stats()
+		if stats.LockWaits != 1 || stats.LockWaitTime != metrics[0].TotalWait {
+			t.Errorf("unexpected lock stats: %+v", stats)
+		}
+		var dump strings.Builder
+		if err := loop.DumpState(&dump); err != nil {
+			return err
+		}
+		if !strings.Contains(dump.String(), "2 locks (1 waits") || !strings.Contains(dump.String(), "mu: 2 acquisitions, 1 waits") {
+			t.Errorf("expected lock metrics in dump, got:\n%s", dump.String())
+		}
+
+		loop.SetLockMetrics(false)
+		if len(loop.LockMetrics()) != 0 || loop.Stats().LockWaits != 0 {
+			t.Errorf("expected disabling lock metrics to discard recorded metrics")
+		}
+		return nil
+	})
+}
+
+func TestEventLoop_SetDeadlockDetection(t *testing.T) {
+	testEventLoop(t, "deadlock detection", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		loop.SetDeadlockDetection(true)
+
+		var first, second Mutex
+		lockBoth := func(a, b *Mutex) Coroutine2[any] {
+			return func(ctx context.Context) (any, error) {
+				if err := a.Lock(ctx); err != nil {
+					return nil, err
+				}
+				defer a.Unlock()
+				if err := Sleep(ctx, time.Millisecond*10); err != nil {
+					return nil, err
+				}
+				if err := b.Lock(ctx); err != nil {
+					return nil, err
+				}
+				b.Unlock()
+				return nil, nil
+			}
+		}
+		taskA := SpawnTask(ctx, lockBoth(&first, &second))
+		taskA.Future().WithName("A")
+		taskB := SpawnTask(ctx, lockBoth(&second, &first))
+		taskB.Future().WithName("B")
+
+		// whichever task attempts to complete the cycle fails, letting the other one proceed
+		results := WaitAllTyped[any](ctx, taskA, taskB)
+		var deadlocks int
+		for _, res := range results {
+			var derr *DeadlockError
+			if errors.As(res.Err, &derr) {
+				deadlocks++
+				if len(derr.Cycle) != 3 || derr.Cycle[0] != derr.Cycle[2] {
+					t.Errorf("unexpected cycle: %v", derr.Cycle)
+				}
+			} else if res.Err != nil {
+				t.Errorf("unexpected error: %v", res.Err)
+			}
+		}
+		if deadlocks != 1 {
+			t.Errorf("expected exactly one task to detect the deadlock, got: %d", deadlocks)
+		}
+
+		// awaiting a task that is awaiting you is also a deadlock
+		var inner *Task[any]
+		outer := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			if err := loop.Yield(ctx, nil); err != nil {
+				return nil, err
+			}
+			return inner.Await(ctx)
+		})
+		inner = SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return outer.Await(ctx)
+		})
+		var derr *DeadlockError
+		if _, err := outer.Await(ctx); !errors.As(err, &derr) {
+			t.Errorf("expected deadlock error, got: %v", err)
+		} else if !strings.Contains(derr.Error(), "deadlock detected: task") {
+			t.Errorf("unexpected error message: %s", derr.Error())
+		}
+		return nil
+	})
+}
+
+func TestEventLoop_SetAffinityChecks(t *testing.T) {
+	testEventLoop(t, "affinity checks", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		loop.SetAffinityChecks(true)
+		AssertOnLoop(ctx)
+
+		// awaiting and completing futures from tasks is fine
+		fut := NewFuture[int]()
+		task := SpawnTask(ctx, func(ctx context.Context) (int, error) {
+			AssertOnLoop(ctx)
+			return fut.Await(ctx)
+		})
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+
+		// completing the awaited future from a foreign goroutine is caught
+		p, err := Go(ctx, func(ctx context.Context) (p any, err error) {
+			defer func() {
+				p = recover()
+			}()
+			fut.SetResult(1, nil)
+			return nil, nil
+		}).Await(ctx)
+		if err != nil {
+			return err
+		}
+		var aerr *AffinityError
+		if err, ok := p.(error); !ok || !errors.As(err, &aerr) {
+			t.Fatalf("expected AffinityError panic, got: %v", p)
+		} else if aerr.Op != "Future.SetResult" || len(aerr.Stack) == 0 {
+			t.Errorf("unexpected error: %+v", aerr)
+		}
+
+		fut.SetResult(2, nil)
+		if res, err := task.Await(ctx); err != nil || res != 2 {
+			t.Errorf("unexpected result: %v, %v", res, err)
+		}
+		return nil
+	})
+}
+
+func TestEventLoop_AllTasks(t *testing.T) {
+	testEventLoop(t, "all tasks", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		untracked := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, nil
+		})
+		if tasks := loop.AllTasks(); tasks != nil {
+			t.Errorf("expected no tasks to be listed without task tracking, got: %+v", tasks)
+		}
+		// the main task and any tasks spawned so far aren't tracked
+		loop.SetTaskTracking(true)
+
+		fut := NewFuture[any]().WithName("signal")
+		waiter := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return fut.Await(ctx)
+		}).WithName("waiter")
+		joiner := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return waiter.Await(ctx)
+		})
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+		pending := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, nil
+		})
+
+		tasks := loop.AllTasks()
+		if len(tasks) != 3 {
+			t.Fatalf("expected 3 tasks, got: %+v", tasks)
+		}
+		want := []struct {
+			name      string
+			state     TaskState
+			blockedOn string
+		}{
+			{"waiter", TaskBlocked, "signal"},
+			{fmt.Sprintf("task %d", joiner.info().ID), TaskBlocked, "waiter"},
+			{fmt.Sprintf("task %d", pending.info().ID), TaskPending, ""},
+		}
+		for i, w := range want {
+			if tasks[i].Name != w.name || tasks[i].State != w.state || tasks[i].BlockedOn != w.blockedOn {
+				t.Errorf("expected task %d to be %v, got: %+v", i, w, tasks[i])
+			}
+			if !strings.Contains(tasks[i].SpawnedAt, "loop_test.go") {
+				t.Errorf("expected task %d to be spawned from test, got: %s", i, tasks[i].SpawnedAt)
+			}
+		}
+
+		fut.SetResult(nil, nil)
+		if err := Wait(ctx, WaitAll, untracked, joiner, pending); err != nil {
+			return err
+		}
+		if tasks := loop.AllTasks(); len(tasks) != 0 {
+			t.Errorf("expected no tasks to remain, got: %+v", tasks)
+		}
+		return nil
+	})
+}
+
+func TestEventLoop_StatsThreadsafe(t *testing.T) {
+	testEventLoop(t, "stats threadsafe", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		loop.SetTaskTracking(true)
+		fut := NewFuture[any]()
+		for range 2 {
+			SpawnTask(ctx, func(ctx context.Context) (any, error) {
+				return fut.Await(ctx)
+			}).WithName("waiter")
+		}
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+
+		// poll the loop from a monitoring goroutine while the main task is blocked waiting for it
+		var dump strings.Builder
+		stats, err := Go(ctx, func(ctx context.Context) (LoopStats, error) {
+			if err := loop.DumpStateThreadsafe(ctx, &dump); err != nil {
+				return LoopStats{}, err
+			}
+			return loop.StatsThreadsafe(ctx)
+		}).Await(ctx)
+		if err != nil {
+			return err
+		}
+		fut.SetResult(nil, nil)
+
+		// the main task was spawned before enabling task tracking, so it isn't counted as blocked
+		want := LoopStats{TasksSpawned: 3, Tasks: 3, BlockedTasks: 2}
+		if stats != want {
+			t.Errorf("expected %+v, got: %+v", want, stats)
+		}
+		if !strings.HasPrefix(dump.String(), "3 tasks (2 blocked") || strings.Count(dump.String(), "waiter: blocked") != 2 {
+			t.Errorf("unexpected dump: %s", dump.String())
+		}
+		return nil
+	})
+}
+
+func TestEventLoop_SetErrorHandler(t *testing.T) {
+	testEventLoop(t, "error handler", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var unhandled []string
+		loop.SetErrorHandler(func(info TaskInfo, err error) {
+			unhandled = append(unhandled, info.Name+": "+err.Error())
+		})
+
+		errFailed := errors.New("failed")
+		fail := func(ctx context.Context) (any, error) {
+			return nil, errFailed
+		}
+		SpawnTask(ctx, fail).WithName("forgotten")
+		awaited := SpawnTask(ctx, fail).WithName("awaited")
+		SpawnTask(ctx, fail).WithName("callback").AddDoneCallback(func(error) {})
+		cancelled := SpawnTask(ctx, fail).WithName("cancelled")
+		cancelled.Cancel(nil)
+
+		// tasks that fail before being awaited aren't reported as long as they're awaited eventually
+		if err := Sleep(ctx, time.Millisecond); err != nil {
+			return err
+		}
+		if _, err := awaited.Await(ctx); err != errFailed {
+			t.Errorf("expected %v, got: %v", errFailed, err)
+		}
+
+		// errors are reported once the failed tasks have been garbage collected
+		for range 10 {
+			runtime.GC()
+			if err := Sleep(ctx, time.Millisecond); err != nil {
+				return err
+			}
+		}
+		if want := []string{"forgotten: failed"}; !slices.Equal(unhandled, want) {
+			t.Errorf("expected %v, got: %v", want, unhandled)
+		}
+		return nil
+	})
+}
+
+func TestFromContext(t *testing.T) {
+	testEventLoop(t, "from context", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		errShutdown := errors.New("shutdown")
+		external, cancel := context.WithCancelCause(context.Background())
+		defer cancel(nil)
+		go func() {
+			time.Sleep(time.Millisecond * 20)
+			cancel(errShutdown)
+		}()
+
+		work := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, Sleep(ctx, time.Hour)
+		})
+		done := FromContext(ctx, external)
+		if err := Wait(ctx, WaitFirstResult, work, done); !errors.Is(err, errShutdown) {
+			t.Errorf("expected %v, got: %v", errShutdown, err)
+		}
+		if work.HasResult() {
+			t.Errorf("expected work to still be running")
+		}
+		work.Cancel(nil)
+		return nil
+	})
+}
+
+func TestMutex_LockTimeout(t *testing.T) {
+	testEventLoop(t, "lock timeout", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var mutex Mutex
+		if !mutex.TryLock() {
+			t.Errorf("expected unlocked mutex to be lockable")
+		}
+		if mutex.TryLock() {
+			t.Errorf("expected locked mutex to not be lockable")
+		}
+
+		if err := mutex.LockTimeout(ctx, time.Millisecond*10); !errors.Is(err, ErrLockTimeout) || !errors.Is(err, ErrTimeout) {
+			t.Errorf("expected %v, got: %v", ErrLockTimeout, err)
+		}
+		// timing out shouldn't have unlocked the mutex
+		if mutex.TryLock() {
+			t.Errorf("expected mutex to still be locked after timing out")
+		}
+
+		loop.ScheduleCallback(time.Millisecond*10, mutex.Unlock)
+		if err := mutex.LockTimeout(ctx, time.Second); err != nil {
+			t.Errorf("expected to acquire the lock once unlocked, got: %v", err)
+		}
+		mutex.Unlock()
+		return nil
+	})
+}
+
+func TestAsyncStream_Send(t *testing.T) {
+	testEventLoop(t, "send", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		r, w, err := loop.Pipe()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		var want bytes.Buffer
+		var futs []Futurer
+		for i := range 2000 {
+			frame := []byte(fmt.Sprintf("frame %d\n", i))
+			want.Write(frame)
+			futs = append(futs, w.Send(ctx, frame))
+		}
+		if futs[0] != futs[len(futs)-1] {
+			t.Errorf("expected frames sent in the same iteration to be written in the same batch")
+		}
+
+		reader := SpawnTask(ctx, r.ReadAll)
+		if err := Wait(ctx, WaitAll, futs...); err != nil {
+			return err
+		}
+		// a single frame in a later iteration gets a batch of its own
+		if _, err := w.Send(ctx, []byte("last\n")).Await(ctx); err != nil {
+			return err
+		}
+		want.WriteString("last\n")
+		if err := w.Close(); err != nil {
+			return err
+		}
+
+		got, err := reader.Await(ctx)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Errorf("sent data did not match, got %d bytes, expected %d", len(got), want.Len())
+		}
+		return nil
+	})
+}
+
+func TestMutex_Fairness(t *testing.T) {
+	testEventLoop(t, "mutex fairness", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var mutex Mutex
+		if err := mutex.Lock(ctx); err != nil {
+			return err
+		}
+
+		// waiters should be granted the lock in the order they requested it
+		var order []int
+		var futs []Futurer
+		for i := range 5 {
+			futs = append(futs, SpawnTask(ctx, func(ctx context.Context) (any, error) {
+				if err := Sleep(ctx, time.Millisecond*time.Duration(i)); err != nil {
+					return nil, err
+				}
+				if err := mutex.Lock(ctx); err != nil {
+					return nil, err
+				}
+				defer mutex.Unlock()
+				order = append(order, i)
+				return nil, Checkpoint(ctx)
+			}))
+		}
+		if err := Sleep(ctx, time.Millisecond*10); err != nil {
+			return err
+		}
+		mutex.Unlock()
+		if err := Wait(ctx, WaitAll, futs...); err != nil {
+			return err
+		}
+		if want := []int{0, 1, 2, 3, 4}; !slices.Equal(order, want) {
+			t.Errorf("expected waiters to acquire the lock in order %v, got: %v", want, order)
+		}
+
+		// a task repeatedly relocking shouldn't starve others
+		var hotIterations int
+		hot := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			for range 100 {
+				if err := mutex.Lock(ctx); err != nil {
+					return nil, err
+				}
+				hotIterations++
+				err := Checkpoint(ctx)
+				mutex.Unlock()
+				if err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		})
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+		if err := mutex.Lock(ctx); err != nil {
+			return err
+		}
+		if hotIterations > 2 {
+			t.Errorf("expected to acquire the lock after at most 2 iterations of the hot task, got: %d", hotIterations)
+		}
+		mutex.Unlock()
+		_, err := hot.Await(ctx)
+		return err
+	})
+}
+
+func TestStreamFile(t *testing.T) {
+	for _, file := range []string{"stream_1.txt", "stream_2.txt", "stream_3.txt"} {
+		testEventLoop(t, file, false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+			path := filepath.Join("tests", file)
+			want, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			var got []byte
+			for chunk, err := range StreamFile(ctx, path, 100, 3) {
+				if err != nil {
+					return err
+				}
+				if len(chunk) > 100 {
+					t.Errorf("expected chunks of at most 100 bytes, got: %d", len(chunk))
+				}
+				got = append(got, chunk...)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("streamed data did not match")
+			}
+			return nil
+		})
+	}
+
+	for _, chunkSize := range []int{0, -1} {
+		testEventLoop(t, fmt.Sprintf("chunk size %d", chunkSize), false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+			path := filepath.Join("tests", "stream_1.txt")
+			want, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			var got []byte
+			for chunk, err := range StreamFile(ctx, path, chunkSize, 3) {
+				if err != nil {
+					return err
+				}
+				got = append(got, chunk...)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("streamed data did not match")
+			}
+			return nil
+		})
+	}
+
+	testEventLoop(t, "missing file", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		for _, err := range StreamFile(ctx, filepath.Join("tests", "missing.txt"), 100, 3) {
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected %v, got: %v", os.ErrNotExist, err)
+			}
+		}
+		return nil
+	})
+}
+
+func TestReentrantMutex(t *testing.T) {
+	testEventLoop(t, "reentrant mutex", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var mutex ReentrantMutex
+
+		var recurse func(ctx context.Context, depth int) error
+		recurse = func(ctx context.Context, depth int) error {
+			if err := mutex.Lock(ctx); err != nil {
+				return err
+			}
+			defer mutex.Unlock()
+			if depth == 0 {
+				return Checkpoint(ctx)
+			}
+			return recurse(ctx, depth-1)
+		}
+
+		var otherLocked bool
+		task := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, recurse(ctx, 3)
+		})
+		other := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			if err := mutex.Lock(ctx); err != nil {
+				return nil, err
+			}
+			defer mutex.Unlock()
+			otherLocked = true
+			if holds := mutex.Holds(); holds != 1 {
+				t.Errorf("expected a single hold, got: %d", holds)
+			}
+			return nil, nil
+		})
+
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+		if holds := mutex.Holds(); holds != 4 {
+			t.Errorf("expected 4 holds while recursing, got: %d", holds)
+		}
+		if otherLocked {
+			t.Errorf("expected other task to wait for the lock")
+		}
+
+		if err := Wait(ctx, WaitAll, task, other); err != nil {
+			return err
+		}
+		if !otherLocked {
+			t.Errorf("expected other task to acquire the lock")
+		}
+		if holds := mutex.Holds(); holds != 0 {
+			t.Errorf("expected mutex to be unlocked, got %d holds", holds)
+		}
+		return nil
+	})
+}
+
+func TestOnce(t *testing.T) {
+	testEventLoop(t, "once", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var once Once
+		var calls int
+		errInit := errors.New("init failed")
+
+		var futs []Futurer
+		for range 3 {
+			futs = append(futs, Coroutine1(func(ctx context.Context) error {
+				return once.Do(ctx, func(ctx context.Context) error {
+					calls++
+					if err := Sleep(ctx, time.Millisecond); err != nil {
+						return err
+					}
+					return errInit
+				})
+			}).SpawnTask(ctx))
+		}
+		_ = Wait(ctx, WaitAll, futs...)
+		for i, fut := range futs {
+			if !errors.Is(fut.Err(), errInit) {
+				t.Errorf("expected caller %d to get %v, got: %v", i, errInit, fut.Err())
+			}
+		}
+		if err := once.Do(ctx, func(ctx context.Context) error { calls++; return nil }); !errors.Is(err, errInit) {
+			t.Errorf("expected later call to get %v, got: %v", errInit, err)
+		}
+		if calls != 1 {
+			t.Errorf("expected coroutine to run once, ran %d times", calls)
+		}
+
+		// a panic is passed on to later callers rather than leaving them waiting forever
+		var panicking Once
+		first := Coroutine1(func(ctx context.Context) error {
+			return panicking.Do(ctx, func(ctx context.Context) error {
+				if err := Sleep(ctx, time.Millisecond); err != nil {
+					return err
+				}
+				panic("init panicked")
+			})
+		}).SpawnTask(ctx)
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+		var perr *PanicError
+		if err := panicking.Do(ctx, func(ctx context.Context) error { return nil }); !errors.As(err, &perr) || perr.Value != "init panicked" {
+			t.Errorf("expected waiting caller to get the panic, got: %v", err)
+		}
+		if _, err := first.Await(ctx); !errors.As(err, &perr) {
+			t.Errorf("expected first caller to panic, got: %v", err)
+		}
+		return nil
+	})
+}
+
+func TestLazy(t *testing.T) {
+	testEventLoop(t, "lazy", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var calls int
+		lazy := NewLazy(func(ctx context.Context) (int, error) {
+			calls++
+			return 42, Sleep(ctx, time.Millisecond)
+		})
+
+		var tasks []Awaitable[int]
+		for range 3 {
+			tasks = append(tasks, SpawnTask(ctx, lazy.Get))
+		}
+		for i, res := range WaitAllTyped(ctx, tasks...) {
+			if res.Value != 42 || res.Err != nil {
+				t.Errorf("expected caller %d to get (42, <nil>), got: (%v, %v)", i, res.Value, res.Err)
+			}
+		}
+		if value, err := lazy.Get(ctx); value != 42 || err != nil {
+			t.Errorf("expected (42, <nil>), got: (%v, %v)", value, err)
+		}
+		if calls != 1 {
+			t.Errorf("expected value to be computed once, computed %d times", calls)
+		}
+
+		// if the first caller is cancelled, a waiting caller computes the value instead
+		calls = 0
+		lazy = NewLazy(func(ctx context.Context) (int, error) {
+			calls++
+			return calls, Sleep(ctx, time.Millisecond*5)
+		})
+		first := SpawnTask(ctx, lazy.Get)
+		second := SpawnTask(ctx, lazy.Get)
+		if err := Sleep(ctx, time.Millisecond); err != nil {
+			return err
+		}
+		first.Cancel(nil)
+		var cerr *CancelledError
+		if _, err := first.Await(ctx); !errors.As(err, &cerr) {
+			t.Errorf("expected first caller to be cancelled, got: %v", err)
+		}
+		if value, err := second.Await(ctx); value != 2 || err != nil {
+			t.Errorf("expected (2, <nil>), got: (%v, %v)", value, err)
+		}
+		if value, err := lazy.Get(ctx); value != 2 || err != nil {
+			t.Errorf("expected cached (2, <nil>), got: (%v, %v)", value, err)
+		}
+		return nil
+	})
+}
+
+func TestWaitGroup(t *testing.T) {
+	testEventLoop(t, "wait group", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var wg WaitGroup
+		if err := wg.Wait(ctx); err != nil {
+			t.Errorf("expected wait on empty group to succeed immediately, got: %v", err)
+		}
+
+		var finished atomic.Int32
+		var goros []Futurer
+		for i := range 5 {
+			wg.Add(1)
+			if i%2 == 0 {
+				SpawnTask(ctx, func(ctx context.Context) (any, error) {
+					defer wg.Done()
+					finished.Add(1)
+					return nil, Sleep(ctx, time.Millisecond*time.Duration(i))
+				})
+			} else {
+				goros = append(goros, Go(ctx, func(ctx context.Context) (any, error) {
+					defer wg.Done()
+					time.Sleep(time.Millisecond * time.Duration(i))
+					finished.Add(1)
+					return nil, nil
+				}))
+			}
+		}
+
+		waiters := make([]Futurer, 3)
+		for i := range waiters {
+			waiters[i] = Coroutine1(wg.Wait).SpawnTask(ctx)
+		}
+		if err := Wait(ctx, WaitAll, waiters...); err != nil {
+			return err
+		}
+		if got := finished.Load(); got != 5 {
+			t.Errorf("expected all 5 operations to finish before waiters were woken, got: %d", got)
+		}
+		return Wait(ctx, WaitAll, goros...)
+	})
+}
+
+func TestRecordStream(t *testing.T) {
+	testEventLoop(t, "record and replay", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		r, w, err := loop.Pipe()
+		if err != nil {
+			return err
+		}
+
+		var readCapture, writeCapture bytes.Buffer
+		RecordStream(r, &readCapture)
+		RecordStream(w, &writeCapture)
+
+		for _, chunk := range []string{"hello\n", "world\n"} {
+			if _, err := w.Write(ctx, []byte(chunk)).Await(ctx); err != nil {
+				return err
+			}
+			if _, err := r.ReadLine(ctx); err != nil {
+				return err
+			}
+		}
+		_ = w.Close()
+		_ = r.Close()
+
+		var directions []Direction
+		var written []byte
+		for record := range ReadCapture(bytes.NewReader(writeCapture.Bytes())).UntilErr(&err) {
+			directions = append(directions, record.Direction)
+			written = append(written, record.Data...)
+		}
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(directions, func(d Direction) bool { return d != DirectionWrite }) {
+			t.Errorf("expected only writes to be recorded, got: %q", directions)
+		}
+		if string(written) != "hello\nworld\n" {
+			t.Errorf("expected recorded writes to be %q, got: %q", "hello\nworld\n", written)
+		}
+
+		replay, err := ReplayStream(&readCapture)
+		if err != nil {
+			return err
+		}
+		var lines []string
+		for line := range replay.Lines(ctx).UntilErr(&err) {
+			lines = append(lines, string(line))
+		}
+		if err != nil {
+			return err
+		}
+		if want := []string{"hello\n", "world\n"}; !slices.Equal(lines, want) {
+			t.Errorf("expected replayed lines %q, got: %q", want, lines)
+		}
+		return nil
+	})
+}
+
+func TestChaosStream(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("tests", "stream_1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer := func(ctx context.Context, loop *EventLoop, policy ChaosPolicy) ([]byte, error) {
+		r, w, err := loop.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		ChaosStream(r, policy)
+
+		writer := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			defer w.Close()
+			_, err := w.Write(ctx, data).Await(ctx)
+			return nil, err
+		})
+		received, err := r.ReadAll(ctx)
+		_, _ = writer.Await(ctx)
+		return received, err
+	}
+
+	testEventLoop(t, "short reads and EAGAIN", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		received, err := transfer(ctx, loop, ChaosPolicy{
+			Seed:       1,
+			Latency:    time.Millisecond,
+			ShortRead:  0.5,
+			WouldBlock: 0.3,
+		})
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(received, data) {
+			t.Errorf("expected data to survive short reads and EAGAIN")
+		}
+		return nil
+	})
+
+	testEventLoop(t, "corruption", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		received, err := transfer(ctx, loop, ChaosPolicy{Seed: 1, Corrupt: 1})
+		if err != nil {
+			return err
+		}
+		if len(received) != len(data) || bytes.Equal(received, data) {
+			t.Errorf("expected data to be corrupted")
+		}
+		return nil
+	})
+
+	testEventLoop(t, "reset", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		if _, err := transfer(ctx, loop, ChaosPolicy{Seed: 1, Reset: 1}); !errors.Is(err, syscall.ECONNRESET) {
+			t.Errorf("expected %v, got: %v", syscall.ECONNRESET, err)
+		}
+		return nil
+	})
+}
+
+func TestWaitForFileStable(t *testing.T) {
+	testEventLoop(t, "file stable", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		path := filepath.Join(t.TempDir(), "upload")
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		var lastWrite time.Time
+		SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			for range 5 {
+				if err := Sleep(ctx, time.Millisecond*20); err != nil {
+					return nil, err
+				}
+				if _, err := f.WriteString("chunk\n"); err != nil {
+					return nil, err
+				}
+				lastWrite = time.Now()
+			}
+			return nil, nil
+		})
+
+		quietPeriod := time.Millisecond * 100
+		if err := WaitForFileStable(ctx, path, quietPeriod); err != nil {
+			return err
+		}
+		if lastWrite.IsZero() || time.Since(lastWrite) < quietPeriod {
+			t.Errorf("expected file to be stable for %v after the last write, was stable for %v", quietPeriod, time.Since(lastWrite))
+		}
+
+		if err := WaitForFileStable(ctx, filepath.Join(t.TempDir(), "missing"), quietPeriod); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %v, got: %v", os.ErrNotExist, err)
+		}
+
+		// a file deleted while waiting isn't reported as stable
+		deleted := filepath.Join(t.TempDir(), "deleted")
+		if err := os.WriteFile(deleted, []byte("partial"), 0o644); err != nil {
+			return err
+		}
+		SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			if err := Sleep(ctx, time.Millisecond*20); err != nil {
+				return nil, err
+			}
+			return nil, os.Remove(deleted)
+		})
+		if err := WaitForFileStable(ctx, deleted, time.Second); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %v, got: %v", os.ErrNotExist, err)
+		}
+		return nil
+	})
+}
+
+func TestGather(t *testing.T) {
+	errOops := errors.New("oops")
+	coro := func(i int, delay time.Duration, err error) Coroutine2[int] {
+		return func(ctx context.Context) (int, error) {
+			if err := Sleep(ctx, delay); err != nil {
+				return 0, err
+			}
+			return i, err
+		}
+	}
+
+	testEventLoop(t, "gather", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		results, err := Gather(ctx,
+			coro(1, time.Millisecond*30, nil),
+			coro(2, time.Millisecond*10, nil),
+			coro(3, time.Millisecond*20, nil),
+		)
+		if err != nil {
+			return err
+		}
+		if want := []int{1, 2, 3}; !slices.Equal(results, want) {
+			t.Errorf("expected %v, got: %v", want, results)
+		}
+		return nil
+	})
+
+	testEventLoop(t, "gather failure", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var cancelled bool
+		results, err := Gather(ctx,
+			coro(1, time.Millisecond, errOops),
+			func(ctx context.Context) (int, error) {
+				err := Sleep(ctx, time.Hour)
+				cancelled = errors.Is(err, context.Canceled)
+				return 0, err
+			},
+		)
+		if !errors.Is(err, errOops) || results != nil {
+			t.Errorf("expected (nil, %v), got: (%v, %v)", errOops, results, err)
+		}
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+		if !cancelled {
+			t.Errorf("expected remaining coroutines to be cancelled")
+		}
+		return nil
+	})
+
+	testEventLoop(t, "gather results", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		results := GatherResults(ctx,
+			coro(1, time.Millisecond*20, nil),
+			coro(2, time.Millisecond*10, errOops),
+			coro(3, time.Millisecond*30, nil),
+		)
+		want := []Result[int]{{Value: 1}, {Value: 2, Err: errOops}, {Value: 3}}
+		if !slices.Equal(results, want) {
+			t.Errorf("expected %v, got: %v", want, results)
+		}
+		return nil
+	})
+}
+
+func TestSpawnLimited(t *testing.T) {
+	testEventLoop(t, "spawn limited", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var running, maxRunning int
+		coros := make([]Coroutine2[int], 10)
+		for i := range coros {
+			coros[i] = func(ctx context.Context) (int, error) {
+				running++
+				maxRunning = max(maxRunning, running)
+				defer func() {
+					running--
+				}()
+				// finish out of order
+				return i * 2, Sleep(ctx, time.Millisecond*time.Duration(10-i))
+			}
+		}
+
+		results, err := SpawnLimited(ctx, 3, coros...)
+		if err != nil {
+			return err
+		}
+		if want := []int{0, 2, 4, 6, 8, 10, 12, 14, 16, 18}; !slices.Equal(results, want) {
+			t.Errorf("expected %v, got: %v", want, results)
+		}
+		if maxRunning != 3 {
+			t.Errorf("expected at most 3 coroutines to run at a time, got: %d", maxRunning)
+		}
+
+		errFailed := errors.New("failed")
+		if _, err := SpawnLimited(ctx, 2, func(ctx context.Context) (int, error) {
+			return 0, errFailed
+		}); err != errFailed {
+			t.Errorf("expected %v, got: %v", errFailed, err)
+		}
+		return nil
+	})
+}
+
+func TestAsCompleted(t *testing.T) {
+	testEventLoop(t, "as completed", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		errOops := errors.New("oops")
+		done := NewFuture[int]()
+		done.SetResult(0, nil)
+
+		var awaitables []Awaitable[int]
+		awaitables = append(awaitables, done)
+		for _, i := range []int{3, 1, 2} {
+			awaitables = append(awaitables, SpawnTask(ctx, func(ctx context.Context) (int, error) {
+				if err := Sleep(ctx, time.Millisecond*time.Duration(10*i)); err != nil {
+					return 0, err
+				}
+				if i == 2 {
+					return i, errOops
+				}
+				return i, nil
+			}))
+		}
+
+		var got []Result[int]
+		var err error
+		for res := range AsCompleted(ctx, awaitables...).UntilErr(&err) {
+			got = append(got, res)
+		}
+		if err != nil {
+			return err
+		}
+		want := []Result[int]{{Value: 0}, {Value: 1}, {Value: 2, Err: errOops}, {Value: 3}}
+		if !slices.Equal(got, want) {
+			t.Errorf("expected %v, got: %v", want, got)
+		}
+		return nil
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	source := func(n int) AsyncIterable[int] {
+		return AsyncIter(func(yield func(int) error) error {
+			for i := 1; i <= n; i++ {
+				if err := yield(i); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	testEventLoop(t, "pipeline", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var running, maxRunning int
+		doubled := NewPipeline(source(20), 4).Map("double", StageOptions{Workers: 4, Buffer: 2}, func(ctx context.Context, i int) (int, error) {
+			running++
+			maxRunning = max(maxRunning, running)
+			defer func() {
+				running--
+			}()
+			return i * 2, Sleep(ctx, time.Millisecond*time.Duration(i%3))
+		})
+		pipeline := MapStage(doubled.Filter("multiples of 3", StageOptions{}, func(ctx context.Context, i int) (bool, error) {
+			return i%3 == 0, nil
+		}), "format", StageOptions{}, func(ctx context.Context, i int) (string, error) {
+			return strconv.Itoa(i), nil
+		})
+
+		var got []string
+		var err error
+		for item := range pipeline.Run(ctx).UntilErr(&err) {
+			got = append(got, item)
+		}
+		if err != nil {
+			return err
+		}
+
+		// multiple workers may emit items out of order
+		slices.Sort(got)
+		if want := []string{"12", "18", "24", "30", "36", "6"}; !slices.Equal(got, want) {
+			t.Errorf("expected %v, got: %v", want, got)
+		}
+		if maxRunning < 2 || maxRunning > 4 {
+			t.Errorf("expected between 2 and 4 concurrent workers, got: %d", maxRunning)
+		}
+
+		want := []StageMetrics{
+			{Name: "source", Out: 20},
+			{Name: "double", In: 20, Out: 20},
+			{Name: "multiples of 3", In: 20, Out: 6, Filtered: 14},
+			{Name: "format", In: 6, Out: 6},
+		}
+		if got := pipeline.Metrics(); !slices.Equal(got, want) {
+			t.Errorf("expected metrics %+v, got: %+v", want, got)
+		}
+		return nil
+	})
+
+	testEventLoop(t, "pipeline failure", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		errOops := errors.New("oops")
+		pipeline := NewPipeline(source(100), 1).Map("fail", StageOptions{Workers: 2}, func(ctx context.Context, i int) (int, error) {
+			if i == 5 {
+				return 0, errOops
+			}
+			return i, nil
+		})
+
+		var count int
+		var err error
+		for range pipeline.Run(ctx).UntilErr(&err) {
+			count++
+		}
+		if !errors.Is(err, errOops) {
+			t.Errorf("expected %v, got: %v", errOops, err)
+		}
+		if metrics := pipeline.Metrics(); metrics[1].Errors != 1 || metrics[0].Out == 100 {
+			t.Errorf("expected the pipeline to stop after the failure, got metrics: %+v", metrics)
+		}
+		return nil
+	})
+}
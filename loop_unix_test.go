@@ -0,0 +1,130 @@
+//go:build unix
+
+package asyncigo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignals(t *testing.T) {
+	testEventLoop(t, "signals", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+		defer cancel()
+
+		SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			for range 3 {
+				if err := Sleep(ctx, time.Millisecond*50); err != nil {
+					return nil, err
+				}
+				if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		})
+
+		var received int
+		for sig, err := range Signals(ctx, syscall.SIGUSR1) {
+			if err != nil {
+				return err
+			}
+			if sig != syscall.SIGUSR1 {
+				t.Errorf("expected SIGUSR1, got: %v", sig)
+			}
+			if received++; received == 3 {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func TestWaitChild(t *testing.T) {
+	testEventLoop(t, "wait child", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		var futs []Futurer
+		statuses := make([]syscall.WaitStatus, 3)
+		for i, code := range []string{"0", "3", "7"} {
+			proc, err := os.StartProcess("/bin/sh", []string{"sh", "-c", "sleep 0.2; exit " + code}, &os.ProcAttr{})
+			if err != nil {
+				return err
+			}
+			futs = append(futs, SpawnTask(ctx, func(ctx context.Context) (syscall.WaitStatus, error) {
+				return WaitChild(ctx, proc.Pid)
+			}).WriteResultTo(&statuses[i]))
+		}
+
+		if err := Wait(ctx, WaitAll, futs...); err != nil {
+			return err
+		}
+
+		for i, want := range []int{0, 3, 7} {
+			if got := statuses[i].ExitStatus(); got != want {
+				t.Errorf("expected exit status %d for child %d, got: %d", want, i, got)
+			}
+		}
+		return nil
+	})
+}
+
+func TestWaitPid(t *testing.T) {
+	testEventLoop(t, "wait pid", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		proc, err := os.StartProcess("/bin/sh", []string{"sh", "-c", "sleep 0.2"}, &os.ProcAttr{})
+		if err != nil {
+			return err
+		}
+
+		if _, err := WatchProcess(ctx, proc.Pid).Await(ctx); err != nil {
+			return err
+		}
+		// the process has exited but not yet been reaped, so waiting again should return immediately
+		if err := WaitPid(ctx, proc.Pid); err != nil {
+			return err
+		}
+		_, err = proc.Wait()
+		return err
+	})
+}
+
+func TestLockFile(t *testing.T) {
+	testEventLoop(t, "lock file", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		path := filepath.Join(t.TempDir(), "lock")
+
+		// a second open file description behaves like another process as far as flock is concerned
+		first, err := LockFile(ctx, path, true)
+		if err != nil {
+			return err
+		}
+
+		var acquiredAt time.Time
+		start := time.Now()
+		second := SpawnTask(ctx, func(ctx context.Context) (*FileLock, error) {
+			lock, err := LockFile(ctx, path, false)
+			acquiredAt = time.Now()
+			return lock, err
+		})
+
+		if err := Sleep(ctx, time.Millisecond*200); err != nil {
+			return err
+		}
+		if second.HasResult() {
+			t.Errorf("shared lock acquired while exclusive lock was held")
+		}
+		if err := first.Unlock(); err != nil {
+			return err
+		}
+
+		lock, err := second.Await(ctx)
+		if err != nil {
+			return err
+		}
+		if waited := acquiredAt.Sub(start); waited < time.Millisecond*200 {
+			t.Errorf("expected to wait at least 200ms for the lock, waited: %s", waited)
+		}
+		return lock.Unlock()
+	})
+}
@@ -0,0 +1,110 @@
+package asyncigo
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/maphash"
+	"math"
+	"reflect"
+)
+
+// PartitionBy splits the given iterable into n AsyncIterables, sending each item to the partition
+// picked by hashing the key returned by keyFn for it. Items with the same key always go to the same partition,
+// in their original order, so iterating over the partitions from n separate tasks
+// processes the items for each key in order while items with different keys are processed in parallel.
+//
+// The source is consumed by a background task started once the first partition is iterated over.
+// Each partition buffers at most one item, so a partition that falls behind holds up the others.
+// If a partition stops iterating early, the remaining items for its keys are discarded,
+// and once every partition has stopped, so does the consumption of the source.
+// If the source fails, each partition ends with the error after yielding the items it was already sent.
+func PartitionBy[T any, K cmp.Ordered](ctx context.Context, it AsyncIterable[T], keyFn func(T) K, n int) []AsyncIterable[T] {
+	n = max(1, n)
+	queues := make([]*Queue[T], n)
+	for i := range queues {
+		queues[i] = NewBoundedQueue[T](1)
+	}
+
+	seed := maphash.MakeSeed()
+	var pump *Task[any]
+	var sourceErr error
+	start := func() {
+		if pump != nil {
+			return
+		}
+		pump = SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			for item, err := range it {
+				if err != nil {
+					return nil, err
+				}
+				queue := queues[hashKey(seed, keyFn(item))%uint64(n)]
+				if _, err := queue.Put(item).Await(ctx); err != nil && !errors.Is(err, ErrQueueClosed) {
+					return nil, err
+				}
+			}
+			return nil, nil
+		})
+		// close the queues even if the task is cancelled before it starts
+		pump.AddDoneCallback(func(err error) {
+			sourceErr = err
+			for _, queue := range queues {
+				queue.Close()
+			}
+		})
+	}
+
+	active := n
+	partitions := make([]AsyncIterable[T], n)
+	for i, queue := range queues {
+		var stopped bool
+		partitions[i] = AsyncIter(func(yield func(T) error) error {
+			start()
+			defer func() {
+				if stopped {
+					return
+				}
+				stopped = true
+				// drop any further items for this partition, in case the iteration ended early
+				queue.Close()
+				if active--; active == 0 {
+					pump.Cancel(nil)
+				}
+			}()
+
+			if err := queue.Iter(ctx).YieldTo(yield); err != nil {
+				return err
+			}
+			return sourceErr
+		})
+	}
+	return partitions
+}
+
+// hashKey hashes a key for [PartitionBy] such that equal keys have equal hashes.
+func hashKey[K cmp.Ordered](seed maphash.Seed, key K) uint64 {
+	var h maphash.Hash
+	h.SetSeed(seed)
+
+	var buf [8]byte
+	// use reflection to also handle types derived from the basic types
+	switch v := reflect.ValueOf(key); v.Kind() {
+	case reflect.String:
+		_, _ = h.WriteString(v.String())
+		return h.Sum64()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		binary.LittleEndian.PutUint64(buf[:], uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		binary.LittleEndian.PutUint64(buf[:], v.Uint())
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f == 0 {
+			// -0 and +0 are equal, but have different bit patterns
+			f = 0
+		}
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+	}
+	_, _ = h.Write(buf[:])
+	return h.Sum64()
+}
@@ -0,0 +1,10 @@
+package asyncigo
+
+// PeerCredentials holds the credentials of the process at the other end of a unix socket connection,
+// as recorded by the kernel when the connection was established.
+type PeerCredentials struct {
+	// PID is the process ID of the peer, or 0 if not available on this platform.
+	PID int
+	UID int
+	GID int
+}
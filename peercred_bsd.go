@@ -0,0 +1,25 @@
+//go:build darwin || freebsd
+
+package asyncigo
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// PeerCred returns the credentials of the peer process using LOCAL_PEERCRED.
+// Only supported for unix domain sockets.
+// The PID of the peer is not available.
+func (a *AsyncStream) PeerCred() (*PeerCredentials, error) {
+	return sockOpt(a, func(fd int) (*PeerCredentials, error) {
+		cred, err := unix.GetsockoptXucred(fd, unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+		if err != nil {
+			return nil, err
+		}
+
+		creds := &PeerCredentials{UID: int(cred.Uid)}
+		if cred.Ngroups > 0 {
+			creds.GID = int(cred.Groups[0])
+		}
+		return creds, nil
+	})
+}
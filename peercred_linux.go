@@ -0,0 +1,19 @@
+//go:build linux
+
+package asyncigo
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// PeerCred returns the credentials of the peer process using SO_PEERCRED.
+// Only supported for unix domain sockets.
+func (a *AsyncStream) PeerCred() (*PeerCredentials, error) {
+	return sockOpt(a, func(fd int) (*PeerCredentials, error) {
+		cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+		if err != nil {
+			return nil, err
+		}
+		return &PeerCredentials{PID: int(cred.Pid), UID: int(cred.Uid), GID: int(cred.Gid)}, nil
+	})
+}
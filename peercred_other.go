@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package asyncigo
+
+// PeerCred is not supported on this platform and will always return [ErrNotImplemented].
+func (a *AsyncStream) PeerCred() (*PeerCredentials, error) {
+	return nil, ErrNotImplemented
+}
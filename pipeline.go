@@ -0,0 +1,197 @@
+package asyncigo
+
+import (
+	"context"
+	"slices"
+)
+
+// StageOptions configures a stage of a [Pipeline].
+type StageOptions struct {
+	// Workers is the number of items processed concurrently by the stage. Defaults to 1.
+	// Stages with more than one worker may emit items out of order.
+	Workers int
+	// Buffer is the number of processed items the stage can hold before its workers
+	// wait for the next stage to catch up. Defaults to 1.
+	Buffer int
+}
+
+// StageMetrics counts the items processed by a stage of a [Pipeline].
+type StageMetrics struct {
+	Name string
+	// In is the number of items received by the stage.
+	In int
+	// Out is the number of items emitted by the stage.
+	Out int
+	// Filtered is the number of items dropped by a filter stage.
+	Filtered int
+	// Errors is the number of items the stage failed to process.
+	Errors int
+}
+
+// Pipeline is a chain of processing stages fed by an [AsyncIterable] source.
+// Each stage runs as one or more tasks connected to the next stage by a bounded queue,
+// so that slow stages apply backpressure to the stages before them.
+//
+// Pipelines are built using [NewPipeline], [Pipeline.Map], [Pipeline.Filter] and [MapStage],
+// and started by ranging over [Pipeline.Run]. A Pipeline should only be run once.
+type Pipeline[T any] struct {
+	metrics []*StageMetrics
+	start   func(ctx context.Context, run *pipelineRun) *Queue[T]
+}
+
+// pipelineRun tracks the tasks of a running [Pipeline].
+type pipelineRun struct {
+	tasks   []*Task[any]
+	err     error
+	stopped bool
+}
+
+// spawn starts a task for a pipeline stage, stopping the pipeline if the task fails.
+// The done callback is run once the task finishes, even if it was cancelled before it started.
+func (r *pipelineRun) spawn(ctx context.Context, coro Coroutine1, done func()) {
+	task := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+		err := coro(ctx)
+		if err != nil && !r.stopped {
+			r.err = err
+			r.stop()
+		}
+		return nil, err
+	})
+	task.AddDoneCallback(func(error) {
+		done()
+	})
+	r.tasks = append(r.tasks, task)
+}
+
+// stop cancels all tasks of the pipeline.
+func (r *pipelineRun) stop() {
+	r.stopped = true
+	for _, t := range r.tasks {
+		t.Cancel(nil)
+	}
+}
+
+// NewPipeline constructs a new [Pipeline] fed by the given source,
+// buffering up to buffer items read from the source ahead of the first stage.
+func NewPipeline[T any](source AsyncIterable[T], buffer int) *Pipeline[T] {
+	metrics := &StageMetrics{Name: "source"}
+	return &Pipeline[T]{
+		metrics: []*StageMetrics{metrics},
+		start: func(ctx context.Context, run *pipelineRun) *Queue[T] {
+			out := NewBoundedQueue[T](buffer)
+			run.spawn(ctx, func(ctx context.Context) error {
+				for item, err := range source {
+					if err != nil {
+						return err
+					}
+					metrics.Out++
+					if _, err := out.Put(item).Await(ctx); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, out.Close)
+			return out
+		},
+	}
+}
+
+// Map adds a stage to the pipeline that transforms each item using fn.
+// If fn fails, the whole pipeline is stopped with the error. Use [MapStage] to change the item type.
+func (p *Pipeline[T]) Map(name string, opts StageOptions, fn func(ctx context.Context, item T) (T, error)) *Pipeline[T] {
+	return MapStage(p, name, opts, fn)
+}
+
+// Filter adds a stage to the pipeline that drops each item for which fn returns false.
+// If fn fails, the whole pipeline is stopped with the error.
+func (p *Pipeline[T]) Filter(name string, opts StageOptions, fn func(ctx context.Context, item T) (bool, error)) *Pipeline[T] {
+	return addStage(p, name, opts, func(ctx context.Context, item T) (T, bool, error) {
+		keep, err := fn(ctx, item)
+		return item, keep, err
+	})
+}
+
+// MapStage adds a stage to the pipeline that transforms each item into an item of another type using fn.
+// If fn fails, the whole pipeline is stopped with the error.
+func MapStage[In, Out any](p *Pipeline[In], name string, opts StageOptions, fn func(ctx context.Context, item In) (Out, error)) *Pipeline[Out] {
+	return addStage(p, name, opts, func(ctx context.Context, item In) (Out, bool, error) {
+		out, err := fn(ctx, item)
+		return out, true, err
+	})
+}
+
+// addStage adds a stage that processes each item using fn, emitting the result if keep is true.
+func addStage[In, Out any](p *Pipeline[In], name string, opts StageOptions, fn func(ctx context.Context, item In) (out Out, keep bool, err error)) *Pipeline[Out] {
+	metrics := &StageMetrics{Name: name}
+	return &Pipeline[Out]{
+		metrics: append(slices.Clip(p.metrics), metrics),
+		start: func(ctx context.Context, run *pipelineRun) *Queue[Out] {
+			in := p.start(ctx, run)
+			out := NewBoundedQueue[Out](opts.Buffer)
+
+			workers := max(1, opts.Workers)
+			for range workers {
+				run.spawn(ctx, func(ctx context.Context) error {
+					for item, err := range in.Iter(ctx) {
+						if err != nil {
+							return err
+						}
+
+						metrics.In++
+						result, keep, err := fn(ctx, item)
+						if err != nil {
+							metrics.Errors++
+							return err
+						} else if !keep {
+							metrics.Filtered++
+							continue
+						}
+
+						metrics.Out++
+						if _, err := out.Put(result).Await(ctx); err != nil {
+							return err
+						}
+					}
+					return nil
+				}, func() {
+					// the last worker to finish closes the stage
+					if workers--; workers == 0 {
+						out.Close()
+					}
+				})
+			}
+			return out
+		},
+	}
+}
+
+// Run starts the pipeline, spawning the tasks for each stage,
+// and returns an [AsyncIterable] over the items emitted by the last stage.
+// If any stage fails, the pipeline is stopped and the iteration ends with the error.
+// Ending the iteration early also stops the pipeline.
+func (p *Pipeline[T]) Run(ctx context.Context) AsyncIterable[T] {
+	return AsyncIter(func(yield func(T) error) error {
+		run := &pipelineRun{}
+		out := p.start(ctx, run)
+		defer run.stop()
+
+		for item, err := range out.Iter(ctx) {
+			if err != nil {
+				return err
+			}
+			if err := yield(item); err != nil {
+				return err
+			}
+		}
+		return run.err
+	})
+}
+
+// Metrics returns the metrics of each stage of the pipeline, starting with the source.
+func (p *Pipeline[T]) Metrics() []StageMetrics {
+	metrics := make([]StageMetrics, len(p.metrics))
+	for i, m := range p.metrics {
+		metrics[i] = *m
+	}
+	return metrics
+}
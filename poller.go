@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net"
 	"time"
 )
 
@@ -27,6 +28,8 @@ type Poller interface {
 	Pipe() (r, w AsyncReadWriteCloser, err error)
 	// Dial opens a non-blocking network connection.
 	Dial(ctx context.Context, network, address string) (AsyncReadWriteCloser, error)
+	// Listen opens a non-blocking listening socket.
+	Listen(ctx context.Context, network, address string) (AsyncListener, error)
 }
 
 // AsyncReadWriteCloser represents a non-blocking file handle.
@@ -38,3 +41,24 @@ type AsyncReadWriteCloser interface {
 	// WaitForReady suspends the calling coroutine until an I/O event occurs for this file handle.
 	WaitForReady(ctx context.Context) error
 }
+
+// AsyncListener represents a non-blocking listening socket.
+//
+// If no connection is pending when Accept is called, a [syscall.EAGAIN] error will be returned.
+type AsyncListener interface {
+	io.Closer
+	// Accept accepts a pending connection, returning a non-blocking handle to the connection
+	// along with the address of the remote peer.
+	Accept() (conn AsyncReadWriteCloser, remote net.Addr, err error)
+	// Addr returns the address the listener is listening on.
+	Addr() net.Addr
+	// WaitForReady suspends the calling coroutine until a connection may be pending.
+	WaitForReady(ctx context.Context) error
+}
+
+// Fder represents a file handle that has an associated file descriptor.
+type Fder interface {
+	io.ReadWriteCloser
+	// Fd returns the file descriptor of this handle.
+	Fd() uintptr
+}
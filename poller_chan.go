@@ -1,4 +1,4 @@
-//go:build !linux || channels
+//go:build !(linux || windows) || channels
 
 package asyncigo
 
@@ -85,6 +85,11 @@ func (c *ChannelPoller) Dial(_ context.Context, _, _ string) (AsyncReadWriteClos
 	return nil, ErrNotImplemented
 }
 
+// Listen implements [Poller].
+func (c *ChannelPoller) Listen(_ context.Context, _, _ string) (AsyncListener, error) {
+	return nil, ErrNotImplemented
+}
+
 type channelNotifier interface {
 	// notifyReadyMaybe notifies any waiting coroutines
 	// if the channel is ready to be read from/written to.
@@ -1,4 +1,4 @@
-//go:build linux && !channels
+//go:build linux && !uring && !channels
 
 package asyncigo
 
@@ -15,6 +15,9 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+// epollEvents are the events an [EpollAsyncFile] is subscribed to by default.
+const epollEvents = unix.EPOLLIN | unix.EPOLLOUT | unix.EPOLLPRI | unix.EPOLLET
+
 // EpollPoller is an epoll-backed [Poller] implementation.
 type EpollPoller struct {
 	epfd     int
@@ -93,7 +96,7 @@ func (e *EpollPoller) Subscribe(target *EpollAsyncFile) error {
 		return err
 	}
 
-	event := unix.EpollEvent{Events: unix.EPOLLIN | unix.EPOLLOUT | unix.EPOLLPRI | unix.EPOLLET, Fd: int32(fd)}
+	event := unix.EpollEvent{Events: epollEvents, Fd: int32(fd)}
 	if err := unix.EpollCtl(e.epfd, unix.EPOLL_CTL_ADD, fd, &event); err != nil {
 		return err
 	}
@@ -143,48 +146,137 @@ func (e *EpollPoller) Pipe() (r, w AsyncReadWriteCloser, err error) {
 
 // Dial implements [Poller].
 func (e *EpollPoller) Dial(ctx context.Context, network, address string) (conn AsyncReadWriteCloser, err error) {
-	if network != "tcp" {
-		return nil, errors.New("unsupported connection type")
+	return dialSocket(ctx, network, address, e.connect)
+}
+
+// WatchProcess opens a pidfd for the process with the given pid.
+// The returned handle becomes ready once the process exits,
+// which can also be checked directly using exited.
+func (e *EpollPoller) WatchProcess(pid int) (handle AsyncReadWriteCloser, exited func() bool, err error) {
+	fd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f := NewEpollAsyncFile(e, NewSocket(fd))
+	if err := e.Subscribe(f); err != nil {
+		_ = f.Close()
+		return nil, nil, err
 	}
 
-	// would have been nice to be able to rely on go's own intelligent address resolution,
-	// and then just retrieve the underlying fd and perform non-blocking operations with it,
-	// but there's no way to keep the connecting code from blocking,
-	// so just do a simple, naive getaddrinfo/socket/connect
+	// the process may already have exited by the time we subscribe,
+	// in which case there won't be any edge for epoll to report
+	exited = func() bool {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, 0)
+		return err == nil && n > 0
+	}
+	return f, exited, nil
+}
 
-	host, port, err := net.SplitHostPort(address)
+// WatchFile opens an inotify instance watching the file at the given path for modifications.
+// The returned handle becomes ready whenever the file is modified, closed after writing,
+// or moved or deleted. Reading from the handle consumes the pending events,
+// and fails with an error matching [os.ErrNotExist] once the file has been deleted.
+func (e *EpollPoller) WatchFile(path string) (handle AsyncReadWriteCloser, err error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
 	if err != nil {
 		return nil, err
 	}
-	portNum, err := net.DefaultResolver.LookupPort(ctx, network, port)
-	if err != nil {
+
+	mask := uint32(unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_CLOSE_WRITE | unix.IN_MOVE_SELF | unix.IN_DELETE_SELF)
+	if _, err := unix.InotifyAddWatch(fd, path, mask); err != nil {
+		_ = unix.Close(fd)
 		return nil, err
 	}
 
-	// this is still blocking though :(
-	// consider getaddrinfo_a???
-	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
-	if err != nil {
+	f := NewEpollAsyncFile(e, NewSocket(fd))
+	if err := e.Subscribe(f); err != nil {
+		_ = f.Close()
 		return nil, err
 	}
+	return &inotifyFile{EpollAsyncFile: f, path: path}, nil
+}
+
+// inotifyFile is an [EpollAsyncFile] reading the events of an inotify instance watching a single file.
+type inotifyFile struct {
+	*EpollAsyncFile
+	path string
+}
 
-	// try to connect in parallel and return the first successful connection
-	futs := make([]Coroutine2[*EpollAsyncFile], len(addrs))
-	for i, addr := range addrs {
-		futs[i] = func(ctx context.Context) (*EpollAsyncFile, error) {
-			return e.dialSingle(ctx, addr, portNum)
+// Read implements [io.Reader], failing with an error matching [os.ErrNotExist]
+// if any of the events read reports that the watched file has been deleted.
+func (f *inotifyFile) Read(p []byte) (n int, err error) {
+	n, err = f.EpollAsyncFile.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+	// the kernel only ever returns whole events, each followed by a name of the given length
+	for events := p[:n]; len(events) >= unix.SizeofInotifyEvent; {
+		mask := binary.NativeEndian.Uint32(events[4:8])
+		if mask&unix.IN_DELETE_SELF != 0 {
+			return n, &os.PathError{Op: "watch", Path: f.path, Err: unix.ENOENT}
 		}
+		nameLen := int(binary.NativeEndian.Uint32(events[12:16]))
+		events = events[min(len(events), unix.SizeofInotifyEvent+nameLen):]
+	}
+	return n, err
+}
+
+// EventFD opens an eventfd that becomes ready once a non-zero value has been written to it.
+// Reading from the handle returns and resets the sum of the values written since the last read.
+func (e *EpollPoller) EventFD() (handle AsyncReadWriteCloser, fd uintptr, err error) {
+	efd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return GetFirstResult(ctx, futs...)
+	f := NewEpollAsyncFile(e, NewSocket(efd))
+	if err := e.Subscribe(f); err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	return f, uintptr(efd), nil
 }
 
-func (e *EpollPoller) dialSingle(ctx context.Context, addr net.IPAddr, port int) (*EpollAsyncFile, error) {
-	domain, sockAddr, err := e.toSockAddr(addr, port)
+// AdoptListener takes ownership of the given listening socket, e.g. one inherited from another process,
+// and subscribes to its events.
+// The file descriptor is expected to have close-on-exec set already.
+func (e *EpollPoller) AdoptListener(fd uintptr) (AsyncListener, error) {
+	f, err := e.adoptSocket(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	sockAddr, err := unix.Getsockname(int(fd))
 	if err != nil {
+		_ = f.Close()
 		return nil, err
 	}
+	return &EpollListener{
+		EpollAsyncFile: f,
+		addr:           sockAddrToNetAddr(sockAddr, &net.UnixAddr{Net: "unix"}),
+	}, nil
+}
+
+// AdoptConn takes ownership of the given connected socket, e.g. one inherited from another process,
+// and subscribes to its events.
+// The file descriptor is expected to have close-on-exec set already.
+func (e *EpollPoller) AdoptConn(fd uintptr) (AsyncReadWriteCloser, error) {
+	return e.adoptSocket(fd)
+}
 
+func (e *EpollPoller) adoptSocket(fd uintptr) (*EpollAsyncFile, error) {
+	f := NewEpollAsyncFile(e, NewSocket(int(fd)))
+	if err := e.Subscribe(f); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// connect opens a non-blocking stream socket connected to the given address.
+func (e *EpollPoller) connect(ctx context.Context, domain int, sockAddr unix.Sockaddr) (*EpollAsyncFile, error) {
 	fd, err := unix.Socket(domain, unix.SOCK_STREAM|unix.SOCK_NONBLOCK, 0)
 	if err != nil {
 		return nil, err
@@ -215,22 +307,19 @@ func (e *EpollPoller) dialSingle(ctx context.Context, addr net.IPAddr, port int)
 	}
 }
 
-func (e *EpollPoller) toSockAddr(addr net.IPAddr, port int) (domain int, sockAddr unix.Sockaddr, err error) {
-	if ipv4 := addr.IP.To4(); len(ipv4) == net.IPv4len {
-		return unix.AF_INET, &unix.SockaddrInet4{Port: port, Addr: [net.IPv4len]byte(ipv4)}, nil
-	} else if ipv6 := addr.IP.To16(); len(ipv6) == net.IPv6len {
-		// handling the zone seems really complicated so no thanks
-		return unix.AF_INET6, &unix.SockaddrInet6{Port: port, Addr: [net.IPv6len]byte(ipv6)}, nil
-	} else {
-		return domain, nil, errors.New("could not parse IP address")
+// Listen implements [Poller].
+func (e *EpollPoller) Listen(ctx context.Context, network, address string) (AsyncListener, error) {
+	fd, addr, err := listenSocket(ctx, network, address)
+	if err != nil {
+		return nil, err
 	}
-}
 
-// Fder represents a file handle that has an associated file descriptor.
-type Fder interface {
-	io.ReadWriteCloser
-	// Fd returns the file descriptor of this handle.
-	Fd() uintptr
+	f := NewEpollAsyncFile(e, NewSocket(fd))
+	if err := e.Subscribe(f); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &EpollListener{EpollAsyncFile: f, addr: addr, path: unixSocketPath(addr)}, nil
 }
 
 // EpollAsyncFile is an implementation of [AsyncReadWriteCloser] for [EpollPoller].
@@ -238,6 +327,8 @@ type EpollAsyncFile struct {
 	poller   *EpollPoller
 	f        Fder
 	readyFut *Future[any]
+
+	zeroCopy zeroCopyState
 }
 
 // NewEpollAsyncFile wraps the given file handle using an [EpollAsyncFile].
@@ -288,6 +379,92 @@ func (eaf *EpollAsyncFile) Fd() uintptr {
 	return eaf.f.Fd()
 }
 
+// Writev writes the given buffers using a single writev system call.
+func (eaf *EpollAsyncFile) Writev(bufs [][]byte) (n int, err error) {
+	return unix.Writev(int(eaf.Fd()), bufs)
+}
+
+// SetCork sets the TCP_CORK option on the underlying socket.
+// While corked, the kernel will hold back partial frames until the socket is uncorked.
+func (eaf *EpollAsyncFile) SetCork(cork bool) error {
+	value := 0
+	if cork {
+		value = 1
+	}
+	return unix.SetsockoptInt(int(eaf.Fd()), unix.IPPROTO_TCP, unix.TCP_CORK, value)
+}
+
+// SetReadInterest controls whether the poller reports the file handle as ready when data is available to read.
+// Re-enabling read interest will immediately report the file handle as ready if data is already available.
+func (eaf *EpollAsyncFile) SetReadInterest(enabled bool) error {
+	var events uint32 = epollEvents
+	if !enabled {
+		events &^= unix.EPOLLIN
+	}
+	fd := int(eaf.Fd())
+	event := unix.EpollEvent{Events: events, Fd: int32(fd)}
+	return unix.EpollCtl(eaf.poller.epfd, unix.EPOLL_CTL_MOD, fd, &event)
+}
+
+// EpollListener is an implementation of [AsyncListener] for [EpollPoller].
+type EpollListener struct {
+	*EpollAsyncFile
+	addr net.Addr
+	hook AcceptHook
+	// path is the filesystem path of a unix socket, removed once the listener is closed
+	path string
+}
+
+// Accept implements [AsyncListener].
+func (l *EpollListener) Accept() (conn AsyncReadWriteCloser, remote net.Addr, err error) {
+	for {
+		fd, sockAddr, err := unix.Accept4(int(l.Fd()), unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		remote := sockAddrToNetAddr(sockAddr, l.addr)
+		if l.hook != nil {
+			if err := l.hook(uintptr(fd), remote); err != nil {
+				// rejected; move on to the next pending connection, if any
+				_ = unix.Close(fd)
+				continue
+			}
+		}
+
+		f := NewEpollAsyncFile(l.poller, NewSocket(fd))
+		if err := l.poller.Subscribe(f); err != nil {
+			_ = f.Close()
+			return nil, nil, err
+		}
+		return f, remote, nil
+	}
+}
+
+// SetAcceptHook sets a hook to run for each accepted connection
+// before it is registered with the poller.
+func (l *EpollListener) SetAcceptHook(hook AcceptHook) {
+	l.hook = hook
+}
+
+// Addr implements [AsyncListener].
+func (l *EpollListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *EpollListener) disownSocketPath() {
+	l.path = ""
+}
+
+// Close implements [io.Closer].
+func (l *EpollListener) Close() error {
+	err := l.EpollAsyncFile.Close()
+	unlinkSocketPath(&l.path)
+	// wake up any pending Accept so it can observe that the listener has been closed
+	l.notifyReady()
+	return err
+}
+
 // EpollSocket is a wrapper for a low-level socket file descriptor.
 type EpollSocket struct {
 	fd int
@@ -0,0 +1,119 @@
+//go:build linux && !uring && !channels
+
+package asyncigo
+
+import (
+	"context"
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// soZeroCopy is SO_ZEROCOPY from <asm-generic/socket.h>, which is missing from x/sys/unix.
+const soZeroCopy = 0x3c
+
+// zeroCopyState tracks the MSG_ZEROCOPY sends of an [EpollAsyncFile]
+// that the kernel has not yet reported as completed.
+type zeroCopyState struct {
+	enabled bool
+	// sent is the number of sendmsg calls made with MSG_ZEROCOPY,
+	// which the kernel uses as sequence numbers in its completion notifications
+	sent      uint32
+	completed uint32
+}
+
+// EnableZeroCopy enables MSG_ZEROCOPY sends for this socket.
+// Returns an error if the underlying file handle does not support zero-copy sends.
+func (eaf *EpollAsyncFile) EnableZeroCopy() error {
+	if err := unix.SetsockoptInt(int(eaf.Fd()), unix.SOL_SOCKET, soZeroCopy, 1); err != nil {
+		return err
+	}
+	eaf.zeroCopy.enabled = true
+	return nil
+}
+
+// WriteZeroCopy writes all of p to the socket using MSG_ZEROCOPY,
+// suspending the calling coroutine until the kernel reports that it no longer references p.
+//
+// If zero-copy sends are not enabled, or the kernel reports that it had to copy the data anyway
+// (e.g. because the destination is the loopback device), subsequent writes fall back to regular sends.
+func (eaf *EpollAsyncFile) WriteZeroCopy(ctx context.Context, p []byte) (n int, err error) {
+	fd := int(eaf.Fd())
+	for n < len(p) {
+		if !eaf.zeroCopy.enabled {
+			sent, err := eaf.Write(p[n:])
+			n += max(0, sent)
+			if err != nil && !errors.Is(err, unix.EAGAIN) {
+				return n, err
+			}
+			if err != nil {
+				if err := eaf.WaitForReady(ctx); err != nil {
+					return n, err
+				}
+			}
+			continue
+		}
+
+		sent, err := unix.SendmsgN(fd, p[n:], nil, nil, unix.MSG_ZEROCOPY)
+		if errors.Is(err, unix.ENOBUFS) {
+			// we've exceeded the locked memory limit for pinned pages; fall back to copying
+			eaf.zeroCopy.enabled = false
+			continue
+		} else if errors.Is(err, unix.EAGAIN) {
+			if err := eaf.WaitForReady(ctx); err != nil {
+				return n, err
+			}
+			continue
+		} else if err != nil {
+			return n, err
+		}
+
+		n += sent
+		eaf.zeroCopy.sent++
+	}
+
+	for eaf.zeroCopy.completed != eaf.zeroCopy.sent {
+		err := eaf.readZeroCopyCompletions()
+		if errors.Is(err, unix.EAGAIN) {
+			err = eaf.WaitForReady(ctx)
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readZeroCopyCompletions reads a single notification from the socket's error queue.
+func (eaf *EpollAsyncFile) readZeroCopyCompletions() error {
+	oob := make([]byte, unix.CmsgSpace(int(unsafe.Sizeof(unix.SockExtendedErr{}))))
+	_, oobn, _, _, err := unix.Recvmsg(int(eaf.Fd()), nil, oob, unix.MSG_ERRQUEUE)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		isRecvErr := (msg.Header.Level == unix.SOL_IP && msg.Header.Type == unix.IP_RECVERR) ||
+			(msg.Header.Level == unix.SOL_IPV6 && msg.Header.Type == unix.IPV6_RECVERR)
+		if !isRecvErr || len(msg.Data) < int(unsafe.Sizeof(unix.SockExtendedErr{})) {
+			continue
+		}
+
+		serr := (*unix.SockExtendedErr)(unsafe.Pointer(&msg.Data[0]))
+		if serr.Origin != unix.SO_EE_ORIGIN_ZEROCOPY {
+			continue
+		}
+		// Info and Data hold the inclusive range of completed sequence numbers
+		eaf.zeroCopy.completed += serr.Data - serr.Info + 1
+		if serr.Code&unix.SO_EE_CODE_ZEROCOPY_COPIED != 0 {
+			// the kernel fell back to copying, so zero-copy just adds overhead
+			eaf.zeroCopy.enabled = false
+		}
+	}
+	return nil
+}
@@ -0,0 +1,642 @@
+//go:build linux && uring && !channels
+
+package asyncigo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// io_uring constants from <linux/io_uring.h>, which are missing from x/sys/unix
+const (
+	uringEntries = 256
+
+	uringOffSQRing = 0
+	uringOffSQEs   = 0x10000000
+
+	uringFeatSingleMmap = 1 << 0
+	uringFeatExtArg     = 1 << 8
+
+	uringEnterGetevents = 1 << 0
+	uringEnterExtArg    = 1 << 3
+
+	uringOpAccept      = 13
+	uringOpAsyncCancel = 14
+	uringOpConnect     = 16
+	uringOpRead        = 22
+	uringOpWrite       = 23
+)
+
+// uringParams mirrors struct io_uring_params.
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        struct {
+		head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+		userAddr                                                        uint64
+	}
+	cqOff struct {
+		head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+		userAddr                                                        uint64
+	}
+}
+
+// uringSQE mirrors struct io_uring_sqe.
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+// uringCQE mirrors struct io_uring_cqe.
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uringGeteventsArg mirrors struct io_uring_getevents_arg.
+type uringGeteventsArg struct {
+	sigmask   uint64
+	sigmaskSz uint32
+	_         uint32
+	ts        uint64
+}
+
+// uringOp is a submitted operation awaiting its completion.
+// Any memory referenced by the submission is kept alive by the op until it completes.
+type uringOp struct {
+	complete func(res int32)
+	buf      []byte
+}
+
+// UringPoller is a [Poller] implementation based on io_uring, enabled using the uring build tag.
+// Rather than waiting for file handles to become ready and then retrying the operation,
+// reads, writes, accepts and connects are submitted to the kernel as operations of their own,
+// saving a system call per operation on busy connections. Requires Linux 5.11 or later.
+//
+// File handles opened by UringPoller still implement the non-blocking [AsyncReadWriteCloser] API:
+// a read or write that can't complete right away is submitted to the kernel and fails with [unix.EAGAIN],
+// and the result is returned by the next call once [AsyncReadWriteCloser.WaitForReady] returns.
+// Data written is copied when the write is submitted, so a write abandoned by a cancelled coroutine
+// may still complete in the background.
+type UringPoller struct {
+	fd    int
+	ring  []byte
+	sqes  []byte
+	sqe   []uringSQE
+	cqe   []uringCQE
+	ops   map[uint64]*uringOp
+	opSeq uint64
+
+	sqHead, sqTail *uint32
+	cqHead, cqTail *uint32
+	sqMask, cqMask uint32
+	sqEntries      uint32
+	// tail is the local copy of the submission queue tail
+	tail uint32
+
+	// ts and arg are used when waiting for completions, and live on the heap
+	// so that the kernel can be handed their addresses
+	ts  unix.Timespec
+	arg uringGeteventsArg
+
+	waker    int
+	wakerBuf []byte
+	closed   bool
+}
+
+// NewPoller constructs a new UringPoller.
+// Will fail if an io_uring instance could not be created.
+func NewPoller() (Poller, error) {
+	var params uringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uringEntries, uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	u := &UringPoller{fd: int(fd), ops: make(map[uint64]*uringOp), waker: -1, wakerBuf: make([]byte, 8)}
+
+	if params.features&uringFeatSingleMmap == 0 || params.features&uringFeatExtArg == 0 {
+		_ = u.Close()
+		return nil, errors.New("io_uring is not supported by this kernel")
+	}
+
+	// with IORING_FEAT_SINGLE_MMAP, the submission and completion queues share a single mapping
+	ringSize := max(params.sqOff.array+params.sqEntries*4, params.cqOff.cqes+params.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	var err error
+	if u.ring, err = unix.Mmap(u.fd, uringOffSQRing, int(ringSize), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		_ = u.Close()
+		return nil, err
+	}
+	sqeSize := int(params.sqEntries) * int(unsafe.Sizeof(uringSQE{}))
+	if u.sqes, err = unix.Mmap(u.fd, uringOffSQEs, sqeSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		_ = u.Close()
+		return nil, err
+	}
+
+	u.sqHead = u.ringUint32(params.sqOff.head)
+	u.sqTail = u.ringUint32(params.sqOff.tail)
+	u.sqMask = *u.ringUint32(params.sqOff.ringMask)
+	u.sqEntries = params.sqEntries
+	u.cqHead = u.ringUint32(params.cqOff.head)
+	u.cqTail = u.ringUint32(params.cqOff.tail)
+	u.cqMask = *u.ringUint32(params.cqOff.ringMask)
+	u.sqe = unsafe.Slice((*uringSQE)(unsafe.Pointer(&u.sqes[0])), params.sqEntries)
+	u.cqe = unsafe.Slice((*uringCQE)(unsafe.Pointer(&u.ring[params.cqOff.cqes])), params.cqEntries)
+	u.tail = atomic.LoadUint32(u.sqTail)
+
+	// map every slot of the submission queue to the entry with the same index once and for all
+	array := unsafe.Slice(u.ringUint32(params.sqOff.array), params.sqEntries)
+	for i := range array {
+		array[i] = uint32(i)
+	}
+
+	// eventfd for waking up the poller from another thread
+	if u.waker, err = unix.Eventfd(0, unix.EFD_CLOEXEC); err != nil {
+		_ = u.Close()
+		return nil, err
+	}
+	u.readWaker()
+
+	return u, nil
+}
+
+func (u *UringPoller) ringUint32(offset uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(&u.ring[offset]))
+}
+
+// Close implements [Poller].
+func (u *UringPoller) Close() error {
+	u.closed = true
+	if u.waker >= 0 {
+		_ = unix.Close(u.waker)
+	}
+	if u.sqes != nil {
+		_ = unix.Munmap(u.sqes)
+	}
+	if u.ring != nil {
+		_ = unix.Munmap(u.ring)
+	}
+	return unix.Close(u.fd)
+}
+
+// Wait implements [Poller].
+// Pending operations are submitted to the kernel before waiting.
+func (u *UringPoller) Wait(timeout time.Duration) error {
+	u.ts = unix.NsecToTimespec(max(0, timeout).Nanoseconds())
+	u.arg = uringGeteventsArg{ts: uint64(uintptr(unsafe.Pointer(&u.ts)))}
+	err := u.enter(uringEnterGetevents|uringEnterExtArg, 1, unsafe.Pointer(&u.arg), unsafe.Sizeof(u.arg))
+	if errors.Is(err, unix.ETIME) || errors.Is(err, unix.EINTR) || errors.Is(err, unix.EBUSY) {
+		err = nil
+	}
+	u.reap()
+	return err
+}
+
+// enter submits any pending operations, optionally waiting for completions.
+func (u *UringPoller) enter(flags uintptr, minComplete uintptr, arg unsafe.Pointer, argSize uintptr) error {
+	toSubmit := u.tail - atomic.LoadUint32(u.sqHead)
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(u.fd), uintptr(toSubmit), minComplete, flags, uintptr(arg), argSize)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// reap processes all available completions.
+func (u *UringPoller) reap() {
+	for {
+		head := atomic.LoadUint32(u.cqHead)
+		if head == atomic.LoadUint32(u.cqTail) {
+			return
+		}
+		cqe := u.cqe[head&u.cqMask]
+		atomic.StoreUint32(u.cqHead, head+1)
+
+		if op, ok := u.ops[cqe.userData]; ok {
+			delete(u.ops, cqe.userData)
+			op.complete(cqe.res)
+		}
+	}
+}
+
+// submit queues an operation, to be submitted to the kernel on the next call to Wait.
+// Returns the ID of the operation, which can be used to cancel it.
+func (u *UringPoller) submit(sqe uringSQE, op *uringOp) uint64 {
+	if u.tail-atomic.LoadUint32(u.sqHead) >= u.sqEntries {
+		// the submission queue is full, so hand the pending operations over to the kernel right away
+		_ = u.enter(0, 0, nil, 0)
+		u.reap()
+	}
+
+	u.opSeq++
+	sqe.userData = u.opSeq
+	u.ops[u.opSeq] = op
+	u.sqe[u.tail&u.sqMask] = sqe
+	u.tail++
+	atomic.StoreUint32(u.sqTail, u.tail)
+	return u.opSeq
+}
+
+// cancel asks the kernel to cancel the operation with the given ID.
+// The operation still completes, typically with [unix.ECANCELED].
+func (u *UringPoller) cancel(id uint64) {
+	u.submit(uringSQE{opcode: uringOpAsyncCancel, fd: -1, addr: id}, &uringOp{complete: func(int32) {}})
+}
+
+func (u *UringPoller) readWaker() {
+	u.submit(uringSQE{
+		opcode: uringOpRead,
+		fd:     int32(u.waker),
+		off:    ^uint64(0),
+		addr:   uint64(uintptr(unsafe.Pointer(&u.wakerBuf[0]))),
+		len:    uint32(len(u.wakerBuf)),
+	}, &uringOp{buf: u.wakerBuf, complete: func(int32) {
+		if !u.closed {
+			u.readWaker()
+		}
+	}})
+}
+
+// WakeupThreadsafe implements [Poller].
+func (u *UringPoller) WakeupThreadsafe() error {
+	buf := make([]byte, 8)
+	binary.NativeEndian.PutUint64(buf, 1)
+	_, err := unix.Write(u.waker, buf)
+	return err
+}
+
+// Open wraps the given file descriptor.
+// The file descriptor is switched to blocking mode, as io_uring fails operations on non-blocking files
+// instead of waiting for them to complete.
+func (u *UringPoller) Open(fd uintptr) (file AsyncReadWriteCloser, err error) {
+	return u.open(int(fd))
+}
+
+func (u *UringPoller) open(fd int) (*UringAsyncFile, error) {
+	if err := unix.SetNonblock(fd, false); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	return NewUringAsyncFile(u, fd), nil
+}
+
+// Pipe implements [Poller].
+func (u *UringPoller) Pipe() (r, w AsyncReadWriteCloser, err error) {
+	p := make([]int, 2)
+	if err := unix.Pipe2(p, unix.O_CLOEXEC); err != nil {
+		return nil, nil, err
+	}
+
+	if r, err = u.open(p[0]); err != nil {
+		_ = unix.Close(p[1])
+		return nil, nil, err
+	}
+	if w, err = u.open(p[1]); err != nil {
+		_ = r.Close()
+		return nil, nil, err
+	}
+	return r, w, nil
+}
+
+// Dial implements [Poller].
+func (u *UringPoller) Dial(ctx context.Context, network, address string) (conn AsyncReadWriteCloser, err error) {
+	return dialSocket(ctx, network, address, u.connect)
+}
+
+// connect opens a stream socket connected to the given address.
+func (u *UringPoller) connect(ctx context.Context, domain int, sockAddr unix.Sockaddr) (*UringAsyncFile, error) {
+	raw, err := rawSockAddr(sockAddr)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(domain, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	f := NewUringAsyncFile(u, fd)
+
+	f.write.start(u, uringSQE{
+		opcode: uringOpConnect,
+		fd:     int32(fd),
+		addr:   uint64(uintptr(unsafe.Pointer(&raw[0]))),
+		off:    uint64(len(raw)),
+	}, f, &uringOp{buf: raw})
+	for !f.write.done {
+		if err := f.WaitForReady(ctx); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	if res := f.write.take(); res < 0 {
+		_ = f.Close()
+		return nil, unix.Errno(-res)
+	}
+	return f, nil
+}
+
+// Listen implements [Poller].
+func (u *UringPoller) Listen(ctx context.Context, network, address string) (AsyncListener, error) {
+	fd, addr, err := listenSocket(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := u.open(fd)
+	if err != nil {
+		return nil, err
+	}
+	return &UringListener{UringAsyncFile: f, addr: addr, path: unixSocketPath(addr)}, nil
+}
+
+// rawSockAddr encodes the given address as a struct sockaddr for submitting to the kernel.
+func rawSockAddr(sockAddr unix.Sockaddr) ([]byte, error) {
+	switch sa := sockAddr.(type) {
+	case *unix.SockaddrInet4:
+		raw := unix.RawSockaddrInet4{Family: unix.AF_INET, Addr: sa.Addr}
+		binary.BigEndian.PutUint16((*[2]byte)(unsafe.Pointer(&raw.Port))[:], uint16(sa.Port))
+		return rawBytes(&raw), nil
+	case *unix.SockaddrInet6:
+		raw := unix.RawSockaddrInet6{Family: unix.AF_INET6, Addr: sa.Addr}
+		binary.BigEndian.PutUint16((*[2]byte)(unsafe.Pointer(&raw.Port))[:], uint16(sa.Port))
+		return rawBytes(&raw), nil
+	case *unix.SockaddrUnix:
+		raw := unix.RawSockaddrUnix{Family: unix.AF_UNIX}
+		if len(sa.Name) >= len(raw.Path) {
+			return nil, unix.EINVAL
+		}
+		for i := range len(sa.Name) {
+			raw.Path[i] = int8(sa.Name[i])
+		}
+		// the path is NUL-terminated
+		return rawBytes(&raw)[:unsafe.Offsetof(raw.Path)+uintptr(len(sa.Name))+1], nil
+	default:
+		return nil, errors.New("unsupported address type")
+	}
+}
+
+// rawBytes returns a copy of the memory of the given value.
+func rawBytes[T any](v *T) []byte {
+	return append([]byte(nil), unsafe.Slice((*byte)(unsafe.Pointer(v)), unsafe.Sizeof(*v))...)
+}
+
+// uringOpState tracks an operation submitted on behalf of an [UringAsyncFile].
+type uringOpState struct {
+	// id is the ID of the operation in flight, if any
+	id   uint64
+	done bool
+	res  int32
+}
+
+func (s *uringOpState) pending() bool {
+	return s.id != 0
+}
+
+// start submits the given operation, notifying f once it completes.
+func (s *uringOpState) start(u *UringPoller, sqe uringSQE, f *UringAsyncFile, op *uringOp) {
+	complete := op.complete
+	op.complete = func(res int32) {
+		s.id, s.done, s.res = 0, true, res
+		if complete != nil {
+			complete(res)
+		}
+		f.notifyReady()
+	}
+	s.id = u.submit(sqe, op)
+}
+
+// take consumes the result of the completed operation.
+func (s *uringOpState) take() int32 {
+	s.done = false
+	return s.res
+}
+
+// UringAsyncFile is an implementation of [AsyncReadWriteCloser] for [UringPoller].
+type UringAsyncFile struct {
+	poller   *UringPoller
+	fd       int
+	readyFut *Future[any]
+
+	// read and write track the operations in flight;
+	// accepts and connects use the read and write slots respectively
+	read, write uringOpState
+	readBuf     []byte
+	// readData holds data read by a completed read that has yet to be returned
+	readData []byte
+	writeBuf []byte
+}
+
+// NewUringAsyncFile wraps the given blocking file descriptor using an [UringAsyncFile].
+func NewUringAsyncFile(poller *UringPoller, fd int) *UringAsyncFile {
+	f := &UringAsyncFile{poller: poller, fd: fd}
+	runtime.SetFinalizer(f, func(f *UringAsyncFile) { _ = f.Close() })
+	return f
+}
+
+func (f *UringAsyncFile) notifyReady() {
+	if f.readyFut != nil {
+		readyFut := f.readyFut
+		f.readyFut = nil
+		readyFut.SetResult(nil, nil)
+	}
+}
+
+// WaitForReady implements [AsyncReadWriteCloser].
+// Returns once an operation submitted on behalf of the file has completed.
+func (f *UringAsyncFile) WaitForReady(ctx context.Context) error {
+	// every read and write waits for its completion, so a wait cancelled along with its coroutine
+	// mustn't leave a cancelled future behind for the next wait to pick up
+	if f.readyFut == nil || f.readyFut.HasResult() {
+		f.readyFut = NewFuture[any]()
+	}
+	_, err := f.readyFut.Await(ctx)
+	return err
+}
+
+// Read implements [io.Reader].
+func (f *UringAsyncFile) Read(p []byte) (n int, err error) {
+	if f.fd < 0 {
+		return 0, unix.EBADF
+	} else if len(f.readData) > 0 {
+		n = copy(p, f.readData)
+		f.readData = f.readData[n:]
+		return n, nil
+	} else if f.read.done {
+		if res := f.read.take(); res < 0 {
+			return 0, unix.Errno(-res)
+		}
+		return 0, io.EOF
+	} else if f.read.pending() {
+		return 0, unix.EAGAIN
+	} else if len(p) == 0 {
+		return 0, nil
+	}
+
+	if cap(f.readBuf) < len(p) {
+		f.readBuf = make([]byte, len(p))
+	}
+	buf := f.readBuf[:len(p)]
+	f.read.start(f.poller, uringSQE{
+		opcode: uringOpRead,
+		fd:     int32(f.fd),
+		off:    ^uint64(0),
+		addr:   uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		len:    uint32(len(buf)),
+	}, f, &uringOp{buf: buf, complete: func(res int32) {
+		if res > 0 {
+			f.read.done = false
+			f.readData = buf[:res]
+		}
+	}})
+	return 0, unix.EAGAIN
+}
+
+// Write implements [io.Writer].
+// Returns [unix.EAGAIN] once the data has been submitted to the kernel,
+// and the number of bytes written by the next call once the write has completed.
+// As when retrying a non-blocking write, the next call must pass the same data;
+// the kernel may have written only part of it, in which case the rest has to be written again.
+func (f *UringAsyncFile) Write(p []byte) (n int, err error) {
+	if f.fd < 0 {
+		return 0, unix.EBADF
+	} else if f.write.done {
+		if res := f.write.take(); res < 0 {
+			return 0, unix.Errno(-res)
+		} else {
+			return min(int(res), len(p)), nil
+		}
+	} else if f.write.pending() {
+		return 0, unix.EAGAIN
+	} else if len(p) == 0 {
+		return 0, nil
+	}
+
+	if cap(f.writeBuf) < len(p) {
+		f.writeBuf = make([]byte, len(p))
+	}
+	buf := f.writeBuf[:copy(f.writeBuf[:len(p)], p)]
+	f.write.start(f.poller, uringSQE{
+		opcode: uringOpWrite,
+		fd:     int32(f.fd),
+		off:    ^uint64(0),
+		addr:   uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		len:    uint32(len(buf)),
+	}, f, &uringOp{buf: buf})
+	return 0, unix.EAGAIN
+}
+
+// Close implements [io.Closer].
+// Operations in flight are cancelled.
+func (f *UringAsyncFile) Close() error {
+	if f.fd < 0 {
+		return unix.EBADF
+	}
+	for _, op := range []*uringOpState{&f.read, &f.write} {
+		if op.pending() {
+			f.poller.cancel(op.id)
+		}
+	}
+	fd := f.fd
+	f.fd = -1
+	return unix.Close(fd)
+}
+
+// Fd implements [Fder].
+func (f *UringAsyncFile) Fd() uintptr {
+	return uintptr(f.fd)
+}
+
+// UringListener is an implementation of [AsyncListener] for [UringPoller].
+type UringListener struct {
+	*UringAsyncFile
+	addr net.Addr
+	hook AcceptHook
+	// path is the filesystem path of a unix socket, removed once the listener is closed
+	path string
+}
+
+// Accept implements [AsyncListener].
+func (l *UringListener) Accept() (conn AsyncReadWriteCloser, remote net.Addr, err error) {
+	if l.fd < 0 {
+		return nil, nil, unix.EBADF
+	} else if l.read.done {
+		res := l.read.take()
+		if res < 0 {
+			return nil, nil, unix.Errno(-res)
+		}
+		fd := int(res)
+
+		var remote net.Addr
+		if sockAddr, err := unix.Getpeername(fd); err == nil {
+			remote = sockAddrToNetAddr(sockAddr, l.addr)
+		}
+		if l.hook == nil || l.hook(uintptr(fd), remote) == nil {
+			f, err := l.poller.open(fd)
+			if err != nil {
+				return nil, nil, err
+			}
+			return f, remote, nil
+		}
+		// rejected; move on to the next pending connection
+		_ = unix.Close(fd)
+	} else if l.read.pending() {
+		return nil, nil, unix.EAGAIN
+	}
+
+	l.read.start(l.poller, uringSQE{
+		opcode:  uringOpAccept,
+		fd:      int32(l.fd),
+		opFlags: unix.SOCK_CLOEXEC,
+	}, l.UringAsyncFile, &uringOp{})
+	return nil, nil, unix.EAGAIN
+}
+
+// SetAcceptHook sets a hook to run for each accepted connection
+// before it is wrapped in an [UringAsyncFile].
+func (l *UringListener) SetAcceptHook(hook AcceptHook) {
+	l.hook = hook
+}
+
+// Addr implements [AsyncListener].
+func (l *UringListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *UringListener) disownSocketPath() {
+	l.path = ""
+}
+
+// Close implements [io.Closer].
+func (l *UringListener) Close() error {
+	err := l.UringAsyncFile.Close()
+	unlinkSocketPath(&l.path)
+	// wake up any pending Accept so it can observe that the listener has been closed
+	l.notifyReady()
+	return err
+}
@@ -0,0 +1,142 @@
+//go:build linux && uring && !channels
+
+package asyncigo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMain(m *testing.M) {
+	// io_uring may be unavailable, e.g. on older kernels or if blocked by seccomp
+	poller, err := NewPoller()
+	if err != nil {
+		fmt.Printf("skipping tests, io_uring is unavailable: %v\n", err)
+		os.Exit(0)
+	}
+	_ = poller.Close()
+	os.Exit(m.Run())
+}
+
+// retryUring repeats op until it no longer fails with EAGAIN, waiting for f to become ready in between.
+func retryUring(ctx context.Context, f AsyncReadWriteCloser, op func() (int, error)) (int, error) {
+	for {
+		n, err := op()
+		if !errors.Is(err, unix.EAGAIN) {
+			return n, err
+		}
+		if err := f.WaitForReady(ctx); err != nil {
+			return n, err
+		}
+	}
+}
+
+func TestUringAsyncFile_ShortWrite(t *testing.T) {
+	testEventLoop(t, "short write", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		r, w, err := loop.poller.Pipe()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		// write more than fits in the pipe, so that the kernel completes the write partially
+		data := make([]byte, 1<<18)
+		for i := range data {
+			data[i] = byte(i % 251)
+		}
+		var shortWrites int
+		writer := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			defer w.Close()
+			for remaining := data; len(remaining) > 0; {
+				n, err := retryUring(ctx, w, func() (int, error) {
+					return w.Write(remaining)
+				})
+				if err != nil {
+					return nil, err
+				}
+				if n < len(remaining) {
+					shortWrites++
+				}
+				remaining = remaining[n:]
+			}
+			return nil, nil
+		})
+
+		var got []byte
+		buf := make([]byte, 4096)
+		for {
+			n, err := retryUring(ctx, r, func() (int, error) {
+				return r.Read(buf)
+			})
+			got = append(got, buf[:n]...)
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		if _, err := writer.Await(ctx); err != nil {
+			return err
+		}
+
+		if !bytes.Equal(got, data) {
+			t.Errorf("expected to read back the %d bytes written, got %d bytes", len(data), len(got))
+		}
+		if shortWrites == 0 {
+			t.Errorf("expected the kernel to complete some writes partially")
+		}
+		return nil
+	})
+}
+
+func TestUringAsyncFile_CloseInFlight(t *testing.T) {
+	testEventLoop(t, "close in flight", false, -1, func(ctx context.Context, loop *EventLoop, t *testing.T) error {
+		r, w, err := loop.poller.Pipe()
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+
+		// submit a read that can't complete until data is written
+		if _, err := r.Read(make([]byte, 16)); !errors.Is(err, unix.EAGAIN) {
+			t.Fatalf("expected read to be submitted, got: %v", err)
+		}
+		waiter := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, r.WaitForReady(ctx)
+		})
+		if err := Checkpoint(ctx); err != nil {
+			return err
+		}
+
+		if err := r.Close(); err != nil {
+			return err
+		}
+		// the buffer of the cancelled read must stay alive until the kernel is done with it
+		runtime.GC()
+
+		// the read is cancelled, waking up the waiter, rather than left hanging
+		if _, err := waiter.AwaitTimeout(ctx, time.Second); err != nil {
+			t.Errorf("expected waiter to be woken up by the cancelled read, got: %v", err)
+		}
+		if _, err := r.Read(make([]byte, 16)); !errors.Is(err, unix.EBADF) {
+			t.Errorf("expected reading from closed file to fail with %v, got: %v", unix.EBADF, err)
+		}
+
+		// nothing is reading from the pipe anymore, so the cancelled read mustn't consume any data written to it
+		if _, err := retryUring(ctx, w, func() (int, error) {
+			return w.Write([]byte("hello"))
+		}); !errors.Is(err, unix.EPIPE) {
+			t.Errorf("expected writing to pipe without readers to fail with %v, got: %v", unix.EPIPE, err)
+		}
+		return nil
+	})
+}
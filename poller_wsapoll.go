@@ -0,0 +1,562 @@
+//go:build windows && !channels
+
+package asyncigo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modws2_32       = windows.NewLazySystemDLL("ws2_32.dll")
+	procWSAPoll     = modws2_32.NewProc("WSAPoll")
+	procAccept      = modws2_32.NewProc("accept")
+	procIoctlsocket = modws2_32.NewProc("ioctlsocket")
+)
+
+const (
+	wsaPollWrNorm = 0x0010
+	wsaPollRdNorm = 0x0100
+
+	fionbio = 0x8004667e
+	soError = 0x1007
+)
+
+// wsaPollFd mirrors the WSAPOLLFD structure.
+type wsaPollFd struct {
+	fd      windows.Handle
+	events  int16
+	revents int16
+}
+
+// WSAPoller is a [Poller] implementation for Windows based on WSAPoll.
+//
+// WSAPoll is level-triggered, so only sockets with a coroutine waiting in WaitForReady are polled,
+// and only for the direction the socket's last read or write would have blocked in.
+// Only sockets are supported; Pipe returns a pair of connected loopback sockets.
+//
+// Versions of Windows prior to Windows 10 version 2004 may fail to report failed connection attempts,
+// in which case Dial only fails once the context is cancelled.
+type WSAPoller struct {
+	waker     windows.Handle
+	wakerRecv *WSAAsyncSocket
+	wakerBuf  []byte
+
+	subscribed map[windows.Handle]*WSAAsyncSocket
+	fds        []wsaPollFd
+}
+
+// NewPoller constructs a new WSAPoller.
+// Will fail if the sockets used to wake up the poller could not be created.
+func NewPoller() (Poller, error) {
+	poller := &WSAPoller{
+		wakerBuf:   make([]byte, 64),
+		subscribed: make(map[windows.Handle]*WSAAsyncSocket),
+	}
+
+	// loopback connection for waking up the poller from another thread
+	r, w, err := poller.socketPair()
+	if err != nil {
+		return nil, err
+	}
+	poller.waker = w
+	if err := setNonblock(w); err != nil {
+		_ = windows.Closesocket(r)
+		_ = windows.Closesocket(w)
+		return nil, err
+	}
+	if poller.wakerRecv, err = poller.adoptSocket(r); err != nil {
+		_ = windows.Closesocket(w)
+		return nil, err
+	}
+	return poller, nil
+}
+
+// Close implements [Poller].
+func (p *WSAPoller) Close() error {
+	_ = p.wakerRecv.Close()
+	return windows.Closesocket(p.waker)
+}
+
+// Wait implements [Poller].
+func (p *WSAPoller) Wait(timeout time.Duration) error {
+	p.fds = append(p.fds[:0], wsaPollFd{fd: p.wakerRecv.handle, events: wsaPollRdNorm})
+	for handle, s := range p.subscribed {
+		if s.readyFut != nil && s != p.wakerRecv {
+			p.fds = append(p.fds, wsaPollFd{fd: handle, events: s.pollEvents()})
+		}
+	}
+
+	ms := min(max(0, timeout.Milliseconds()), math.MaxInt32)
+	r, _, err := procWSAPoll.Call(uintptr(unsafe.Pointer(&p.fds[0])), uintptr(len(p.fds)), uintptr(ms))
+	if int32(r) < 0 {
+		return err
+	}
+
+	for _, fd := range p.fds {
+		if fd.revents == 0 {
+			continue
+		} else if fd.fd == p.wakerRecv.handle {
+			p.drainWaker()
+		} else if s := p.subscribed[fd.fd]; s != nil {
+			s.notifyReady()
+		}
+	}
+	return nil
+}
+
+// WakeupThreadsafe implements [Poller].
+func (p *WSAPoller) WakeupThreadsafe() error {
+	var sent uint32
+	buf := windows.WSABuf{Len: 1, Buf: &[]byte{1}[0]}
+	err := windows.WSASend(p.waker, &buf, 1, &sent, 0, nil, nil)
+	if errors.Is(err, windows.WSAEWOULDBLOCK) {
+		// the buffer is full of pending wakeups already
+		return nil
+	}
+	return err
+}
+
+func (p *WSAPoller) drainWaker() {
+	for {
+		if _, err := p.wakerRecv.Read(p.wakerBuf); err != nil {
+			return
+		}
+	}
+}
+
+// Subscribe instructs the poller to start listening for events for the given socket.
+func (p *WSAPoller) Subscribe(target *WSAAsyncSocket) error {
+	if err := setNonblock(target.handle); err != nil {
+		return err
+	}
+	p.subscribed[target.handle] = target
+	return nil
+}
+
+// Unsubscribe instructs the poller to stop listening for events for the given socket.
+func (p *WSAPoller) Unsubscribe(target *WSAAsyncSocket) error {
+	delete(p.subscribed, target.handle)
+	return nil
+}
+
+// Open wraps the given socket handle and subscribes to its events.
+func (p *WSAPoller) Open(fd uintptr) (file AsyncReadWriteCloser, err error) {
+	return p.adoptSocket(windows.Handle(fd))
+}
+
+// Pipe implements [Poller].
+// Windows pipes can't be polled, so a pair of connected loopback sockets is used instead.
+func (p *WSAPoller) Pipe() (r, w AsyncReadWriteCloser, err error) {
+	rh, wh, err := p.socketPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if r, err = p.adoptSocket(rh); err != nil {
+		_ = windows.Closesocket(wh)
+		return nil, nil, err
+	}
+	if w, err = p.adoptSocket(wh); err != nil {
+		_ = r.Close()
+		return nil, nil, err
+	}
+	return r, w, nil
+}
+
+// socketPair returns a pair of blocking sockets connected to each other over the loopback interface.
+func (p *WSAPoller) socketPair() (r, w windows.Handle, err error) {
+	l, err := newSocket(windows.AF_INET)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer windows.Closesocket(l)
+
+	if err := windows.Bind(l, &windows.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		return 0, 0, err
+	}
+	if err := windows.Listen(l, 1); err != nil {
+		return 0, 0, err
+	}
+	addr, err := windows.Getsockname(l)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if w, err = newSocket(windows.AF_INET); err != nil {
+		return 0, 0, err
+	}
+	if err := windows.Connect(w, addr); err != nil {
+		_ = windows.Closesocket(w)
+		return 0, 0, err
+	}
+	if r, _, err = accept(l); err != nil {
+		_ = windows.Closesocket(w)
+		return 0, 0, err
+	}
+	return r, w, nil
+}
+
+// Dial implements [Poller].
+func (p *WSAPoller) Dial(ctx context.Context, network, address string) (conn AsyncReadWriteCloser, err error) {
+	if network == "unix" {
+		s, err := p.connect(ctx, windows.AF_UNIX, &windows.SockaddrUnix{Name: address})
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	} else if network != "tcp" {
+		return nil, errors.New("unsupported connection type")
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := net.DefaultResolver.LookupPort(ctx, network, port)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	// try to connect in parallel and return the first successful connection
+	futs := make([]Coroutine2[*WSAAsyncSocket], len(addrs))
+	for i, addr := range addrs {
+		futs[i] = func(ctx context.Context) (*WSAAsyncSocket, error) {
+			if ipv4 := addr.IP.To4(); ipv4 != nil {
+				return p.connect(ctx, windows.AF_INET, &windows.SockaddrInet4{Port: portNum, Addr: [net.IPv4len]byte(ipv4)})
+			}
+			return p.connect(ctx, windows.AF_INET6, &windows.SockaddrInet6{Port: portNum, Addr: [net.IPv6len]byte(addr.IP.To16())})
+		}
+	}
+
+	return GetFirstResult(ctx, futs...)
+}
+
+// connect opens a non-blocking stream socket connected to the given address.
+func (p *WSAPoller) connect(ctx context.Context, domain int, sockAddr windows.Sockaddr) (*WSAAsyncSocket, error) {
+	handle, err := newSocket(domain)
+	if err != nil {
+		return nil, err
+	}
+	s, err := p.adoptSocket(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	err = windows.Connect(handle, sockAddr)
+	if errors.Is(err, windows.WSAEWOULDBLOCK) {
+		// the socket becomes writable once connected, and reports an error if the connection failed
+		s.wantWrite = true
+		if err = s.WaitForReady(ctx); err == nil {
+			var soErr int
+			if soErr, err = windows.GetsockoptInt(handle, windows.SOL_SOCKET, soError); err == nil && soErr != 0 {
+				err = syscall.Errno(soErr)
+			}
+		}
+	}
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Listen implements [Poller].
+func (p *WSAPoller) Listen(ctx context.Context, network, address string) (AsyncListener, error) {
+	tcpAddr, err := net.ResolveTCPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	domain, sockAddr := windows.AF_INET6, windows.Sockaddr(&windows.SockaddrInet6{Port: tcpAddr.Port})
+	if ipv4 := tcpAddr.IP.To4(); ipv4 != nil || (tcpAddr.IP == nil && network == "tcp4") {
+		sa := &windows.SockaddrInet4{Port: tcpAddr.Port}
+		copy(sa.Addr[:], ipv4)
+		domain, sockAddr = windows.AF_INET, sa
+	} else if tcpAddr.IP != nil {
+		sockAddr.(*windows.SockaddrInet6).Addr = [net.IPv6len]byte(tcpAddr.IP.To16())
+	}
+
+	handle, err := newSocket(domain)
+	if err != nil {
+		return nil, err
+	}
+	if domain == windows.AF_INET6 && network != "tcp6" {
+		// accept IPv4 connections as well, as on other platforms
+		if err := windows.SetsockoptInt(handle, windows.IPPROTO_IPV6, windows.IPV6_V6ONLY, 0); err != nil {
+			_ = windows.Closesocket(handle)
+			return nil, err
+		}
+	}
+	if err := windows.Bind(handle, sockAddr); err != nil {
+		_ = windows.Closesocket(handle)
+		return nil, err
+	}
+	if err := windows.Listen(handle, windows.SOMAXCONN); err != nil {
+		_ = windows.Closesocket(handle)
+		return nil, err
+	}
+	bound, err := windows.Getsockname(handle)
+	if err != nil {
+		_ = windows.Closesocket(handle)
+		return nil, err
+	}
+
+	s, err := p.adoptSocket(handle)
+	if err != nil {
+		return nil, err
+	}
+	return &WSAListener{WSAAsyncSocket: s, addr: wsaSockAddrToNetAddr(bound)}, nil
+}
+
+func (p *WSAPoller) adoptSocket(handle windows.Handle) (*WSAAsyncSocket, error) {
+	s := NewWSAAsyncSocket(p, handle)
+	if err := p.Subscribe(s); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// WSAAsyncSocket is an implementation of [AsyncReadWriteCloser] for [WSAPoller].
+type WSAAsyncSocket struct {
+	poller   *WSAPoller
+	handle   windows.Handle
+	readyFut *Future[any]
+
+	// wantRead and wantWrite record which directions the socket last blocked in,
+	// and thus which events to poll for
+	wantRead  bool
+	wantWrite bool
+}
+
+// NewWSAAsyncSocket wraps the given socket handle using a [WSAAsyncSocket].
+func NewWSAAsyncSocket(poller *WSAPoller, handle windows.Handle) *WSAAsyncSocket {
+	s := &WSAAsyncSocket{poller: poller, handle: handle}
+	runtime.SetFinalizer(s, func(s *WSAAsyncSocket) { _ = s.Close() })
+	return s
+}
+
+// pollEvents returns the events to poll for while a coroutine is waiting for the socket.
+func (s *WSAAsyncSocket) pollEvents() int16 {
+	var events int16
+	if s.wantRead {
+		events |= wsaPollRdNorm
+	}
+	if s.wantWrite {
+		events |= wsaPollWrNorm
+	}
+	if events == 0 {
+		events = wsaPollRdNorm | wsaPollWrNorm
+	}
+	return events
+}
+
+func (s *WSAAsyncSocket) notifyReady() {
+	s.wantRead, s.wantWrite = false, false
+	if s.readyFut != nil {
+		readyFut := s.readyFut
+		s.readyFut = nil
+		readyFut.SetResult(nil, nil)
+	}
+}
+
+// WaitForReady implements [AsyncReadWriteCloser].
+func (s *WSAAsyncSocket) WaitForReady(ctx context.Context) error {
+	if s.readyFut == nil {
+		s.readyFut = NewFuture[any]()
+	}
+	_, err := s.readyFut.Await(ctx)
+	return err
+}
+
+// Read implements [io.Reader].
+func (s *WSAAsyncSocket) Read(p []byte) (n int, err error) {
+	if s.handle == windows.InvalidHandle {
+		return 0, syscall.EBADF
+	} else if len(p) == 0 {
+		return 0, nil
+	}
+
+	var received, flags uint32
+	buf := windows.WSABuf{Len: uint32(len(p)), Buf: &p[0]}
+	if err := windows.WSARecv(s.handle, &buf, 1, &received, &flags, nil, nil); err != nil {
+		return 0, s.wouldBlock(err, &s.wantRead)
+	} else if received == 0 {
+		return 0, io.EOF
+	}
+	return int(received), nil
+}
+
+// Write implements [io.Writer].
+func (s *WSAAsyncSocket) Write(p []byte) (n int, err error) {
+	return s.Writev([][]byte{p})
+}
+
+// Writev writes the given buffers using a single WSASend call.
+func (s *WSAAsyncSocket) Writev(bufs [][]byte) (n int, err error) {
+	if s.handle == windows.InvalidHandle {
+		return 0, syscall.EBADF
+	}
+
+	wsaBufs := make([]windows.WSABuf, 0, len(bufs))
+	for _, b := range bufs {
+		if len(b) > 0 {
+			wsaBufs = append(wsaBufs, windows.WSABuf{Len: uint32(len(b)), Buf: &b[0]})
+		}
+	}
+	if len(wsaBufs) == 0 {
+		return 0, nil
+	}
+
+	var sent uint32
+	if err := windows.WSASend(s.handle, &wsaBufs[0], uint32(len(wsaBufs)), &sent, 0, nil, nil); err != nil {
+		return 0, s.wouldBlock(err, &s.wantWrite)
+	}
+	return int(sent), nil
+}
+
+// wouldBlock translates WSAEWOULDBLOCK into [syscall.EAGAIN] as expected by [AsyncStream],
+// recording the direction the socket blocked in.
+func (s *WSAAsyncSocket) wouldBlock(err error, want *bool) error {
+	if errors.Is(err, windows.WSAEWOULDBLOCK) {
+		*want = true
+		return syscall.EAGAIN
+	}
+	return err
+}
+
+// Close implements [io.Closer].
+func (s *WSAAsyncSocket) Close() error {
+	if s.handle == windows.InvalidHandle {
+		return syscall.EBADF
+	}
+	_ = s.poller.Unsubscribe(s)
+	handle := s.handle
+	s.handle = windows.InvalidHandle
+	return windows.Closesocket(handle)
+}
+
+// Fd implements [Fder].
+func (s *WSAAsyncSocket) Fd() uintptr {
+	return uintptr(s.handle)
+}
+
+// WSAListener is an implementation of [AsyncListener] for [WSAPoller].
+type WSAListener struct {
+	*WSAAsyncSocket
+	addr net.Addr
+	hook AcceptHook
+}
+
+// Accept implements [AsyncListener].
+func (l *WSAListener) Accept() (conn AsyncReadWriteCloser, remote net.Addr, err error) {
+	for {
+		handle, sockAddr, err := accept(l.handle)
+		if err != nil {
+			return nil, nil, l.wouldBlock(err, &l.wantRead)
+		}
+
+		remote := wsaSockAddrToNetAddr(sockAddr)
+		if l.hook != nil {
+			if err := l.hook(uintptr(handle), remote); err != nil {
+				// rejected; move on to the next pending connection, if any
+				_ = windows.Closesocket(handle)
+				continue
+			}
+		}
+
+		s, err := l.poller.adoptSocket(handle)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, remote, nil
+	}
+}
+
+// SetAcceptHook sets a hook to run for each accepted connection
+// before it is registered with the poller.
+func (l *WSAListener) SetAcceptHook(hook AcceptHook) {
+	l.hook = hook
+}
+
+// Addr implements [AsyncListener].
+func (l *WSAListener) Addr() net.Addr {
+	return l.addr
+}
+
+// Close implements [io.Closer].
+func (l *WSAListener) Close() error {
+	err := l.WSAAsyncSocket.Close()
+	// wake up any pending Accept so it can observe that the listener has been closed
+	l.notifyReady()
+	return err
+}
+
+// newSocket opens a stream socket that isn't inherited by child processes.
+func newSocket(domain int) (windows.Handle, error) {
+	handle, err := windows.Socket(domain, windows.SOCK_STREAM, 0)
+	if err != nil {
+		return 0, err
+	}
+	if err := windows.SetHandleInformation(handle, windows.HANDLE_FLAG_INHERIT, 0); err != nil {
+		_ = windows.Closesocket(handle)
+		return 0, err
+	}
+	return handle, nil
+}
+
+func setNonblock(handle windows.Handle) error {
+	enabled := uint32(1)
+	r, _, err := procIoctlsocket.Call(uintptr(handle), fionbio, uintptr(unsafe.Pointer(&enabled)))
+	if r != 0 {
+		return err
+	}
+	return nil
+}
+
+func accept(listener windows.Handle) (windows.Handle, windows.Sockaddr, error) {
+	var rsa windows.RawSockaddrAny
+	size := int32(unsafe.Sizeof(rsa))
+	r, _, err := procAccept.Call(uintptr(listener), uintptr(unsafe.Pointer(&rsa)), uintptr(unsafe.Pointer(&size)))
+	handle := windows.Handle(r)
+	if handle == windows.InvalidHandle {
+		return 0, nil, err
+	}
+	if err := windows.SetHandleInformation(handle, windows.HANDLE_FLAG_INHERIT, 0); err != nil {
+		_ = windows.Closesocket(handle)
+		return 0, nil, err
+	}
+	sockAddr, err := rsa.Sockaddr()
+	if err != nil {
+		_ = windows.Closesocket(handle)
+		return 0, nil, err
+	}
+	return handle, sockAddr, nil
+}
+
+func wsaSockAddrToNetAddr(sockAddr windows.Sockaddr) net.Addr {
+	switch sa := sockAddr.(type) {
+	case *windows.SockaddrInet4:
+		return &net.TCPAddr{IP: net.IP(sa.Addr[:]).To16(), Port: sa.Port}
+	case *windows.SockaddrInet6:
+		return &net.TCPAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port}
+	case *windows.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: "unix"}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,46 @@
+package asyncigo
+
+import (
+	"context"
+)
+
+// processWatcher is implemented by [Poller] implementations
+// that can monitor arbitrary processes for exit.
+type processWatcher interface {
+	// WatchProcess returns a handle that becomes ready once the process with the given pid exits.
+	WatchProcess(pid int) (handle AsyncReadWriteCloser, exited func() bool, err error)
+}
+
+// WaitPid suspends the calling coroutine until the process with the given pid exits.
+//
+// Unlike [WaitChild], the process does not need to be a child of the current process,
+// making WaitPid suitable for supervising externally started processes.
+// As a consequence, the exit status of the process is not available.
+// Returns [ErrNotImplemented] if the poller in use does not support watching processes.
+func WaitPid(ctx context.Context, pid int) error {
+	watcher, ok := RunningLoop(ctx).poller.(processWatcher)
+	if !ok {
+		return ErrNotImplemented
+	}
+
+	handle, exited, err := watcher.WatchProcess(pid)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	for !exited() {
+		if err := handle.WaitForReady(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WatchProcess starts a background task that completes once the process with the given pid exits.
+// See [WaitPid] for details.
+func WatchProcess(ctx context.Context, pid int) *Task[any] {
+	return SpawnTask(ctx, func(ctx context.Context) (any, error) {
+		return nil, WaitPid(ctx, pid)
+	})
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package asyncigo
+
+import (
+	"context"
+	"syscall"
+)
+
+type childReaper struct{}
+
+// WaitChild is not supported on this platform and will always return [ErrNotImplemented].
+func WaitChild(_ context.Context, _ int) (syscall.WaitStatus, error) {
+	return syscall.WaitStatus{}, ErrNotImplemented
+}
@@ -0,0 +1,94 @@
+//go:build unix
+
+package asyncigo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// childReaper reaps child processes in response to SIGCHLD
+// and hands their exit statuses to any coroutines waiting on them.
+type childReaper struct {
+	waiters map[int]*Future[syscall.WaitStatus]
+	stop    func()
+}
+
+// WaitChild suspends the calling coroutine until the child process with the given pid exits,
+// and returns its exit status.
+//
+// All children waited on from the same [EventLoop] share a single SIGCHLD handler,
+// so waiting on many processes concurrently does not require a goroutine per process.
+// The child is reaped by this function; do not also call [os/exec.Cmd.Wait] or [os.Process.Wait] on it.
+func WaitChild(ctx context.Context, pid int) (syscall.WaitStatus, error) {
+	loop := RunningLoop(ctx)
+	if loop.reaper == nil {
+		loop.reaper = &childReaper{
+			waiters: make(map[int]*Future[syscall.WaitStatus]),
+		}
+	}
+	return loop.reaper.wait(ctx, loop, pid)
+}
+
+func (r *childReaper) wait(ctx context.Context, loop *EventLoop, pid int) (syscall.WaitStatus, error) {
+	fut, ok := r.waiters[pid]
+	if !ok {
+		fut = NewFuture[syscall.WaitStatus]()
+		r.waiters[pid] = fut
+		fut.AddDoneCallback(func(err error) {
+			delete(r.waiters, pid)
+			if len(r.waiters) == 0 && r.stop != nil {
+				r.stop()
+				r.stop = nil
+			}
+		})
+
+		if r.stop == nil {
+			r.start(ctx, loop)
+		}
+		// the child may have exited before we started listening for SIGCHLD
+		loop.RunCallback(r.reap)
+	}
+	return fut.Shield().Await(ctx)
+}
+
+// start begins relaying SIGCHLD to the event loop.
+func (r *childReaper) start(ctx context.Context, loop *EventLoop) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGCHLD)
+
+	done := make(chan struct{})
+	r.stop = func() {
+		signal.Stop(ch)
+		close(done)
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ch:
+				loop.RunCallbackThreadsafe(ctx, r.reap)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// reap collects the exit status of every waited-on child that has exited.
+func (r *childReaper) reap() {
+	for pid, fut := range r.waiters {
+		var status syscall.WaitStatus
+		wpid, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		} else if err != nil {
+			fut.SetResult(0, err)
+		} else if wpid == pid {
+			fut.SetResult(status, nil)
+		}
+	}
+}
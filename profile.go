@@ -0,0 +1,34 @@
+package asyncigo
+
+import (
+	"context"
+	"io"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+)
+
+// ProfileFor captures a CPU profile and/or an execution trace of the whole process for the given duration,
+// writing them to cpuProfile and execTrace respectively once done. Either writer may be nil to skip it.
+// The loop keeps running as usual while profiling, making it possible to capture
+// latency spikes in production on demand, e.g. from a monitoring endpoint.
+//
+// Only one profile and one trace can be captured at a time per process;
+// an error is returned if profiling or tracing is already in progress.
+func (e *EventLoop) ProfileFor(ctx context.Context, d time.Duration, cpuProfile, execTrace io.Writer) error {
+	if cpuProfile != nil {
+		if err := pprof.StartCPUProfile(cpuProfile); err != nil {
+			return err
+		}
+		defer pprof.StopCPUProfile()
+	}
+	if execTrace != nil {
+		if err := trace.Start(execTrace); err != nil {
+			return err
+		}
+		defer trace.Stop()
+	}
+
+	// the profile and trace are written out even if we're cancelled early
+	return Sleep(ctx, d)
+}
@@ -0,0 +1,92 @@
+package asyncigo
+
+import (
+	"context"
+	"slices"
+)
+
+// ProgressUpdate describes the progress of a long-running operation reported through a [Progress].
+type ProgressUpdate struct {
+	// Done is the amount of work completed so far, e.g. the number of bytes transferred.
+	Done int64
+	// Total is the total amount of work, or 0 if unknown.
+	Total int64
+}
+
+// Percent returns the percentage of the work completed, or 0 if the total is unknown.
+func (u ProgressUpdate) Percent() float64 {
+	if u.Total <= 0 {
+		return 0
+	}
+	return float64(u.Done) / float64(u.Total) * 100
+}
+
+// Progress is a [Future] that, besides its final result, publishes intermediate progress updates,
+// e.g. to drive a progress bar during a long transfer.
+// Updates are observed by iterating over [Progress.Updates].
+type Progress[ResType any] struct {
+	*Future[ResType]
+	last      ProgressUpdate
+	listeners []*Queue[ProgressUpdate]
+}
+
+// NewProgress returns a new [Progress] ready to receive updates and be populated with a result.
+func NewProgress[ResType any]() *Progress[ResType] {
+	p := &Progress[ResType]{Future: NewFuture[ResType]()}
+	p.Future.AddResultCallback(func(ResType, error) {
+		for _, listener := range p.listeners {
+			listener.Close()
+		}
+		p.listeners = nil
+	})
+	return p
+}
+
+// Report publishes a progress update. Updates reported after the Progress has completed are ignored.
+func (p *Progress[ResType]) Report(done, total int64) {
+	if p.HasResult() {
+		return
+	}
+	p.last = ProgressUpdate{Done: done, Total: total}
+	for _, listener := range p.listeners {
+		_ = listener.PutNoWait(p.last)
+	}
+}
+
+// Last returns the most recently reported progress update.
+func (p *Progress[ResType]) Last() ProgressUpdate {
+	return p.last
+}
+
+// Updates returns an [AsyncIterable] over the progress updates reported from now on,
+// starting with the most recent update if any, and ending once the Progress completes.
+// Slow consumers only see the most recent update rather than falling behind.
+// Await the Progress itself to get the final result.
+func (p *Progress[ResType]) Updates(ctx context.Context) AsyncIterable[ProgressUpdate] {
+	return AsyncIter(func(yield func(ProgressUpdate) error) error {
+		if p.HasResult() {
+			return nil
+		}
+
+		listener := NewDroppingQueue[ProgressUpdate](1, OverflowConflate)
+		if p.last != (ProgressUpdate{}) {
+			_ = listener.PutNoWait(p.last)
+		}
+		p.listeners = append(p.listeners, listener)
+		defer func() {
+			p.listeners = slices.DeleteFunc(p.listeners, func(q *Queue[ProgressUpdate]) bool {
+				return q == listener
+			})
+		}()
+
+		for update, err := range listener.Iter(ctx) {
+			if err != nil {
+				return err
+			}
+			if err := yield(update); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
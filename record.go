@@ -0,0 +1,164 @@
+package asyncigo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Direction is the direction of traffic recorded by [RecordStream].
+type Direction byte
+
+const (
+	DirectionRead  Direction = 'R' // data read from the stream
+	DirectionWrite Direction = 'W' // data written to the stream
+)
+
+// recordHeaderSize is the size of the header preceding each record in a capture:
+// an 8-byte timestamp in nanoseconds since the Unix epoch, a 1-byte [Direction], and a 4-byte length.
+const recordHeaderSize = 13
+
+// Record is a single chunk of traffic captured by [RecordStream].
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Data      []byte
+}
+
+// RecordStream starts recording all data read from and written to the stream into sink,
+// along with the time and direction of each read and write.
+// The capture can be read back using [ReadCapture], or replayed using [ReplayStream].
+//
+// Recording hides any optional capabilities of the underlying file handle, such as zero-copy writes,
+// so the stream may behave slightly differently while recorded.
+// Recording is intended for debugging, and writes to sink block the event loop.
+func RecordStream(stream *AsyncStream, sink io.Writer) *AsyncStream {
+	stream.file = &recordingFile{AsyncReadWriteCloser: stream.file, sink: sink}
+	return stream
+}
+
+// recordingFile is an [AsyncReadWriteCloser] that tees all traffic into a capture.
+type recordingFile struct {
+	AsyncReadWriteCloser
+	sink io.Writer
+	err  error
+}
+
+// Read implements [io.Reader].
+func (r *recordingFile) Read(p []byte) (n int, err error) {
+	n, err = r.AsyncReadWriteCloser.Read(p)
+	r.record(DirectionRead, p[:n])
+	return n, err
+}
+
+// Write implements [io.Writer].
+func (r *recordingFile) Write(p []byte) (n int, err error) {
+	n, err = r.AsyncReadWriteCloser.Write(p)
+	r.record(DirectionWrite, p[:n])
+	return n, err
+}
+
+// record writes a record to the sink. Recording stops after the first error writing to the sink.
+func (r *recordingFile) record(direction Direction, data []byte) {
+	if len(data) == 0 || r.err != nil {
+		return
+	}
+
+	var header [recordHeaderSize]byte
+	binary.BigEndian.PutUint64(header[:8], uint64(time.Now().UnixNano()))
+	header[8] = byte(direction)
+	binary.BigEndian.PutUint32(header[9:], uint32(len(data)))
+	if _, r.err = r.sink.Write(header[:]); r.err == nil {
+		_, r.err = r.sink.Write(data)
+	}
+}
+
+// ReadCapture returns an iterator over the records in a capture produced by [RecordStream].
+func ReadCapture(capture io.Reader) AsyncIterable[Record] {
+	return AsyncIter(func(yield func(Record) error) error {
+		for {
+			var header [recordHeaderSize]byte
+			if _, err := io.ReadFull(capture, header[:]); errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			record := Record{
+				Time:      time.Unix(0, int64(binary.BigEndian.Uint64(header[:8]))),
+				Direction: Direction(header[8]),
+				Data:      make([]byte, binary.BigEndian.Uint32(header[9:])),
+			}
+			if record.Direction != DirectionRead && record.Direction != DirectionWrite {
+				return fmt.Errorf("invalid record direction %q", record.Direction)
+			}
+			if _, err := io.ReadFull(capture, record.Data); err != nil {
+				return err
+			}
+			if err := yield(record); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// ReplayStream returns an [AsyncStream] that replays the data read in a capture produced by [RecordStream],
+// so that protocol code can be debugged offline against recorded traffic.
+// Each read returns at most one recorded chunk, reproducing the framing of the original reads,
+// after which the stream reaches EOF. Data written to the stream is discarded.
+func ReplayStream(capture io.Reader) (*AsyncStream, error) {
+	f := &replayFile{}
+	var err error
+	for record := range ReadCapture(capture).UntilErr(&err) {
+		if record.Direction == DirectionRead {
+			f.chunks = append(f.chunks, record.Data)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewAsyncStream(f), nil
+}
+
+// replayFile is an [AsyncReadWriteCloser] that replays recorded reads.
+type replayFile struct {
+	chunks [][]byte
+	closed bool
+}
+
+// Read implements [io.Reader].
+func (r *replayFile) Read(p []byte) (n int, err error) {
+	if r.closed {
+		return 0, ErrStreamClosed
+	} else if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+
+	n = copy(p, r.chunks[0])
+	if r.chunks[0] = r.chunks[0][n:]; len(r.chunks[0]) == 0 {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
+// Write implements [io.Writer].
+func (r *replayFile) Write(p []byte) (n int, err error) {
+	if r.closed {
+		return 0, ErrStreamClosed
+	}
+	return len(p), nil
+}
+
+// Close implements [io.Closer].
+func (r *replayFile) Close() error {
+	r.closed = true
+	return nil
+}
+
+// WaitForReady implements [AsyncReadWriteCloser]. A replayed stream is always ready.
+func (r *replayFile) WaitForReady(ctx context.Context) error {
+	return nil
+}
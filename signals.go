@@ -0,0 +1,51 @@
+package asyncigo
+
+import (
+	"context"
+	"os"
+	"os/signal"
+)
+
+// Signals returns an [AsyncIterable] that yields each of the given signals as it is delivered to the process.
+// If no signals are given, all incoming signals will be relayed.
+//
+// Signals are only intercepted while the iterable is being ranged over;
+// once the iteration stops, signal delivery reverts to its previous behaviour.
+// The iteration will end with an error once the given context is cancelled.
+func Signals(ctx context.Context, sigs ...os.Signal) AsyncIterable[os.Signal] {
+	return AsyncIter(func(yield func(os.Signal) error) error {
+		loop := RunningLoop(ctx)
+
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, sigs...)
+		defer signal.Stop(ch)
+
+		// signals arrive on a channel outside the event loop,
+		// so relay them to the loop thread through a queue
+		var queue Queue[os.Signal]
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case sig := <-ch:
+					loop.RunCallbackThreadsafe(ctx, func() {
+						queue.Push(sig)
+					})
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		for {
+			sig, err := queue.Get().Await(ctx)
+			if err != nil {
+				return err
+			}
+			if err := yield(sig); err != nil {
+				return err
+			}
+		}
+	})
+}
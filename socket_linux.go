@@ -0,0 +1,143 @@
+//go:build linux && !channels
+
+package asyncigo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketConnector opens a stream socket connected to the given address,
+// suspending the calling coroutine until the connection has been established.
+type socketConnector[F AsyncReadWriteCloser] func(ctx context.Context, domain int, sockAddr unix.Sockaddr) (F, error)
+
+// dialSocket resolves the given address and connects to it using connect.
+// The "tcp" and "unix" networks are supported.
+func dialSocket[F AsyncReadWriteCloser](ctx context.Context, network, address string, connect socketConnector[F]) (AsyncReadWriteCloser, error) {
+	if network == "unix" {
+		f, err := connect(ctx, unix.AF_UNIX, &unix.SockaddrUnix{Name: address})
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	} else if network != "tcp" {
+		return nil, errors.New("unsupported connection type")
+	}
+
+	// would have been nice to be able to rely on go's own intelligent address resolution,
+	// and then just retrieve the underlying fd and perform non-blocking operations with it,
+	// but there's no way to keep the connecting code from blocking,
+	// so just do a simple, naive getaddrinfo/socket/connect
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := net.DefaultResolver.LookupPort(ctx, network, port)
+	if err != nil {
+		return nil, err
+	}
+
+	// this is still blocking though :(
+	// consider getaddrinfo_a???
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	// try to connect in parallel and return the first successful connection
+	futs := make([]Coroutine2[F], len(addrs))
+	for i, addr := range addrs {
+		futs[i] = func(ctx context.Context) (F, error) {
+			domain, sockAddr, err := toSockAddr(addr, portNum)
+			if err != nil {
+				var zero F
+				return zero, err
+			}
+			return connect(ctx, domain, sockAddr)
+		}
+	}
+
+	f, err := GetFirstResult(ctx, futs...)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func toSockAddr(addr net.IPAddr, port int) (domain int, sockAddr unix.Sockaddr, err error) {
+	if ipv4 := addr.IP.To4(); len(ipv4) == net.IPv4len {
+		return unix.AF_INET, &unix.SockaddrInet4{Port: port, Addr: [net.IPv4len]byte(ipv4)}, nil
+	} else if ipv6 := addr.IP.To16(); len(ipv6) == net.IPv6len {
+		// handling the zone seems really complicated so no thanks
+		return unix.AF_INET6, &unix.SockaddrInet6{Port: port, Addr: [net.IPv6len]byte(ipv6)}, nil
+	} else {
+		return domain, nil, errors.New("could not parse IP address")
+	}
+}
+
+// listenSocket opens a listening socket, returning a duplicate of its file descriptor
+// owned by the caller along with the address it is listening on.
+// For a unix socket bound to a path, the caller is responsible for removing the path
+// once done with the socket; see [unixSocketPath].
+func listenSocket(ctx context.Context, network, address string) (fd int, addr net.Addr, err error) {
+	// unlike when dialing, binding never blocks (save for address resolution),
+	// so let the standard library take care of parsing the address and setting up the socket,
+	// and then take over the file descriptor
+	listener, err := (&net.ListenConfig{}).Listen(ctx, network, address)
+	if err != nil {
+		return -1, nil, err
+	}
+	defer listener.Close()
+	if unixListener, ok := listener.(*net.UnixListener); ok {
+		// the duplicated socket is still using the path, so leave it to the caller to remove it
+		unixListener.SetUnlinkOnClose(false)
+	}
+
+	rawConn, err := listener.(syscall.Conn).SyscallConn()
+	if err != nil {
+		return -1, nil, err
+	}
+	if cerr := rawConn.Control(func(lfd uintptr) {
+		fd, err = unix.FcntlInt(lfd, unix.F_DUPFD_CLOEXEC, 0)
+	}); cerr != nil {
+		return -1, nil, cerr
+	} else if err != nil {
+		return -1, nil, err
+	}
+	return fd, listener.Addr(), nil
+}
+
+// unixSocketPath returns the path that a listening socket bound to addr should remove when closed,
+// or the empty string if addr isn't a unix socket bound to a path in the filesystem.
+func unixSocketPath(addr net.Addr) string {
+	if unixAddr, ok := addr.(*net.UnixAddr); ok && unixAddr.Name != "" && unixAddr.Name[0] != '@' {
+		return unixAddr.Name
+	}
+	return ""
+}
+
+// unlinkSocketPath removes the path pointed to by path, if any, and clears it so that it's only removed once.
+func unlinkSocketPath(path *string) {
+	if *path != "" {
+		_ = unix.Unlink(*path)
+		*path = ""
+	}
+}
+
+func sockAddrToNetAddr(sockAddr unix.Sockaddr, localAddr net.Addr) net.Addr {
+	switch sa := sockAddr.(type) {
+	case *unix.SockaddrInet4:
+		return &net.TCPAddr{IP: net.IP(sa.Addr[:]).To16(), Port: sa.Port}
+	case *unix.SockaddrInet6:
+		return &net.TCPAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port}
+	case *unix.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: localAddr.Network()}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !unix
+
+package asyncigo
+
+import (
+	"time"
+)
+
+// SetSockOptInt is not supported on this platform and will always return [ErrNotImplemented].
+func (a *AsyncStream) SetSockOptInt(_, _, _ int) error {
+	return ErrNotImplemented
+}
+
+// GetSockOptInt is not supported on this platform and will always return [ErrNotImplemented].
+func (a *AsyncStream) GetSockOptInt(_, _ int) (int, error) {
+	return 0, ErrNotImplemented
+}
+
+// SetSockOptTimeval is not supported on this platform and will always return [ErrNotImplemented].
+func (a *AsyncStream) SetSockOptTimeval(_, _ int, _ time.Duration) error {
+	return ErrNotImplemented
+}
+
+// GetSockOptTimeval is not supported on this platform and will always return [ErrNotImplemented].
+func (a *AsyncStream) GetSockOptTimeval(_, _ int) (time.Duration, error) {
+	return 0, ErrNotImplemented
+}
+
+// SetLinger is not supported on this platform and will always return [ErrNotImplemented].
+func (a *AsyncStream) SetLinger(_ bool, _ time.Duration) error {
+	return ErrNotImplemented
+}
+
+// Linger is not supported on this platform and will always return [ErrNotImplemented].
+func (a *AsyncStream) Linger() (enabled bool, timeout time.Duration, err error) {
+	return false, 0, ErrNotImplemented
+}
+
+// SetSockOptBytes is not supported on this platform and will always return [ErrNotImplemented].
+func (a *AsyncStream) SetSockOptBytes(_, _ int, _ []byte) error {
+	return ErrNotImplemented
+}
+
+// SetSockOptString is not supported on this platform and will always return [ErrNotImplemented].
+func (a *AsyncStream) SetSockOptString(_, _ int, _ string) error {
+	return ErrNotImplemented
+}
+
+// GetSockOptString is not supported on this platform and will always return [ErrNotImplemented].
+func (a *AsyncStream) GetSockOptString(_, _ int) (string, error) {
+	return "", ErrNotImplemented
+}
@@ -0,0 +1,99 @@
+//go:build unix
+
+package asyncigo
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// sockOpt runs the given socket option accessor against the stream's file descriptor.
+func sockOpt[T any](a *AsyncStream, f func(fd int) (T, error)) (result T, err error) {
+	if cerr := a.Control(func(fd uintptr) {
+		result, err = f(int(fd))
+	}); cerr != nil {
+		return result, cerr
+	}
+	return result, err
+}
+
+// SetSockOptInt sets an integer-valued socket option.
+func (a *AsyncStream) SetSockOptInt(level, opt, value int) error {
+	_, err := sockOpt(a, func(fd int) (any, error) {
+		return nil, unix.SetsockoptInt(fd, level, opt, value)
+	})
+	return err
+}
+
+// GetSockOptInt retrieves an integer-valued socket option.
+func (a *AsyncStream) GetSockOptInt(level, opt int) (int, error) {
+	return sockOpt(a, func(fd int) (int, error) {
+		return unix.GetsockoptInt(fd, level, opt)
+	})
+}
+
+// SetSockOptTimeval sets a timeval-valued socket option, such as SO_RCVTIMEO.
+func (a *AsyncStream) SetSockOptTimeval(level, opt int, value time.Duration) error {
+	_, err := sockOpt(a, func(fd int) (any, error) {
+		tv := unix.NsecToTimeval(value.Nanoseconds())
+		return nil, unix.SetsockoptTimeval(fd, level, opt, &tv)
+	})
+	return err
+}
+
+// GetSockOptTimeval retrieves a timeval-valued socket option.
+func (a *AsyncStream) GetSockOptTimeval(level, opt int) (time.Duration, error) {
+	return sockOpt(a, func(fd int) (time.Duration, error) {
+		tv, err := unix.GetsockoptTimeval(fd, level, opt)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(tv.Nano()), nil
+	})
+}
+
+// SetLinger sets the SO_LINGER socket option.
+// If enabled, closing the stream will block until pending data has been sent
+// or the timeout (with a resolution of one second) has elapsed.
+func (a *AsyncStream) SetLinger(enabled bool, timeout time.Duration) error {
+	_, err := sockOpt(a, func(fd int) (any, error) {
+		linger := unix.Linger{Linger: int32(timeout / time.Second)}
+		if enabled {
+			linger.Onoff = 1
+		}
+		return nil, unix.SetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER, &linger)
+	})
+	return err
+}
+
+// Linger retrieves the SO_LINGER socket option.
+func (a *AsyncStream) Linger() (enabled bool, timeout time.Duration, err error) {
+	linger, err := sockOpt(a, func(fd int) (*unix.Linger, error) {
+		return unix.GetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER)
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	return linger.Onoff != 0, time.Duration(linger.Linger) * time.Second, nil
+}
+
+// SetSockOptBytes sets a socket option with an arbitrary binary value, such as SO_BINDTODEVICE.
+func (a *AsyncStream) SetSockOptBytes(level, opt int, value []byte) error {
+	_, err := sockOpt(a, func(fd int) (any, error) {
+		return nil, unix.SetsockoptString(fd, level, opt, string(value))
+	})
+	return err
+}
+
+// SetSockOptString sets a string-valued socket option, such as TCP_CONGESTION.
+func (a *AsyncStream) SetSockOptString(level, opt int, value string) error {
+	return a.SetSockOptBytes(level, opt, []byte(value))
+}
+
+// GetSockOptString retrieves a NUL-terminated string-valued socket option of at most 256 bytes.
+func (a *AsyncStream) GetSockOptString(level, opt int) (string, error) {
+	return sockOpt(a, func(fd int) (string, error) {
+		return unix.GetsockoptString(fd, level, opt)
+	})
+}
@@ -0,0 +1,272 @@
+package asyncigo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socksVersion        = 5
+	socksAuthVersion    = 1
+	socksMethodNoAuth   = 0x00
+	socksMethodUserPass = 0x02
+	socksMethodNone     = 0xff
+	socksCmdConnect     = 0x01
+	socksAddrIPv4       = 0x01
+	socksAddrDomain     = 0x03
+	socksAddrIPv6       = 0x04
+
+	socksReplySucceeded          = 0x00
+	socksReplyHostUnreachable    = 0x04
+	socksReplyCommandUnsupported = 0x07
+	socksReplyAddressUnsupported = 0x08
+
+	// socksRelayChunkSize is the maximum number of bytes relayed at a time
+	socksRelayChunkSize = 32 * 1024
+)
+
+// Socks5Server is a SOCKS5 proxy server (RFC 1928) supporting the CONNECT command.
+// Register [Socks5Server.ServeConn] with [Listener.Serve] to start serving clients:
+//
+//	server := &asyncigo.Socks5Server{BandwidthLimit: 1 << 20}
+//	err := listener.Serve(ctx, server.ServeConn)
+type Socks5Server struct {
+	// Auth, if set, requires clients to authenticate using a username and password (RFC 1929),
+	// rejecting the client if Auth returns an error.
+	// If nil, clients are not required to authenticate.
+	Auth func(ctx context.Context, username, password string) error
+	// Dial connects to the destination requested by the client.
+	// Defaults to [EventLoop.Dial].
+	Dial func(ctx context.Context, network, address string) (*AsyncStream, error)
+	// BandwidthLimit, if positive, limits the number of bytes per second relayed
+	// in each direction of each connection.
+	BandwidthLimit int
+}
+
+// ServeConn implements [ConnHandler], proxying a single client connection.
+func (s *Socks5Server) ServeConn(ctx context.Context, stream *AsyncStream, remote net.Addr) error {
+	if err := s.negotiate(ctx, stream); err != nil {
+		return err
+	}
+
+	address, err := s.readRequest(ctx, stream)
+	if err != nil {
+		return err
+	}
+
+	dial := s.Dial
+	if dial == nil {
+		dial = RunningLoop(ctx).Dial
+	}
+	upstream, err := dial(ctx, "tcp", address)
+	if err != nil {
+		_ = s.reply(ctx, stream, socksReplyHostUnreachable)
+		return err
+	}
+	defer upstream.Close()
+
+	if err := s.reply(ctx, stream, socksReplySucceeded); err != nil {
+		return err
+	}
+	return s.relay(ctx, stream, upstream)
+}
+
+// negotiate agrees on an authentication method with the client and authenticates it.
+func (s *Socks5Server) negotiate(ctx context.Context, stream *AsyncStream) error {
+	header, err := readExactly(ctx, stream, 2)
+	if err != nil {
+		return err
+	} else if header[0] != socksVersion {
+		return fmt.Errorf("socks5: unsupported protocol version %d", header[0])
+	}
+	methods, err := readExactly(ctx, stream, int(header[1]))
+	if err != nil {
+		return err
+	}
+
+	want := byte(socksMethodNoAuth)
+	if s.Auth != nil {
+		want = socksMethodUserPass
+	}
+	method := byte(socksMethodNone)
+	for _, m := range methods {
+		if m == want {
+			method = want
+		}
+	}
+	if _, err := stream.Write(ctx, []byte{socksVersion, method}).Await(ctx); err != nil {
+		return err
+	}
+
+	switch method {
+	case socksMethodNone:
+		return ErrSocksNoAcceptableMethod
+	case socksMethodUserPass:
+		return s.authenticate(ctx, stream)
+	}
+	return nil
+}
+
+// authenticate performs username/password authentication as per RFC 1929.
+func (s *Socks5Server) authenticate(ctx context.Context, stream *AsyncStream) error {
+	header, err := readExactly(ctx, stream, 2)
+	if err != nil {
+		return err
+	} else if header[0] != socksAuthVersion {
+		return fmt.Errorf("socks5: unsupported authentication version %d", header[0])
+	}
+	username, err := readExactly(ctx, stream, int(header[1]))
+	if err != nil {
+		return err
+	}
+	passwordLen, err := readExactly(ctx, stream, 1)
+	if err != nil {
+		return err
+	}
+	password, err := readExactly(ctx, stream, int(passwordLen[0]))
+	if err != nil {
+		return err
+	}
+
+	authErr := s.Auth(ctx, string(username), string(password))
+	status := byte(0)
+	if authErr != nil {
+		status = 1
+	}
+	if _, err := stream.Write(ctx, []byte{socksAuthVersion, status}).Await(ctx); err != nil {
+		return err
+	}
+	if authErr != nil {
+		return errors.Join(ErrSocksAuthFailed, authErr)
+	}
+	return nil
+}
+
+// readRequest reads the client's request, returning the requested destination address.
+func (s *Socks5Server) readRequest(ctx context.Context, stream *AsyncStream) (string, error) {
+	header, err := readExactly(ctx, stream, 4)
+	if err != nil {
+		return "", err
+	} else if header[0] != socksVersion {
+		return "", fmt.Errorf("socks5: unsupported protocol version %d", header[0])
+	}
+
+	var host string
+	switch header[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		size := net.IPv4len
+		if header[3] == socksAddrIPv6 {
+			size = net.IPv6len
+		}
+		ip, err := readExactly(ctx, stream, size)
+		if err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socksAddrDomain:
+		size, err := readExactly(ctx, stream, 1)
+		if err != nil {
+			return "", err
+		}
+		domain, err := readExactly(ctx, stream, int(size[0]))
+		if err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		_ = s.reply(ctx, stream, socksReplyAddressUnsupported)
+		return "", ErrSocksUnsupported
+	}
+
+	port, err := readExactly(ctx, stream, 2)
+	if err != nil {
+		return "", err
+	}
+
+	if header[1] != socksCmdConnect {
+		_ = s.reply(ctx, stream, socksReplyCommandUnsupported)
+		return "", ErrSocksUnsupported
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// reply sends a reply to the client's request with the given status.
+// The bound address is not tracked, so it is always reported as 0.0.0.0:0.
+func (s *Socks5Server) reply(ctx context.Context, stream *AsyncStream, status byte) error {
+	msg := []byte{socksVersion, status, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0}
+	_, err := stream.Write(ctx, msg).Await(ctx)
+	return err
+}
+
+// relay copies data in both directions until either side closes the connection.
+func (s *Socks5Server) relay(ctx context.Context, client, upstream *AsyncStream) error {
+	up := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+		return nil, s.copy(ctx, upstream, client)
+	})
+	down := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+		return nil, s.copy(ctx, client, upstream)
+	})
+
+	_ = Wait(ctx, WaitFirstResult, up, down)
+	up.Cancel(nil)
+	down.Cancel(nil)
+	_ = Wait(ctx, WaitAll, up, down)
+
+	for _, task := range []*Task[any]{up, down} {
+		if err := task.Err(); err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+	return nil
+}
+
+// copy copies data from src to dst until src reaches EOF, respecting the bandwidth limit.
+func (s *Socks5Server) copy(ctx context.Context, dst, src *AsyncStream) error {
+	chunkSize := socksRelayChunkSize
+	if s.BandwidthLimit > 0 {
+		chunkSize = min(chunkSize, s.BandwidthLimit)
+	}
+
+	start := time.Now()
+	var copied int
+	for chunk, err := range src.Stream(ctx, chunkSize) {
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(ctx, chunk).Await(ctx); err != nil {
+			return err
+		}
+
+		copied += len(chunk)
+		if s.BandwidthLimit > 0 {
+			due := time.Duration(float64(copied) / float64(s.BandwidthLimit) * float64(time.Second))
+			if wait := due - time.Since(start); wait > 0 {
+				if err := Sleep(ctx, wait); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readExactly reads exactly n bytes from the stream,
+// returning [io.ErrUnexpectedEOF] if the stream ends early.
+func readExactly(ctx context.Context, stream *AsyncStream, n int) ([]byte, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	data, err := stream.ReadChunk(ctx, n)
+	if err != nil {
+		return nil, err
+	} else if len(data) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
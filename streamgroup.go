@@ -0,0 +1,78 @@
+package asyncigo
+
+import (
+	"context"
+)
+
+// StreamGroup tracks a set of [AsyncStream] instances, allowing a caller to wait
+// until I/O on all of them has come to rest, e.g. before taking a snapshot of application state
+// or switching traffic over to another process. StreamGroup is not threadsafe.
+type StreamGroup struct {
+	streams map[*AsyncStream]struct{}
+	// changed completes whenever the membership of the group changes
+	changed *Future[any]
+}
+
+// NewStreamGroup constructs a new, empty [StreamGroup].
+func NewStreamGroup() *StreamGroup {
+	return &StreamGroup{streams: make(map[*AsyncStream]struct{})}
+}
+
+// Add adds the given streams to the group.
+func (g *StreamGroup) Add(streams ...*AsyncStream) {
+	for _, stream := range streams {
+		g.streams[stream] = struct{}{}
+	}
+	g.notifyChanged()
+}
+
+// Remove removes the given streams from the group.
+func (g *StreamGroup) Remove(streams ...*AsyncStream) {
+	for _, stream := range streams {
+		delete(g.streams, stream)
+	}
+	g.notifyChanged()
+}
+
+// Len returns the number of streams in the group.
+func (g *StreamGroup) Len() int {
+	return len(g.streams)
+}
+
+// Drained waits until every stream in the group is drained, meaning that all writes
+// started using [AsyncStream.Write] or [AsyncStream.Send] have completed,
+// and that all data read from the underlying file has been consumed. Closed streams are always drained.
+// Returns immediately if the group is empty.
+//
+// Streams added while waiting are waited for as well, while streams removed while waiting are no longer waited for.
+// Drained only guarantees that the group was drained at the point it returns;
+// stop new I/O from being started first if the streams have to stay drained.
+func (g *StreamGroup) Drained(ctx context.Context) error {
+	for {
+		var pending []Futurer
+		for stream := range g.streams {
+			if !stream.drained() {
+				pending = append(pending, stream.drainedFuture())
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		if g.changed == nil {
+			g.changed = NewFuture[any]()
+		}
+		pending = append(pending, g.changed)
+		if err := Wait(ctx, WaitFirstResult, pending...); err != nil {
+			return err
+		}
+	}
+}
+
+func (g *StreamGroup) notifyChanged() {
+	if g.changed != nil {
+		changed := g.changed
+		g.changed = nil
+		changed.SetResult(nil, nil)
+	}
+}
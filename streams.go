@@ -5,8 +5,10 @@ import (
 	"context"
 	"errors"
 	"io"
+	"log/slog"
 	"slices"
 	"syscall"
+	"time"
 )
 
 // AsyncStream is a byte stream that can be read from and written to asynchronously.
@@ -15,9 +17,79 @@ type AsyncStream struct {
 
 	buffer []byte
 
-	writeLock Mutex
+	writeLock   Mutex
+	zeroCopyMin int
+	closed      bool
+	// readResumed is set while reading is paused, and completes once reading is resumed
+	readResumed *Future[any]
+	opts        StreamOptions
+
+	// frames queued using Send, waiting to be flushed
+	sendQueue [][]byte
+	sendFut   *Future[any]
+	// pendingWrites is the number of writes that have been started but not yet completed
+	pendingWrites int
+	// drainedFut is set while a [StreamGroup] is waiting for the stream to be drained
+	drainedFut *Future[any]
+
+	// lastActive is the time data was last read from or written to the stream
+	lastActive time.Time
+	// readStreak and writeStreak count the reads and writes completed without waiting since the last yield
+	readStreak  int
+	writeStreak int
+
+	// loop is set if the stream was opened through an [EventLoop] method,
+	// and is used to report when the stream is closed
+	loop     *EventLoop
+	logAttrs []slog.Attr
+}
+
+// corker is implemented by file handles that can delay sending partial frames.
+type corker interface {
+	SetCork(cork bool) error
+}
+
+// readInterester is implemented by file handles that can stop the poller
+// from listening for incoming data.
+type readInterester interface {
+	SetReadInterest(enabled bool) error
+}
+
+// vectorWriter is implemented by file handles that can write multiple buffers in a single system call.
+type vectorWriter interface {
+	Writev(bufs [][]byte) (n int, err error)
+}
+
+// zeroCopyWriter is implemented by file handles that support zero-copy sends.
+type zeroCopyWriter interface {
+	EnableZeroCopy() error
+	WriteZeroCopy(ctx context.Context, p []byte) (n int, err error)
 }
 
+// StreamOptions configures how an [AsyncStream] buffers incoming data.
+// The zero value uses the default for each option.
+type StreamOptions struct {
+	// InitialBufferSize is the number of bytes initially read at a time
+	// when scanning for a delimiter (e.g. in [AsyncStream.ReadLine]) or reading all data.
+	// Defaults to 1024.
+	InitialBufferSize int
+	// GrowthFactor is the factor the read size is multiplied by whenever the buffer fills up
+	// before a delimiter is found. Defaults to 2.
+	GrowthFactor float64
+	// MaxBufferSize is the maximum number of bytes the stream will buffer.
+	// Reads that would need to buffer more than this fail with [ErrBufferFull].
+	// Defaults to no limit.
+	MaxBufferSize int
+	// YieldEvery is the number of consecutive reads or writes that may complete without waiting
+	// before the stream yields to the loop, so that bulk transfers on a file that is always ready
+	// don't starve other tasks. Cancellation is checked before every read and write regardless.
+	// Defaults to 16; a negative value disables yielding.
+	YieldEvery int
+}
+
+// defaultYieldEvery is the default value of [StreamOptions.YieldEvery].
+const defaultYieldEvery = 16
+
 // NewAsyncStream constructs a new [AsyncStream].
 func NewAsyncStream(file AsyncReadWriteCloser) *AsyncStream {
 	return &AsyncStream{
@@ -25,12 +97,152 @@ func NewAsyncStream(file AsyncReadWriteCloser) *AsyncStream {
 	}
 }
 
+// NewAsyncStreamOpts constructs a new [AsyncStream] with the given buffering options.
+func NewAsyncStreamOpts(file AsyncReadWriteCloser, opts StreamOptions) *AsyncStream {
+	stream := NewAsyncStream(file)
+	stream.SetOptions(opts)
+	return stream
+}
+
+// SetOptions changes the buffering options of the stream,
+// e.g. for streams opened using [EventLoop.Dial]. See [StreamOptions].
+func (a *AsyncStream) SetOptions(opts StreamOptions) {
+	a.opts = opts
+}
+
+func (a *AsyncStream) initialBufSize() int {
+	if a.opts.InitialBufferSize > 0 {
+		return a.opts.InitialBufferSize
+	}
+	return 1024
+}
+
+// checkpoint is called before each attempt to read from or write to the file,
+// returning early if ctx has been cancelled, and yielding to the loop
+// if too many operations in a row have completed without having to wait.
+func (a *AsyncStream) checkpoint(ctx context.Context, streak *int) error {
+	if err := context.Cause(ctx); err != nil {
+		return newCancelledError(err)
+	}
+
+	yieldEvery := a.opts.YieldEvery
+	if yieldEvery == 0 {
+		yieldEvery = defaultYieldEvery
+	}
+	if *streak++; yieldEvery < 0 || *streak <= yieldEvery {
+		return nil
+	}
+	*streak = 0
+	return Checkpoint(ctx)
+}
+
+// waitForReady waits for the file to become ready, resetting the given streak.
+func (a *AsyncStream) waitForReady(ctx context.Context, streak *int) error {
+	*streak = 0
+	return a.file.WaitForReady(ctx)
+}
+
+func (a *AsyncStream) growBufSize(bufSize int) int {
+	if a.opts.GrowthFactor > 1 {
+		return max(bufSize+1, int(float64(bufSize)*a.opts.GrowthFactor))
+	}
+	return bufSize * 2
+}
+
+// SetZeroCopy opts in to zero-copy sends (MSG_ZEROCOPY on Linux) for writes of at least minSize bytes.
+// Zero-copy sends avoid copying data into the kernel, but require waiting for the kernel
+// to release the written data, so they only pay off for large writes (typically over 10 KiB).
+// If the kernel has to copy the data anyway, the stream falls back to regular writes automatically.
+//
+// Returns [ErrNotImplemented] if the underlying file handle does not support zero-copy sends.
+func (a *AsyncStream) SetZeroCopy(minSize int) error {
+	zc, ok := a.file.(zeroCopyWriter)
+	if !ok {
+		return ErrNotImplemented
+	}
+	if err := zc.EnableZeroCopy(); err != nil {
+		return err
+	}
+	a.zeroCopyMin = max(1, minSize)
+	return nil
+}
+
+// Control calls f with the file descriptor of the underlying file handle,
+// allowing for operations not directly supported by AsyncStream.
+// The file descriptor must not be used after f returns.
+// Returns [ErrNotImplemented] if the underlying file handle has no file descriptor.
+func (a *AsyncStream) Control(f func(fd uintptr)) error {
+	fder, ok := a.file.(Fder)
+	if !ok {
+		return ErrNotImplemented
+	}
+	f(fder.Fd())
+	return nil
+}
+
+// PauseReading stops the stream from reading any more data until [AsyncStream.ResumeReading] is called.
+// Data that has already been buffered can still be consumed, after which reads will be suspended.
+// Where supported, the poller also stops listening for incoming data in the meantime,
+// letting the kernel's receive buffer fill up so that a fast peer is forced to slow down.
+func (a *AsyncStream) PauseReading() error {
+	if a.readResumed != nil {
+		return nil
+	}
+	a.readResumed = NewFuture[any]()
+
+	if ri, ok := a.file.(readInterester); ok {
+		return ri.SetReadInterest(false)
+	}
+	return nil
+}
+
+// ResumeReading resumes reading after a call to [AsyncStream.PauseReading],
+// waking up any suspended reads.
+func (a *AsyncStream) ResumeReading() error {
+	if a.readResumed == nil {
+		return nil
+	}
+	resumed := a.readResumed
+	a.readResumed = nil
+
+	var err error
+	if ri, ok := a.file.(readInterester); ok {
+		err = ri.SetReadInterest(true)
+	}
+	resumed.SetResult(nil, nil)
+	return err
+}
+
 // Close closes the stream.
+// Any further operations on the stream will fail with [ErrStreamClosed].
 func (a *AsyncStream) Close() error {
-	return a.file.Close()
+	if a.closed {
+		return ErrStreamClosed
+	}
+	a.closed = true
+	defer a.notifyDrained()
+	if a.readResumed != nil {
+		// wake up any paused reads so they can observe that the stream has been closed
+		a.readResumed.SetResult(nil, nil)
+	}
+
+	err := a.file.Close()
+	if a.loop != nil {
+		a.loop.log(context.Background(), slog.LevelDebug, "stream closed", func() []slog.Attr {
+			return append(slices.Clip(a.logAttrs), slog.Any("error", err))
+		})
+	}
+	return err
 }
 
 func (a *AsyncStream) read(ctx context.Context, maxBytes int) (n int, err error) {
+	if limit := a.opts.MaxBufferSize; limit > 0 && maxBytes > limit {
+		if len(a.buffer) >= limit {
+			return len(a.buffer), ErrBufferFull
+		}
+		maxBytes = limit
+	}
+
 	if len(a.buffer) >= maxBytes {
 		return maxBytes, nil
 	}
@@ -40,13 +252,28 @@ func (a *AsyncStream) read(ctx context.Context, maxBytes int) (n int, err error)
 	}
 
 	for {
+		if a.closed {
+			return len(a.buffer), ErrStreamClosed
+		}
+		if a.readResumed != nil {
+			// shield the future, as it is shared by all paused reads
+			if _, err := a.readResumed.Shield().Await(ctx); err != nil {
+				return len(a.buffer), err
+			}
+			continue
+		}
+		if err := a.checkpoint(ctx, &a.readStreak); err != nil {
+			return len(a.buffer), err
+		}
+
 		readN, err := a.file.Read(a.buffer[len(a.buffer):maxBytes])
 		if readN > 0 {
 			a.buffer = a.buffer[:len(a.buffer)+readN]
+			a.lastActive = time.Now()
 		}
 
 		if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) {
-			if err = a.file.WaitForReady(ctx); err == nil {
+			if err = a.waitForReady(ctx, &a.readStreak); err == nil {
 				continue
 			}
 		}
@@ -55,38 +282,211 @@ func (a *AsyncStream) read(ctx context.Context, maxBytes int) (n int, err error)
 	}
 }
 
+// LastActive returns the time data was last read from or written to the stream,
+// or the zero time if no data has been transferred yet.
+func (a *AsyncStream) LastActive() time.Time {
+	return a.lastActive
+}
+
 // Write writes the given data to the stream.
 // The returned [Awaitable] can be awaited to be sure that all data has been written before continuing.
 func (a *AsyncStream) Write(ctx context.Context, data []byte) Awaitable[int] {
-	return SpawnTask(ctx, func(ctx context.Context) (int, error) {
+	return trackWrite(a, SpawnTask(ctx, func(ctx context.Context) (int, error) {
 		// prevent chunks from being interleaved if multiple tasks are writing at the same time
 		if err := a.writeLock.Lock(ctx); err != nil {
 			return 0, err
 		}
 		defer a.writeLock.Unlock()
 
+		if zc, ok := a.file.(zeroCopyWriter); ok && a.zeroCopyMin > 0 && len(data) >= a.zeroCopyMin {
+			n, err := zc.WriteZeroCopy(ctx, data)
+			if n > 0 {
+				a.lastActive = time.Now()
+			}
+			return n, err
+		}
+
 		var bytesWritten int
 		for {
+			if a.closed {
+				return bytesWritten, ErrStreamClosed
+			}
+			if err := a.checkpoint(ctx, &a.writeStreak); err != nil {
+				return bytesWritten, err
+			}
+
 			n, err := a.file.Write(data)
 			if n > 0 {
 				bytesWritten += n
 				data = data[n:]
+				a.lastActive = time.Now()
 			}
 
 			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) {
-				err = a.file.WaitForReady(ctx)
+				err = a.waitForReady(ctx, &a.writeStreak)
 			}
 			if err != nil || len(data) == 0 {
 				return bytesWritten, err
 			}
 		}
+	}))
+}
+
+// trackWrite counts the given write as pending until it completes.
+// Writes are commonly fired and forgotten, with failures such as the peer having closed the connection
+// surfacing through the next read instead, so the write is marked as observed
+// to keep its error from being reported to the loop's error handler.
+func trackWrite[T any](a *AsyncStream, write *Task[T]) *Task[T] {
+	a.pendingWrites++
+	write.AddDoneCallback(func(error) {
+		a.pendingWrites--
+		a.notifyDrained()
+	})
+	return write
+}
+
+// drained reports whether the stream has no pending writes and no buffered unread data.
+// A closed stream is always considered drained.
+func (a *AsyncStream) drained() bool {
+	return a.closed || (a.pendingWrites == 0 && len(a.sendQueue) == 0 && len(a.buffer) == 0)
+}
+
+// drainedFuture returns a future that completes once the stream has been drained.
+func (a *AsyncStream) drainedFuture() *Future[any] {
+	if a.drainedFut == nil {
+		a.drainedFut = NewFuture[any]()
+	}
+	return a.drainedFut
+}
+
+// notifyDrained completes the future returned by drainedFuture if the stream has been drained.
+func (a *AsyncStream) notifyDrained() {
+	if a.drainedFut != nil && a.drained() {
+		fut := a.drainedFut
+		a.drainedFut = nil
+		fut.SetResult(nil, nil)
+	}
+}
+
+// BatchWrites calls fn with a writer that queues writes to this stream,
+// and waits for all writes to complete before returning.
+// Where supported (TCP sockets on Linux), the stream is corked while fn runs,
+// so that multiple small writes composing one logical message are sent as few packets as possible.
+//
+// BatchWrites returns the error returned by fn, or the first error encountered while writing.
+func (a *AsyncStream) BatchWrites(ctx context.Context, fn func(w io.Writer) error) error {
+	c, ok := a.file.(corker)
+	if corked := ok && c.SetCork(true) == nil; corked {
+		defer func() {
+			_ = c.SetCork(false)
+		}()
+	}
+
+	w := &batchWriter{ctx: ctx, stream: a}
+	err := fn(w)
+	if len(w.writes) == 0 {
+		return err
+	}
+	return errors.Join(err, Wait(ctx, WaitAll, w.writes...))
+}
+
+// Send queues a frame to be written to the stream.
+// Frames sent during the same iteration of the event loop are coalesced and written
+// using as few system calls as possible once control returns to the loop,
+// which greatly reduces overhead for protocols that send many small messages.
+// The frame must not be modified until it has been written.
+//
+// The returned [Future] completes once the batch containing the frame has been written.
+// The batch is written using the context of the first call to Send in the batch.
+func (a *AsyncStream) Send(ctx context.Context, frame []byte) *Future[any] {
+	a.sendQueue = append(a.sendQueue, frame)
+	if a.sendFut == nil {
+		a.sendFut = NewFuture[any]()
+		RunningLoop(ctx).RunCallback(func() {
+			a.flushSendQueue(ctx)
+		})
+	}
+	return a.sendFut
+}
+
+// flushSendQueue writes all frames queued using [AsyncStream.Send].
+func (a *AsyncStream) flushSendQueue(ctx context.Context) {
+	frames, fut := a.sendQueue, a.sendFut
+	a.sendQueue, a.sendFut = nil, nil
+
+	vw, ok := a.file.(vectorWriter)
+	if !ok || len(frames) == 1 {
+		a.Write(ctx, bytes.Join(frames, nil)).AddDoneCallback(func(err error) {
+			fut.SetResult(nil, err)
+		})
+		return
+	}
+
+	trackWrite(a, SpawnTask(ctx, func(ctx context.Context) (any, error) {
+		if err := a.writeLock.Lock(ctx); err != nil {
+			return nil, err
+		}
+		defer a.writeLock.Unlock()
+
+		for len(frames) > 0 {
+			if a.closed {
+				return nil, ErrStreamClosed
+			}
+			if err := a.checkpoint(ctx, &a.writeStreak); err != nil {
+				return nil, err
+			}
+
+			n, err := vw.Writev(frames[:min(len(frames), maxWritevBuffers)])
+			frames = consumeBuffers(frames, max(0, n))
+			if n > 0 {
+				a.lastActive = time.Now()
+			}
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) {
+				err = a.waitForReady(ctx, &a.writeStreak)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})).AddDoneCallback(func(err error) {
+		fut.SetResult(nil, err)
 	})
 }
 
+// maxWritevBuffers is the maximum number of buffers passed to a single writev call (IOV_MAX on Linux).
+const maxWritevBuffers = 1024
+
+// consumeBuffers removes the first n bytes from bufs.
+func consumeBuffers(bufs [][]byte, n int) [][]byte {
+	for len(bufs) > 0 && n >= len(bufs[0]) {
+		n -= len(bufs[0])
+		bufs = bufs[1:]
+	}
+	if len(bufs) > 0 {
+		bufs[0] = bufs[0][n:]
+	}
+	return bufs
+}
+
+// batchWriter queues writes to an [AsyncStream] for [AsyncStream.BatchWrites].
+type batchWriter struct {
+	ctx    context.Context
+	stream *AsyncStream
+	writes []Futurer
+}
+
+// Write implements [io.Writer].
+func (b *batchWriter) Write(p []byte) (n int, err error) {
+	b.writes = append(b.writes, b.stream.Write(b.ctx, slices.Clone(p)))
+	return len(p), nil
+}
+
 func (a *AsyncStream) consumeInto(buf []byte) (n int) {
 	n = copy(buf, a.buffer)
 	copy(a.buffer, a.buffer[n:])
 	a.buffer = a.buffer[:len(a.buffer)-n]
+	a.notifyDrained()
 	return n
 }
 
@@ -99,6 +499,7 @@ func (a *AsyncStream) consume(maxBytes int) []byte {
 func (a *AsyncStream) consumeAll() []byte {
 	buf := slices.Clone(a.buffer)
 	a.buffer = a.buffer[:0]
+	a.notifyDrained()
 	return buf
 }
 
@@ -161,7 +562,7 @@ func (a *AsyncStream) yieldLines(yield func([]byte) error, data []byte) error {
 // The newline character will be included with each line.
 func (a *AsyncStream) Lines(ctx context.Context) AsyncIterable[[]byte] {
 	return AsyncIter(func(yield func([]byte) error) error {
-		bufSize := 1024
+		bufSize := a.initialBufSize()
 		scanned := 0
 		for {
 			_, err := a.read(ctx, bufSize)
@@ -181,12 +582,71 @@ func (a *AsyncStream) Lines(ctx context.Context) AsyncIterable[[]byte] {
 			}
 			scanned = len(a.buffer)
 			if len(a.buffer) >= bufSize {
-				bufSize *= 2
+				bufSize = a.growBufSize(bufSize)
 			}
 		}
 	})
 }
 
+// LinesBorrowed is like [AsyncStream.Lines], but yields lines without copying them out of the stream's buffer.
+// See [AsyncStream.SplitBorrowed] for the restrictions this places on the caller.
+func (a *AsyncStream) LinesBorrowed(ctx context.Context) AsyncIterable[[]byte] {
+	return a.SplitBorrowed(ctx, '\n')
+}
+
+// SplitBorrowed returns an AsyncIterable that iterates over the stream in segments terminated by sep.
+// The separator will be included with each segment, except possibly the last.
+//
+// To avoid allocating for each segment, the yielded slices point directly into the stream's internal buffer,
+// and are only valid until the next iteration. Callers that need to hold on to a segment must copy it.
+// The stream must not be read from by other means until iteration has finished.
+func (a *AsyncStream) SplitBorrowed(ctx context.Context, sep byte) AsyncIterable[[]byte] {
+	return AsyncIter(func(yield func([]byte) error) error {
+		bufSize := a.initialBufSize()
+		scanned := 0
+		var err error
+		for {
+			start := 0
+			for i := scanned; i < len(a.buffer); i++ {
+				if a.buffer[i] == sep {
+					// cap the segment so that appending to it can't overwrite the rest of the buffer
+					if err := yield(a.buffer[start : i+1 : i+1]); err != nil {
+						a.discard(i + 1)
+						return err
+					}
+					start = i + 1
+				}
+			}
+			a.discard(start)
+			scanned = len(a.buffer)
+
+			if errors.Is(err, io.EOF) {
+				if len(a.buffer) == 0 {
+					return nil
+				}
+				err = yield(a.buffer[:len(a.buffer):len(a.buffer)])
+				a.discard(len(a.buffer))
+				return err
+			} else if err != nil {
+				return err
+			}
+
+			if len(a.buffer) >= bufSize {
+				bufSize = a.growBufSize(bufSize)
+			}
+			_, err = a.read(ctx, bufSize)
+		}
+	})
+}
+
+// discard drops the first n bytes of the buffer, reusing its memory.
+func (a *AsyncStream) discard(n int) {
+	if n > 0 {
+		a.buffer = a.buffer[:copy(a.buffer, a.buffer[n:])]
+		a.notifyDrained()
+	}
+}
+
 // ReadLine returns all data until a newline is encountered, including the newline.
 func (a *AsyncStream) ReadLine(ctx context.Context) ([]byte, error) {
 	return a.ReadUntil(ctx, '\n')
@@ -200,7 +660,7 @@ func (a *AsyncStream) ReadUntil(ctx context.Context, character byte) ([]byte, er
 		}
 	}
 
-	bufSize := 1024
+	bufSize := a.initialBufSize()
 	for {
 		n, err := a.read(ctx, bufSize)
 		for i := len(a.buffer) - n; i < len(a.buffer); i++ {
@@ -215,7 +675,7 @@ func (a *AsyncStream) ReadUntil(ctx context.Context, character byte) ([]byte, er
 		}
 
 		if len(a.buffer) >= bufSize {
-			bufSize *= 2
+			bufSize = a.growBufSize(bufSize)
 		}
 	}
 }
@@ -236,8 +696,68 @@ func (a *AsyncStream) ReadChunk(ctx context.Context, chunkSize int) ([]byte, err
 func (a *AsyncStream) ReadAll(ctx context.Context) ([]byte, error) {
 	var buf bytes.Buffer
 	var err error
-	for chunk := range a.Stream(ctx, 1024).UntilErr(&err) {
+	for chunk := range a.Stream(ctx, a.initialBufSize()).UntilErr(&err) {
 		buf.Write(chunk)
 	}
 	return buf.Bytes(), err
 }
+
+// readNChunkSize is the maximum number of bytes [AsyncStream.ReadN] holds in memory at a time.
+const readNChunkSize = 32 * 1024
+
+// ReadN copies exactly n bytes from the stream to w, without reading more than a small chunk
+// of the data into memory at a time. This makes it suitable for e.g. writing large message bodies to disk.
+// The given progress callbacks are called with the total number of bytes copied so far
+// each time a chunk has been written to w.
+//
+// If the stream ends before n bytes have been read, [io.ErrUnexpectedEOF] is returned.
+func (a *AsyncStream) ReadN(ctx context.Context, w io.Writer, n int64, progress ...func(copied int64)) (copied int64, err error) {
+	for copied < n {
+		want := int(min(n-copied, readNChunkSize))
+
+		var readErr error
+		if len(a.buffer) == 0 {
+			_, readErr = a.read(ctx, want)
+		}
+
+		if chunk := a.buffer[:min(len(a.buffer), want)]; len(chunk) > 0 {
+			written, err := w.Write(chunk)
+			a.discard(written)
+			copied += int64(written)
+			for _, f := range progress {
+				f(copied)
+			}
+
+			if err != nil {
+				return copied, err
+			} else if written < len(chunk) {
+				return copied, io.ErrShortWrite
+			}
+		}
+
+		if errors.Is(readErr, io.EOF) {
+			return copied, io.ErrUnexpectedEOF
+		} else if readErr != nil {
+			return copied, readErr
+		}
+	}
+	return copied, nil
+}
+
+// ReadNProgress is like [AsyncStream.ReadN], but runs the copy as a background task,
+// returning a [Progress] that reports the number of bytes copied so far
+// and completes with the total number of bytes copied.
+func (a *AsyncStream) ReadNProgress(ctx context.Context, w io.Writer, n int64) *Progress[int64] {
+	p := NewProgress[int64]()
+	task := SpawnTask(ctx, func(ctx context.Context) (int64, error) {
+		return a.ReadN(ctx, w, n, func(copied int64) {
+			p.Report(copied, n)
+		})
+	})
+	task.AddResultCallback(p.SetResult)
+	// cancelling the Progress stops the copy
+	p.AddDoneCallback(func(err error) {
+		task.Cancel(err)
+	})
+	return p
+}
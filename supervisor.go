@@ -0,0 +1,289 @@
+package asyncigo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	defaultSupervisorMinBackoff = time.Millisecond * 100
+	defaultSupervisorMaxBackoff = time.Second * 30
+)
+
+// RestartPolicy decides whether a [Supervisor] restarts a child once it has exited.
+type RestartPolicy int
+
+const (
+	RestartAlways    RestartPolicy = iota // restart the child whenever it exits
+	RestartOnFailure                      // restart the child only if it returned an error
+	RestartNever                          // never restart the child
+)
+
+// ChildSpec describes a long-running coroutine to be run by a [Supervisor].
+type ChildSpec struct {
+	// Name identifies the child within the supervisor, and is used as the name of its task.
+	Name string
+	// Run is the coroutine to run. It is called again each time the child is restarted.
+	Run     Coroutine1
+	Restart RestartPolicy
+	// MaxRestarts is the number of times the child may be restarted before the supervisor gives up on it.
+	// If 0, the child is restarted indefinitely.
+	MaxRestarts int
+	// MinBackoff is the delay before the first restart, doubling with each consecutive restart
+	// up to MaxBackoff. A child that ran for at least MaxBackoff before exiting
+	// is restarted after MinBackoff again. The defaults are 100ms and 30s respectively.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// SupervisorEventKind identifies a lifecycle event of a [Supervisor] child.
+type SupervisorEventKind int
+
+const (
+	ChildStarted    SupervisorEventKind = iota // the child has been started or restarted
+	ChildExited                                // the child returned, successfully or not
+	ChildRestarting                            // the child will be restarted once the backoff has elapsed
+	ChildFinished                              // the child exited and will not be restarted
+	ChildStopped                               // the child was stopped by the supervisor
+)
+
+// String implements [fmt.Stringer].
+func (k SupervisorEventKind) String() string {
+	switch k {
+	case ChildStarted:
+		return "started"
+	case ChildExited:
+		return "exited"
+	case ChildRestarting:
+		return "restarting"
+	case ChildFinished:
+		return "finished"
+	case ChildStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("SupervisorEventKind(%d)", int(k))
+}
+
+// SupervisorEvent describes a lifecycle event of a [Supervisor] child. See [Supervisor.Events].
+type SupervisorEvent struct {
+	Child string
+	Kind  SupervisorEventKind
+	// Restarts is the number of times the child had been restarted when the event occurred.
+	Restarts int
+	// Err is the error the child exited with, for ChildExited and ChildFinished events.
+	Err error
+	// Backoff is the delay before the child is restarted, for ChildRestarting events.
+	Backoff time.Duration
+}
+
+// Supervisor keeps long-running coroutines, such as the components of a daemon, running
+// by restarting them according to their [RestartPolicy] when they exit.
+//
+// The supervisor is shut down automatically once the main task of the loop it was started on exits,
+// so that its children are cancelled and can clean up rather than keeping the loop running forever.
+// Call [Supervisor.Shutdown] to stop the children earlier.
+// A child that exits with an error and won't be restarted reports the error
+// to the loop's error handler; see [EventLoop.SetErrorHandler].
+// Supervisor is not threadsafe.
+type Supervisor struct {
+	children  []*supervisedChild
+	listeners []*Queue[SupervisorEvent]
+	shutdown  bool
+
+	// mainTask is the main task of the loop the supervisor was started on,
+	// and exitHandle the callback that shuts down the supervisor once it exits
+	mainTask   *Future[any]
+	exitHandle *CallbackHandle
+}
+
+// supervisedChild is the state kept by a [Supervisor] for each of its children.
+type supervisedChild struct {
+	spec     ChildSpec
+	task     *Task[any]
+	restarts int
+	started  bool
+	stopping bool
+}
+
+// NewSupervisor constructs a new [Supervisor] with no children.
+func NewSupervisor() *Supervisor {
+	return &Supervisor{}
+}
+
+// Start starts running the child described by spec.
+// Returns [ErrSupervisorShutdown] if the supervisor has been shut down,
+// or an error if the supervisor already has a child with the same name.
+func (s *Supervisor) Start(ctx context.Context, spec ChildSpec) error {
+	// shuts down the supervisor straight away if the main task has already exited
+	if loop := RunningLoop(ctx); !s.shutdown && s.mainTask == nil && loop.mainTask != nil {
+		s.mainTask = loop.mainTask
+		s.exitHandle = s.mainTask.AddDoneCallback(func(error) {
+			s.Shutdown()
+		})
+	}
+
+	if s.shutdown {
+		return ErrSupervisorShutdown
+	} else if s.child(spec.Name) != nil {
+		return fmt.Errorf("supervisor already has a child named %q", spec.Name)
+	}
+
+	if spec.MinBackoff <= 0 {
+		spec.MinBackoff = defaultSupervisorMinBackoff
+	}
+	if spec.MaxBackoff <= 0 {
+		spec.MaxBackoff = defaultSupervisorMaxBackoff
+	}
+	spec.MaxBackoff = max(spec.MinBackoff, spec.MaxBackoff)
+
+	c := &supervisedChild{spec: spec}
+	c.task = SpawnTask(ctx, func(ctx context.Context) (any, error) {
+		return nil, s.supervise(ctx, c)
+	}).WithName("supervisor: " + spec.Name)
+	s.children = append(s.children, c)
+	return nil
+}
+
+// Stop stops the child with the given name, cancelling it with [ErrSupervisorShutdown]
+// and removing it from the supervisor.
+// Returns false if the supervisor has no child with that name.
+func (s *Supervisor) Stop(name string) bool {
+	c := s.child(name)
+	if c == nil {
+		return false
+	}
+	s.stop(c)
+	return true
+}
+
+// Restarts returns the number of times the child with the given name has been restarted,
+// or -1 if the supervisor has no child with that name.
+func (s *Supervisor) Restarts(name string) int {
+	if c := s.child(name); c != nil {
+		return c.restarts
+	}
+	return -1
+}
+
+// Shutdown stops all children, cancelling them with [ErrSupervisorShutdown],
+// and ends any iterations over [Supervisor.Events]. No more children can be started afterwards.
+// Shutdown is called automatically once the main task of the loop exits.
+func (s *Supervisor) Shutdown() {
+	if s.shutdown {
+		return
+	}
+	s.shutdown = true
+	if s.mainTask != nil {
+		s.mainTask.RemoveCallback(s.exitHandle)
+		s.mainTask, s.exitHandle = nil, nil
+	}
+
+	for len(s.children) > 0 {
+		s.stop(s.children[0])
+	}
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	s.listeners = nil
+}
+
+// Events returns an [AsyncIterable] over the lifecycle events of all children from now on,
+// ending once the supervisor is shut down.
+func (s *Supervisor) Events(ctx context.Context) AsyncIterable[SupervisorEvent] {
+	return AsyncIter(func(yield func(SupervisorEvent) error) error {
+		if s.shutdown {
+			return nil
+		}
+
+		listener := &Queue[SupervisorEvent]{}
+		s.listeners = append(s.listeners, listener)
+		defer func() {
+			s.listeners = slices.DeleteFunc(s.listeners, func(q *Queue[SupervisorEvent]) bool {
+				return q == listener
+			})
+		}()
+
+		for event, err := range listener.Iter(ctx) {
+			if err != nil {
+				return err
+			}
+			if err := yield(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Supervisor) child(name string) *supervisedChild {
+	for _, c := range s.children {
+		if c.spec.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *Supervisor) stop(c *supervisedChild) {
+	s.remove(c)
+	c.stopping = true
+	c.task.Cancel(ErrSupervisorShutdown)
+	if !c.started {
+		// the child never got to run, so supervise won't report it
+		s.emit(SupervisorEvent{Child: c.spec.Name, Kind: ChildStopped})
+	}
+}
+
+func (s *Supervisor) remove(c *supervisedChild) {
+	s.children = slices.DeleteFunc(s.children, func(other *supervisedChild) bool {
+		return other == c
+	})
+}
+
+func (s *Supervisor) emit(event SupervisorEvent) {
+	for _, listener := range s.listeners {
+		_ = listener.PutNoWait(event)
+	}
+}
+
+// supervise runs the child, restarting it according to its policy until it finishes or is stopped.
+func (s *Supervisor) supervise(ctx context.Context, c *supervisedChild) error {
+	c.started = true
+	backoff := c.spec.MinBackoff
+	for {
+		s.emit(SupervisorEvent{Child: c.spec.Name, Kind: ChildStarted, Restarts: c.restarts})
+		started := time.Now()
+		_, err := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+			return nil, c.spec.Run(ctx)
+		}).WithName(c.spec.Name).Await(ctx)
+		if c.stopping {
+			s.emit(SupervisorEvent{Child: c.spec.Name, Kind: ChildStopped, Restarts: c.restarts})
+			return nil
+		}
+		s.emit(SupervisorEvent{Child: c.spec.Name, Kind: ChildExited, Restarts: c.restarts, Err: err})
+
+		restart := c.spec.Restart == RestartAlways || (c.spec.Restart == RestartOnFailure && err != nil)
+		if !restart || (c.spec.MaxRestarts > 0 && c.restarts >= c.spec.MaxRestarts) {
+			s.remove(c)
+			s.emit(SupervisorEvent{Child: c.spec.Name, Kind: ChildFinished, Restarts: c.restarts, Err: err})
+			return err
+		}
+
+		if time.Since(started) >= c.spec.MaxBackoff {
+			// the child was running fine for a while, so don't hold earlier failures against it
+			backoff = c.spec.MinBackoff
+		}
+		s.emit(SupervisorEvent{Child: c.spec.Name, Kind: ChildRestarting, Restarts: c.restarts, Backoff: backoff})
+		if err := Sleep(ctx, backoff); err != nil {
+			if c.stopping {
+				s.emit(SupervisorEvent{Child: c.spec.Name, Kind: ChildStopped, Restarts: c.restarts})
+				return nil
+			}
+			return err
+		}
+		c.restarts++
+		backoff = min(backoff*2, c.spec.MaxBackoff)
+	}
+}
@@ -0,0 +1,177 @@
+package asyncigo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// TextCommandHandler handles a single command received by a [TextProtocolServer].
+// args holds the whitespace-separated arguments following the command name.
+// The returned response is sent to the client, terminated by a newline.
+//
+// If the handler returns an error, "ERR" followed by the error is sent instead.
+// Returning [io.EOF] sends the response and then closes the connection.
+type TextCommandHandler func(ctx context.Context, args []string) (response string, err error)
+
+// textCommand is a [TextCommandHandler] registered with a [TextProtocolServer].
+type textCommand struct {
+	handler TextCommandHandler
+	timeout time.Duration
+}
+
+// TextProtocolServer serves simple line-oriented protocols, where each line sent by the client
+// is a command name followed by its arguments, and the server replies with a line in response.
+// Command names are case-insensitive.
+type TextProtocolServer struct {
+	listener *Listener
+	commands map[string]textCommand
+	timeout  time.Duration
+	limits   ConnLimits
+}
+
+// ConnLimits limits the lifetime of each connection served by a [TextProtocolServer].
+// Once a limit is reached, the connection is closed gracefully, after any in-flight command has been answered.
+// This lets clients reconnect elsewhere, e.g. to spread load after a rolling restart behind a load balancer.
+type ConnLimits struct {
+	// MaxAge, if positive, is the maximum time a connection is kept open.
+	MaxAge time.Duration
+	// MaxRequests, if positive, is the maximum number of commands served per connection.
+	MaxRequests int
+	// Goodbye, if set, is sent to the client as a final line before a connection is closed
+	// due to reaching a limit, telling the client to reconnect.
+	Goodbye string
+}
+
+// NewTextProtocolServer constructs a [TextProtocolServer] serving connections from the given listener,
+// dispatching commands to the given handlers by name.
+// Use [TextProtocolServer.Serve] to start serving.
+func NewTextProtocolServer(listener *Listener, handlers map[string]TextCommandHandler) *TextProtocolServer {
+	s := &TextProtocolServer{listener: listener, commands: make(map[string]textCommand)}
+	for name, handler := range handlers {
+		s.Handle(name, handler, 0)
+	}
+	return s
+}
+
+// Handle registers a handler for the given command, replacing any existing handler.
+// If timeout is positive, the handler is cancelled if it takes longer than timeout to respond,
+// overriding the server's default timeout.
+func (s *TextProtocolServer) Handle(name string, handler TextCommandHandler, timeout time.Duration) {
+	s.commands[strings.ToUpper(name)] = textCommand{handler: handler, timeout: timeout}
+}
+
+// SetTimeout sets the default timeout for commands registered without a timeout of their own.
+// A timeout of 0 disables the default timeout.
+func (s *TextProtocolServer) SetTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
+
+// SetConnLimits sets the lifetime limits for served connections.
+func (s *TextProtocolServer) SetConnLimits(limits ConnLimits) {
+	s.limits = limits
+}
+
+// Serve accepts and serves connections until the context is cancelled or the listener is closed.
+func (s *TextProtocolServer) Serve(ctx context.Context) error {
+	return s.listener.Serve(ctx, s.ServeConn)
+}
+
+// ServeConn implements [ConnHandler], serving commands from a single client until it disconnects.
+// Commands from the same client are handled one at a time, in order.
+func (s *TextProtocolServer) ServeConn(ctx context.Context, stream *AsyncStream, remote net.Addr) error {
+	var busy, expired bool
+	session := SpawnTask(ctx, func(ctx context.Context) (any, error) {
+		var served int
+		for line, err := range stream.Lines(ctx) {
+			if err != nil {
+				return nil, err
+			}
+
+			fields := strings.Fields(string(line))
+			if len(fields) == 0 {
+				continue
+			}
+
+			busy = true
+			response, err := s.dispatch(ctx, fields[0], fields[1:])
+			if err != nil && !errors.Is(err, io.EOF) {
+				response = "ERR " + err.Error()
+			}
+
+			// wait for the response to be written before reading the next command
+			if werr := s.writeLine(ctx, stream, response); werr != nil {
+				return nil, werr
+			}
+			busy = false
+			if errors.Is(err, io.EOF) {
+				return nil, nil
+			}
+
+			if served++; s.limits.MaxRequests > 0 && served >= s.limits.MaxRequests {
+				expired = true
+			}
+			if expired {
+				return nil, s.goodbye(ctx, stream)
+			}
+		}
+		return nil, nil
+	})
+
+	if s.limits.MaxAge > 0 {
+		// let an in-flight command finish before closing the connection
+		timer := RunningLoop(ctx).ScheduleCallback(s.limits.MaxAge, func() {
+			expired = true
+			if !busy {
+				session.Cancel(nil)
+			}
+		})
+		defer timer.Cancel()
+	}
+
+	_, err := session.Await(ctx)
+	if expired && errors.Is(err, context.Canceled) {
+		return s.goodbye(ctx, stream)
+	}
+	return err
+}
+
+// goodbye sends the goodbye message, if any, to a connection that has reached its lifetime limits.
+func (s *TextProtocolServer) goodbye(ctx context.Context, stream *AsyncStream) error {
+	if s.limits.Goodbye == "" {
+		return nil
+	}
+	return s.writeLine(ctx, stream, s.limits.Goodbye)
+}
+
+// writeLine writes a newline-terminated response to the stream.
+func (s *TextProtocolServer) writeLine(ctx context.Context, stream *AsyncStream, response string) error {
+	if !strings.HasSuffix(response, "\n") {
+		response += "\n"
+	}
+	_, err := stream.Write(ctx, []byte(response)).Await(ctx)
+	return err
+}
+
+// dispatch runs the handler for the given command, subject to its timeout.
+func (s *TextProtocolServer) dispatch(ctx context.Context, name string, args []string) (string, error) {
+	command, ok := s.commands[strings.ToUpper(name)]
+	if !ok {
+		return "", errors.New("unknown command " + name)
+	}
+
+	timeout := command.timeout
+	if timeout <= 0 {
+		timeout = s.timeout
+	}
+	if timeout <= 0 {
+		return command.handler(ctx, args)
+	}
+
+	return SpawnTask(ctx, func(ctx context.Context) (string, error) {
+		return command.handler(ctx, args)
+	}).AwaitTimeout(ctx, timeout)
+}
@@ -0,0 +1,121 @@
+package asyncigo
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// AcceptThrottle limits the rate at which a [Listener] accepts connections from each remote IP address,
+// temporarily banning addresses that exceed the limit.
+// Use [Listener.Throttle] to construct an AcceptThrottle registered with a listener.
+//
+// Connection counts and bans expire lazily based on when they were recorded,
+// so the throttle schedules no timers that could keep the event loop running.
+// Expired state is swept away as connections arrive, so little state is kept
+// for addresses that have not connected recently.
+type AcceptThrottle struct {
+	limit       int
+	window      time.Duration
+	banDuration time.Duration
+
+	// windows maps addresses to the connections counted in their current window
+	windows map[string]throttleWindow
+	// bans maps banned addresses to the time their ban expires
+	bans map[string]time.Time
+	// lastSweep is the time expired windows and bans were last removed
+	lastSweep time.Time
+}
+
+// throttleWindow counts the connections from an address since start.
+type throttleWindow struct {
+	start time.Time
+	count int
+}
+
+// NewAcceptThrottle constructs an [AcceptThrottle] that accepts at most limit connections
+// per IP address within each window. Addresses exceeding the limit are banned for banDuration;
+// if banDuration is zero, excess connections are rejected but the address is not banned.
+func NewAcceptThrottle(limit int, window, banDuration time.Duration) *AcceptThrottle {
+	return &AcceptThrottle{
+		limit:       limit,
+		window:      window,
+		banDuration: banDuration,
+		windows:     make(map[string]throttleWindow),
+		bans:        make(map[string]time.Time),
+	}
+}
+
+// Throttle registers a new [AcceptThrottle] as a filter for this listener. See [NewAcceptThrottle].
+func (l *Listener) Throttle(limit int, window, banDuration time.Duration) *AcceptThrottle {
+	throttle := NewAcceptThrottle(limit, window, banDuration)
+	l.AddFilter(throttle.Filter)
+	return throttle
+}
+
+// Filter implements [AcceptFilter].
+// Connections from addresses other than IP addresses are always accepted.
+func (t *AcceptThrottle) Filter(ctx context.Context, _ *AsyncStream, remote net.Addr) error {
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	ip := tcpAddr.IP
+
+	now := time.Now()
+	t.sweep(now)
+	if t.Banned(ip) {
+		return ErrAddressBanned
+	}
+
+	key := ip.String()
+	w := t.windows[key]
+	if now.Sub(w.start) >= t.window {
+		w = throttleWindow{start: now}
+	}
+	w.count++
+	t.windows[key] = w
+
+	if w.count > t.limit {
+		if t.banDuration > 0 {
+			t.Ban(ctx, ip, t.banDuration)
+		}
+		return ErrAcceptThrottled
+	}
+	return nil
+}
+
+// sweep removes expired windows and bans, at most once per window.
+func (t *AcceptThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.window {
+		return
+	}
+	t.lastSweep = now
+	for key, w := range t.windows {
+		if now.Sub(w.start) >= t.window {
+			delete(t.windows, key)
+		}
+	}
+	for key, expiry := range t.bans {
+		if !now.Before(expiry) {
+			delete(t.bans, key)
+		}
+	}
+}
+
+// Ban rejects all connections from the given IP address for the given duration.
+// Banning an address that is already banned resets the expiry of the ban.
+func (t *AcceptThrottle) Ban(_ context.Context, ip net.IP, duration time.Duration) {
+	t.bans[ip.String()] = time.Now().Add(duration)
+}
+
+// Unban lifts any ban on the given IP address.
+func (t *AcceptThrottle) Unban(ip net.IP) {
+	delete(t.bans, ip.String())
+}
+
+// Banned reports whether the given IP address is currently banned.
+func (t *AcceptThrottle) Banned(ip net.IP) bool {
+	expiry, ok := t.bans[ip.String()]
+	return ok && time.Now().Before(expiry)
+}
@@ -0,0 +1,92 @@
+package asyncigo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"syscall"
+)
+
+// eventNotifier is implemented by [Poller] implementations
+// that can be signalled by foreign code through a file descriptor.
+type eventNotifier interface {
+	// EventFD returns a handle that becomes ready once the returned file descriptor has been signalled
+	// by writing a non-zero native-endian 64-bit integer to it. Reading 8 bytes from the handle
+	// returns the sum of the values written since the last read.
+	EventFD() (handle AsyncReadWriteCloser, fd uintptr, err error)
+}
+
+// CompletionToken is a task that completes once foreign code, such as a C library
+// invoking a completion callback on a thread of its own, signals the token's file descriptor.
+// This allows asynchronous C APIs to be awaited without the foreign code needing to call into Go.
+//
+// To signal the token, write a non-zero native-endian 64-bit integer to the file descriptor
+// returned by [CompletionToken.Handle], e.g. using eventfd_write(3) or write(2).
+// The token then completes with the written value, which can be used to pass a status code.
+// The file descriptor is closed once the token has been signalled, so it must be signalled at most once.
+//
+// Cancelling the token doesn't revoke the file descriptor, since the foreign code may still signal it,
+// and closing it could cause the signal to be written to an unrelated file that reused the descriptor.
+// Once the foreign code is known to be done with a token that was cancelled or failed,
+// e.g. after cancelling the foreign operation, call [CompletionToken.Release] to close the file descriptor.
+type CompletionToken struct {
+	*Task[uint64]
+	handle AsyncReadWriteCloser
+	fd     uintptr
+	closed bool
+}
+
+// NewCompletionToken constructs a new [CompletionToken].
+// Returns [ErrNotImplemented] if the poller in use doesn't support completion tokens;
+// currently only the epoll poller does.
+func NewCompletionToken(ctx context.Context) (*CompletionToken, error) {
+	notifier, ok := RunningLoop(ctx).poller.(eventNotifier)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+
+	handle, fd, err := notifier.EventFD()
+	if err != nil {
+		return nil, err
+	}
+
+	task := SpawnTask(ctx, func(ctx context.Context) (uint64, error) {
+		var buf [8]byte
+		for {
+			if _, err := handle.Read(buf[:]); err == nil {
+				return binary.NativeEndian.Uint64(buf[:]), nil
+			} else if !errors.Is(err, syscall.EAGAIN) {
+				return 0, err
+			}
+			if err := handle.WaitForReady(ctx); err != nil {
+				return 0, err
+			}
+		}
+	})
+	token := &CompletionToken{Task: task, handle: handle, fd: fd}
+	task.AddDoneCallback(func(err error) {
+		// the foreign code is done with the file descriptor only once it has been signalled
+		if err == nil {
+			token.Release()
+		}
+	})
+	return token, nil
+}
+
+// Handle returns the file descriptor to be signalled by foreign code to complete the token.
+func (t *CompletionToken) Handle() uintptr {
+	return t.fd
+}
+
+// Release closes the file descriptor of the token, for when the token was cancelled or failed
+// and the foreign code is known not to signal it anymore. Any further signals are lost.
+// Release has no effect if the token has been signalled, or if it has already been released.
+//
+// Release is not threadsafe, and must be called from the event loop.
+func (t *CompletionToken) Release() {
+	if t.closed {
+		return
+	}
+	t.closed = true
+	_ = t.handle.Close()
+}
@@ -1,36 +1,282 @@
 package asyncigo
 
 import (
+	"container/heap"
 	"context"
+	"errors"
+	"iter"
+	"runtime/debug"
+	"slices"
+	"sync"
 	"time"
 )
 
 // Queue provides a basic asynchronous queue.
+// The zero value is an unbounded Queue; use [NewBoundedQueue] to limit the number of queued items.
 // Queue is not threadsafe.
 type Queue[T any] struct {
-	data []T
-	futs []*Future[T]
+	data   []T
+	futs   []*Future[T]
+	closed bool
+
+	// capacity is the maximum number of queued items, or 0 if unbounded
+	capacity int
+	putters  []queuePutter[T]
+
+	// unfinished counts the items that have been added but not yet marked as done using TaskDone
+	unfinished int
+	joined     *Future[any]
+
+	// less orders the items of a priority queue, while lifo makes the queue pop the most recent item first;
+	// if neither is set the queue is FIFO
+	less func(a, b T) bool
+	lifo bool
+
+	// overflow determines what happens when adding items to a full bounded queue
+	overflow OverflowPolicy
+	dropped  int
+}
+
+// queuePutter is an item waiting for space in a bounded [Queue].
+type queuePutter[T any] struct {
+	item T
+	fut  *Future[any]
+}
+
+// NewBoundedQueue constructs a new [Queue] that holds at most capacity items.
+// Producers should add items using [Queue.Put], which waits for space to become available.
+func NewBoundedQueue[T any](capacity int) *Queue[T] {
+	return &Queue[T]{capacity: max(1, capacity)}
+}
+
+// OverflowPolicy determines what happens when an item is added to a full bounded [Queue].
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // wait for space to become available
+	OverflowDropOldest                       // drop the item that would have been retrieved next to make space
+	OverflowDropNewest                       // drop the item being added
+	OverflowConflate                         // replace any queued item, so that only the latest item is kept
+)
+
+// NewDroppingQueue constructs a new [Queue] that holds at most capacity items,
+// and drops items according to the given policy rather than making producers wait.
+// This bounds memory usage for consumers that can tolerate lossy delivery, such as telemetry pipelines.
+// With [OverflowConflate], the Queue holds at most a single item regardless of capacity.
+//
+// [Queue.Put] and [Queue.PutNoWait] never wait or fail with [ErrQueueFull] on a dropping Queue;
+// use [Queue.Dropped] to find out how many items have been dropped.
+func NewDroppingQueue[T any](capacity int, policy OverflowPolicy) *Queue[T] {
+	if policy == OverflowConflate {
+		capacity = 1
+	}
+	return &Queue[T]{capacity: max(1, capacity), overflow: policy}
+}
+
+// Dropped returns the number of items dropped due to the Queue's [OverflowPolicy].
+func (q *Queue[T]) Dropped() int {
+	return q.dropped
+}
+
+// PriorityQueue is a [Queue] that pops the item with the highest priority first.
+// Items of equal priority are popped in no particular order.
+// A PriorityQueue must be constructed using [NewPriorityQueue].
+type PriorityQueue[T any] struct {
+	Queue[T]
+}
+
+// NewPriorityQueue constructs a new [PriorityQueue] where less reports whether a should be popped before b.
+// If capacity is positive, the queue holds at most capacity items; see [NewBoundedQueue].
+func NewPriorityQueue[T any](capacity int, less func(a, b T) bool) *PriorityQueue[T] {
+	return &PriorityQueue[T]{Queue[T]{capacity: max(0, capacity), less: less}}
+}
+
+// LIFOQueue is a [Queue] that pops the most recently added item first.
+// A LIFOQueue must be constructed using [NewLIFOQueue].
+type LIFOQueue[T any] struct {
+	Queue[T]
 }
 
-// Get pops the first item from the Queue.
+// NewLIFOQueue constructs a new [LIFOQueue].
+// If capacity is positive, the queue holds at most capacity items; see [NewBoundedQueue].
+func NewLIFOQueue[T any](capacity int) *LIFOQueue[T] {
+	return &LIFOQueue[T]{Queue[T]{capacity: max(0, capacity), lifo: true}}
+}
+
+// push adds an item to the queue's data according to the queue's ordering.
+func (q *Queue[T]) push(item T) {
+	if q.less != nil {
+		heap.Push(queueHeap[T]{q}, item)
+	} else {
+		q.data = append(q.data, item)
+	}
+}
+
+// pop removes the next item from the queue's data according to the queue's ordering.
+func (q *Queue[T]) pop() (item T) {
+	switch {
+	case q.less != nil:
+		return heap.Pop(queueHeap[T]{q}).(T)
+	case q.lifo:
+		item, q.data = q.data[len(q.data)-1], q.data[:len(q.data)-1]
+	default:
+		item, q.data = q.data[0], q.data[1:]
+	}
+	return item
+}
+
+// queueHeap implements [heap.Interface] for the data of a priority queue.
+type queueHeap[T any] struct {
+	q *Queue[T]
+}
+
+func (h queueHeap[T]) Len() int {
+	return len(h.q.data)
+}
+
+func (h queueHeap[T]) Less(i, j int) bool {
+	return h.q.less(h.q.data[i], h.q.data[j])
+}
+
+func (h queueHeap[T]) Swap(i, j int) {
+	h.q.data[i], h.q.data[j] = h.q.data[j], h.q.data[i]
+}
+
+func (h queueHeap[T]) Push(x any) {
+	h.q.data = append(h.q.data, x.(T))
+}
+
+func (h queueHeap[T]) Pop() any {
+	item := h.q.data[len(h.q.data)-1]
+	h.q.data = h.q.data[:len(h.q.data)-1]
+	return item
+}
+
+// Get pops the next item from the Queue.
 // The returned [Future] will resolve to the popped item
 // once data is available.
+// If the Queue has been closed and no items remain, the Future will fail with [ErrQueueClosed].
 func (q *Queue[T]) Get() *Future[T] {
 	fut := NewFuture[T]()
 	if len(q.data) > 0 {
-		item := q.data[0]
-		q.data = q.data[1:]
+		item := q.pop()
+		q.admitPutters()
 		fut.SetResult(item, nil)
 		return fut
+	} else if q.closed {
+		var zero T
+		fut.SetResult(zero, ErrQueueClosed)
+		return fut
 	}
 
 	q.futs = append(q.futs, fut)
 	return fut
 }
 
-// Push adds an item to the Queue.
-func (q *Queue[T]) Push(item T) {
-	q.data = append(q.data, item)
+// GetBatch pops up to maxItems items from the Queue, waiting until either maxItems items have been retrieved
+// or maxWait has elapsed, whichever comes first. Items are retrieved in the Queue's usual order,
+// so a [PriorityQueue] yields its highest-priority items first.
+// If maxWait elapses before any items become available, an empty batch is returned.
+//
+// If the Queue has been closed, GetBatch returns the remaining items, or fails with [ErrQueueClosed] if there are none.
+// If the wait is interrupted, the items retrieved so far are returned along with the error.
+// A maxItems of less than 1 is treated as 1.
+func (q *Queue[T]) GetBatch(ctx context.Context, maxItems int, maxWait time.Duration) ([]T, error) {
+	maxItems = max(1, maxItems)
+	deadline := time.Now().Add(maxWait)
+	batch := make([]T, 0, maxItems)
+	for len(batch) < maxItems {
+		for len(batch) < maxItems && len(q.data) > 0 {
+			batch = append(batch, q.pop())
+			q.admitPutters()
+		}
+		if len(batch) == maxItems {
+			break
+		} else if q.closed {
+			if len(batch) == 0 {
+				return nil, ErrQueueClosed
+			}
+			break
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		item, err := q.Get().AwaitTimeout(ctx, remaining)
+		if errors.Is(err, ErrTimeout) {
+			break
+		} else if errors.Is(err, ErrQueueClosed) {
+			continue
+		} else if err != nil {
+			return batch, err
+		}
+		batch = append(batch, item)
+	}
+	return batch, nil
+}
+
+// Put adds an item to the Queue once there is space for it.
+// The returned [Future] completes once the item has been added,
+// or fails with [ErrQueueClosed] if the Queue is closed first.
+// For unbounded queues, Put is equivalent to [Queue.Push].
+func (q *Queue[T]) Put(item T) *Future[any] {
+	fut := NewFuture[any]()
+	if err := q.PutNoWait(item); errors.Is(err, ErrQueueFull) {
+		q.putters = append(q.putters, queuePutter[T]{item: item, fut: fut})
+	} else {
+		fut.SetResult(nil, err)
+	}
+	return fut
+}
+
+// PutNoWait adds an item to the Queue if there is space for it,
+// and otherwise fails with [ErrQueueFull].
+// Fails with [ErrQueueClosed] if the Queue has been closed.
+func (q *Queue[T]) PutNoWait(item T) error {
+	if q.closed {
+		return ErrQueueClosed
+	}
+	// don't let anyone cut in line
+	if q.capacity > 0 && (len(q.data) >= q.capacity || len(q.putters) > 0) {
+		switch q.overflow {
+		case OverflowBlock:
+			return ErrQueueFull
+		case OverflowDropNewest:
+			q.dropped++
+			return nil
+		case OverflowDropOldest, OverflowConflate:
+			q.pop()
+			q.dropped++
+			q.TaskDone()
+		}
+	}
+	return q.Push(item)
+}
+
+// admitPutters moves items waiting in [Queue.Put] into the Queue as space frees up.
+func (q *Queue[T]) admitPutters() {
+	for len(q.putters) > 0 && len(q.data) < q.capacity {
+		putter := q.putters[0]
+		q.putters = q.putters[1:]
+		// skip if cancelled
+		if putter.fut.HasResult() {
+			continue
+		}
+		q.push(putter.item)
+		q.unfinished++
+		putter.fut.SetResult(nil, nil)
+	}
+}
+
+// Push adds an item to the Queue, without regard for its capacity.
+// Pushing to a closed Queue fails with [ErrQueueClosed].
+func (q *Queue[T]) Push(item T) error {
+	if q.closed {
+		return ErrQueueClosed
+	}
+	q.push(item)
+	q.unfinished++
 	for len(q.futs) > 0 && len(q.data) > 0 {
 		// skip if cancelled
 		if q.futs[0].HasResult() {
@@ -38,38 +284,475 @@ func (q *Queue[T]) Push(item T) {
 			continue
 		}
 
-		fut, item := q.futs[0], q.data[0]
-		q.futs, q.data = q.futs[1:], q.data[1:]
-		fut.SetResult(item, nil)
+		fut := q.futs[0]
+		q.futs = q.futs[1:]
+		fut.SetResult(q.pop(), nil)
+	}
+	return nil
+}
+
+// TaskDone marks an item retrieved from the Queue as processed.
+// Once every item added to the Queue has been marked as processed, coroutines waiting in [Queue.Join] are woken up.
+// TaskDone panics if called more times than there have been items added to the Queue.
+func (q *Queue[T]) TaskDone() {
+	if q.unfinished == 0 {
+		panic("TaskDone called too many times")
+	}
+	q.unfinished--
+	if q.unfinished == 0 && q.joined != nil {
+		q.joined.SetResult(nil, nil)
+		q.joined = nil
+	}
+}
+
+// Join suspends the calling coroutine until every item added to the Queue
+// has been retrieved and marked as processed using [Queue.TaskDone].
+func (q *Queue[T]) Join(ctx context.Context) error {
+	if q.unfinished == 0 {
+		return nil
+	}
+	if q.joined == nil {
+		q.joined = NewFuture[any]()
 	}
+	// shield the future, as it is shared by all joiners
+	_, err := q.joined.Shield().Await(ctx)
+	return err
+}
+
+// Close closes the Queue. Items that have already been pushed can still be retrieved,
+// after which [Queue.Get] fails with [ErrQueueClosed].
+// Items still waiting to be added using [Queue.Put] are discarded.
+func (q *Queue[T]) Close() {
+	q.closed = true
+	var zero T
+	for _, fut := range q.futs {
+		fut.SetResult(zero, ErrQueueClosed)
+	}
+	q.futs = nil
+	for _, putter := range q.putters {
+		putter.fut.SetResult(nil, ErrQueueClosed)
+	}
+	q.putters = nil
+}
+
+// Iter returns an [AsyncIterable] that pops items from the Queue as they become available.
+// The iteration ends once the Queue has been closed and drained.
+func (q *Queue[T]) Iter(ctx context.Context) AsyncIterable[T] {
+	return AsyncIter(func(yield func(T) error) error {
+		for {
+			item, err := q.Get().Await(ctx)
+			if errors.Is(err, ErrQueueClosed) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := yield(item); err != nil {
+				return err
+			}
+		}
+	})
 }
 
 // Mutex provides a simple asynchronous locking mechanism for coroutines.
+// Waiting coroutines are granted the lock in the order they requested it.
 // Mutex is not threadsafe.
 type Mutex struct {
-	unlockFut *Future[any]
+	locked  bool
+	waiters []mutexWaiter
+	name    string
+
+	// loop and owner are tracked for deadlock detection
+	loop  *EventLoop
+	owner tasker
+}
+
+// mutexWaiter is a coroutine waiting to lock a [Mutex].
+type mutexWaiter struct {
+	fut  *Future[any]
+	task tasker
+}
+
+// WithName assigns a name to this Mutex, used to identify it in the metrics
+// recorded by [EventLoop.SetLockMetrics]. Returns the Mutex itself.
+func (m *Mutex) WithName(name string) *Mutex {
+	m.name = name
+	return m
 }
 
 // Lock locks the Mutex. If the Mutex is already locked,
 // the calling coroutine will be suspended until unlocked.
 func (m *Mutex) Lock(ctx context.Context) error {
-	for {
-		if m.unlockFut == nil || m.unlockFut.HasResult() {
-			m.unlockFut = NewFuture[any]()
-			return nil
+	return m.lock(ctx, func(fut *Future[any]) error {
+		_, err := fut.Await(ctx)
+		return err
+	})
+}
+
+// TryLock locks the Mutex if it is not already locked, and reports whether it was locked.
+func (m *Mutex) TryLock() bool {
+	// don't let anyone cut in line
+	if m.locked || len(m.waiters) > 0 {
+		return false
+	}
+	m.locked = true
+	m.owner = nil
+	return true
+}
+
+// LockTimeout is like [Mutex.Lock], but gives up with [ErrLockTimeout]
+// if the Mutex could not be locked within the given duration.
+func (m *Mutex) LockTimeout(ctx context.Context, timeout time.Duration) error {
+	err := m.lock(ctx, func(fut *Future[any]) error {
+		_, err := fut.AwaitTimeout(ctx, timeout)
+		return err
+	})
+	if errors.Is(err, ErrTimeout) {
+		return ErrLockTimeout
+	}
+	return err
+}
+
+func (m *Mutex) lock(ctx context.Context, await func(fut *Future[any]) error) error {
+	var task tasker
+	if loop, ok := RunningLoopMaybe(ctx); ok {
+		m.loop = loop
+		task = loop.currentTaskMaybe()
+	}
+
+	if m.TryLock() {
+		m.owner = task
+		if m.loop != nil {
+			m.loop.recordLockAcquired(m)
 		}
+		return nil
+	}
 
-		if _, err := m.unlockFut.Await(ctx); err != nil {
-			return err
+	fut := NewFuture[any]()
+	m.waiters = append(m.waiters, mutexWaiter{fut: fut, task: task})
+	if m.loop != nil {
+		m.loop.setOwner(fut, m.owner)
+		defer m.loop.recordLockWait(m, time.Now())
+	}
+
+	if err := await(fut); err != nil {
+		if fut.Err() == nil {
+			// we were handed the lock just as we were cancelled, so pass it on
+			m.Unlock()
+		} else {
+			m.waiters = slices.DeleteFunc(m.waiters, func(w mutexWaiter) bool {
+				return w.fut == fut
+			})
 		}
+		return err
 	}
+	if m.loop != nil {
+		m.loop.recordLockAcquired(m)
+	}
+	return nil
+}
+
+func (m *Mutex) lockHolder() tasker {
+	return m.owner
 }
 
-// Unlock unlocks the Mutex.
+func (m *Mutex) lockName() string {
+	return describeLock(m, m.name)
+}
+
+// Unlock unlocks the Mutex, handing it over to the coroutine that has been waiting the longest.
 func (m *Mutex) Unlock() {
-	if m.unlockFut != nil {
-		m.unlockFut.SetResult(nil, nil)
+	if !m.locked {
+		return
+	}
+
+	for len(m.waiters) > 0 {
+		next := m.waiters[0]
+		m.waiters = m.waiters[1:]
+		if next.fut.HasResult() {
+			continue
+		}
+
+		m.owner = next.task
+		if m.loop != nil {
+			for _, w := range m.waiters {
+				m.loop.setOwner(w.fut, m.owner)
+			}
+		}
+		next.fut.SetResult(nil, nil)
+		return
+	}
+
+	m.locked = false
+	m.owner = nil
+}
+
+// ThreadsafeQueue is a [Queue] that items can be pushed to from any goroutine,
+// bridging producer goroutines and consumer coroutines running on an [EventLoop].
+// Items are handed over to the loop using [EventLoop.RunCallbackThreadsafe],
+// so they only become available to consumers once the loop has processed them.
+type ThreadsafeQueue[T any] struct {
+	loop  *EventLoop
+	queue Queue[T]
+}
+
+// NewThreadsafeQueue constructs a new [ThreadsafeQueue] whose consumers run on the given loop.
+func NewThreadsafeQueue[T any](loop *EventLoop) *ThreadsafeQueue[T] {
+	return &ThreadsafeQueue[T]{loop: loop}
+}
+
+// Push adds an item to the queue. Push is threadsafe.
+func (q *ThreadsafeQueue[T]) Push(item T) {
+	q.loop.RunCallbackThreadsafe(context.Background(), func() {
+		_ = q.queue.Push(item)
+	})
+}
+
+// Close closes the queue after all items pushed so far have been added. Close is threadsafe.
+// See [Queue.Close].
+func (q *ThreadsafeQueue[T]) Close() {
+	q.loop.RunCallbackThreadsafe(context.Background(), q.queue.Close)
+}
+
+// Get pops the first item from the queue. Get must be called from the event loop.
+// See [Queue.Get].
+func (q *ThreadsafeQueue[T]) Get() *Future[T] {
+	return q.queue.Get()
+}
+
+// Iter returns an [AsyncIterable] that pops items from the queue as they become available.
+// Iter must be called from the event loop. See [Queue.Iter].
+func (q *ThreadsafeQueue[T]) Iter(ctx context.Context) AsyncIterable[T] {
+	return q.queue.Iter(ctx)
+}
+
+// ReentrantMutex is a [Mutex] that may be locked multiple times by the task holding it,
+// e.g. by recursive coroutine calls passing through the same locked section.
+// The lock is released once Unlock has been called as many times as Lock.
+//
+// Coroutines not running as part of a task can't reenter the lock.
+// ReentrantMutex is not threadsafe.
+type ReentrantMutex struct {
+	mu    Mutex
+	owner tasker
+	holds int
+}
+
+// Lock locks the ReentrantMutex. If the ReentrantMutex is already locked by another task,
+// the calling coroutine will be suspended until unlocked.
+func (m *ReentrantMutex) Lock(ctx context.Context) error {
+	var task tasker
+	if loop, ok := RunningLoopMaybe(ctx); ok {
+		task = loop.currentTaskMaybe()
+	}
+
+	if task != nil && m.holds > 0 && m.owner == task {
+		m.holds++
+		return nil
+	}
+
+	if err := m.mu.Lock(ctx); err != nil {
+		return err
+	}
+	m.owner = task
+	m.holds = 1
+	return nil
+}
+
+// Holds returns the number of times the ReentrantMutex has been locked by its current owner,
+// or 0 if unlocked.
+func (m *ReentrantMutex) Holds() int {
+	return m.holds
+}
+
+// Unlock releases one hold on the ReentrantMutex, unlocking it once no holds remain.
+// Unlock panics if the ReentrantMutex is not locked.
+func (m *ReentrantMutex) Unlock() {
+	if m.holds == 0 {
+		panic("unlock of unlocked ReentrantMutex")
+	}
+
+	m.holds--
+	if m.holds == 0 {
+		m.owner = nil
+		m.mu.Unlock()
+	}
+}
+
+// Semaphore bounds the number of coroutines that may hold it at the same time.
+// Semaphore is not threadsafe.
+type Semaphore struct {
+	value int
+	// bound is the maximum value of a bounded semaphore, or 0 if unbounded
+	bound   int
+	waiters []*Future[any]
+	name    string
+}
+
+// NewSemaphore constructs a new [Semaphore] that can be acquired n times before blocking.
+func NewSemaphore(n int) *Semaphore {
+	return &Semaphore{value: n}
+}
+
+// NewBoundedSemaphore constructs a new [Semaphore] like [NewSemaphore],
+// but which panics if released more times than it has been acquired, to help catch bugs.
+func NewBoundedSemaphore(n int) *Semaphore {
+	return &Semaphore{value: n, bound: n}
+}
+
+// WithName assigns a name to this Semaphore, used to identify it in the metrics
+// recorded by [EventLoop.SetLockMetrics]. Returns the Semaphore itself.
+func (s *Semaphore) WithName(name string) *Semaphore {
+	s.name = name
+	return s
+}
+
+// Acquire acquires the Semaphore. If the Semaphore is not available,
+// the calling coroutine will be suspended until it is released by another coroutine.
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	loop, _ := RunningLoopMaybe(ctx)
+	if s.TryAcquire() {
+		if loop != nil {
+			loop.recordLockAcquired(s)
+		}
+		return nil
+	}
+
+	fut := NewFuture[any]()
+	s.waiters = append(s.waiters, fut)
+	if loop != nil {
+		defer loop.recordLockWait(s, time.Now())
+	}
+	if _, err := fut.Await(ctx); err != nil {
+		if fut.Err() == nil {
+			// we were handed the semaphore just as we were cancelled, so pass it on
+			s.Release()
+		} else {
+			s.waiters = slices.DeleteFunc(s.waiters, func(f *Future[any]) bool {
+				return f == fut
+			})
+		}
+		return err
+	}
+	if loop != nil {
+		loop.recordLockAcquired(s)
+	}
+	return nil
+}
+
+func (s *Semaphore) lockHolder() tasker {
+	return nil
+}
+
+func (s *Semaphore) lockName() string {
+	return describeLock(s, s.name)
+}
+
+// TryAcquire acquires the Semaphore if it is available without waiting,
+// and reports whether it was acquired.
+func (s *Semaphore) TryAcquire() bool {
+	// don't let anyone cut in line
+	if s.value > 0 && len(s.waiters) == 0 {
+		s.value--
+		return true
+	}
+	return false
+}
+
+// Release releases the Semaphore, waking up the next waiting coroutine if any.
+func (s *Semaphore) Release() {
+	// hand the semaphore directly to the next waiter in line
+	for len(s.waiters) > 0 {
+		fut := s.waiters[0]
+		s.waiters = s.waiters[1:]
+		if !fut.HasResult() {
+			fut.SetResult(nil, nil)
+			return
+		}
 	}
+
+	if s.bound > 0 && s.value >= s.bound {
+		panic("bounded semaphore released too many times")
+	}
+	s.value++
+}
+
+// WithSemaphore runs the given coroutine while holding the semaphore.
+func WithSemaphore[T any](ctx context.Context, s *Semaphore, coro Coroutine2[T]) (T, error) {
+	if err := s.Acquire(ctx); err != nil {
+		var zero T
+		return zero, err
+	}
+	defer s.Release()
+	return coro(ctx)
+}
+
+// Barrier lets a fixed number of coroutines wait for each other to reach the same point,
+// e.g. to finish a phase of a pipeline before any of them starts on the next.
+// Once all parties have arrived, the Barrier resets itself and can be reused.
+// Barrier is not threadsafe.
+type Barrier struct {
+	parties  int
+	arrived  int
+	released *Future[any]
+	broken   bool
+}
+
+// NewBarrier constructs a new [Barrier] for the given number of parties.
+func NewBarrier(parties int) *Barrier {
+	return &Barrier{parties: max(1, parties)}
+}
+
+// Wait suspends the calling coroutine until all parties have called Wait.
+//
+// If the Barrier is aborted while waiting, or any waiting coroutine is cancelled,
+// the Barrier breaks, and all current and future calls to Wait fail with [ErrBarrierBroken]
+// until the Barrier is reset using [Barrier.Reset].
+func (b *Barrier) Wait(ctx context.Context) error {
+	if b.broken {
+		return ErrBarrierBroken
+	}
+
+	if b.released == nil {
+		b.released = NewFuture[any]()
+	}
+	released := b.released
+	if b.arrived++; b.arrived == b.parties {
+		b.arrived, b.released = 0, nil
+		released.SetResult(nil, nil)
+		return nil
+	}
+
+	// shield the future, as it is shared by all waiting parties
+	if _, err := released.Shield().Await(ctx); err != nil {
+		if !errors.Is(err, ErrBarrierBroken) {
+			// the remaining parties would never be released, so let them know
+			b.Abort()
+		}
+		return err
+	}
+	return nil
+}
+
+// Abort breaks the Barrier, failing all current and future calls to [Barrier.Wait] with [ErrBarrierBroken].
+func (b *Barrier) Abort() {
+	b.broken = true
+	b.arrived = 0
+	if b.released != nil {
+		b.released.SetResult(nil, ErrBarrierBroken)
+		b.released = nil
+	}
+}
+
+// Reset returns the Barrier to its initial state.
+// Any coroutines currently waiting will fail with [ErrBarrierBroken].
+func (b *Barrier) Reset() {
+	b.Abort()
+	b.broken = false
+}
+
+// Broken reports whether the Barrier is broken.
+func (b *Barrier) Broken() bool {
+	return b.broken
 }
 
 // WaitMode modifies the behaviour of [Wait].
@@ -90,8 +773,15 @@ func Wait(ctx context.Context, mode WaitMode, futs ...Futurer) error {
 	var lastErr error
 	waitFut := NewFuture[any]()
 
-	for _, fut := range futs {
-		fut.AddDoneCallback(func(err error) {
+	handles := make([]*CallbackHandle, len(futs))
+	// futures that are still pending when Wait returns mustn't keep accumulating callbacks
+	defer func() {
+		for i, fut := range futs {
+			fut.RemoveCallback(handles[i])
+		}
+	}()
+	for i, fut := range futs {
+		handles[i] = fut.AddDoneCallback(func(err error) {
 			completed++
 			if err != nil {
 				lastErr = err
@@ -108,6 +798,181 @@ func Wait(ctx context.Context, mode WaitMode, futs ...Futurer) error {
 	return err
 }
 
+// WaitFor awaits aw for at most the given duration, returning its result.
+// If aw doesn't complete in time, it is cancelled and WaitFor returns [ErrTimeout].
+//
+// WaitFor is equivalent to calling [Awaitable.AwaitTimeout], except that a timeout
+// is reported as ErrTimeout itself rather than as a [*CancelledError] wrapping it.
+func WaitFor[T any](ctx context.Context, timeout time.Duration, aw Awaitable[T]) (T, error) {
+	result, err := aw.AwaitTimeout(ctx, timeout)
+	var cerr *CancelledError
+	if errors.As(err, &cerr) && errors.Is(cerr.Cause, ErrTimeout) {
+		return result, ErrTimeout
+	}
+	return result, err
+}
+
+// Shield awaits aw, protecting it from cancellation of the awaiting coroutine:
+// if the awaiting task is cancelled, only the await is interrupted, while aw keeps running.
+// Cancelling aw directly still cancels it, and the cancellation is reported by Shield as usual.
+//
+// Shield is equivalent to awaiting [Awaitable.Shield].
+func Shield[T any](ctx context.Context, aw Awaitable[T]) (T, error) {
+	return aw.Shield().Await(ctx)
+}
+
+// Timeout is a timeout scope, limiting the time a coroutine run using [Timeout.Run] may take.
+// Unlike [Awaitable.AwaitTimeout], the deadline of a Timeout can be moved while the coroutine is running,
+// e.g. to extend the deadline each time progress is made.
+type Timeout struct {
+	timeout  time.Duration
+	loop     *EventLoop
+	task     *Task[any]
+	handle   *Callback
+	deadline time.Time
+	expired  bool
+	done     bool
+}
+
+// NewTimeout constructs a new [Timeout] scope that expires after the given duration once started.
+func NewTimeout(timeout time.Duration) *Timeout {
+	return &Timeout{timeout: timeout}
+}
+
+// WithTimeout runs coro in a [Timeout] scope that expires after the given duration.
+// See [Timeout.Run].
+func WithTimeout(ctx context.Context, timeout time.Duration, coro Coroutine1) error {
+	return NewTimeout(timeout).Run(ctx, coro)
+}
+
+// Run runs coro as a task, cancelling it if the timeout expires before it completes.
+// The cancellation propagates to whatever the coroutine is awaiting,
+// and is reported as [ErrTimeout] once the coroutine has returned.
+// A Timeout should only be run once.
+func (t *Timeout) Run(ctx context.Context, coro Coroutine1) error {
+	t.loop = RunningLoop(ctx)
+	t.task = SpawnTask(ctx, func(ctx context.Context) (any, error) {
+		return nil, coro(ctx)
+	})
+	t.Reschedule(t.timeout)
+	defer func() {
+		// the handle may have been replaced by a call to Reschedule while the coroutine was running
+		t.done = true
+		t.handle.Cancel()
+	}()
+
+	_, err := t.task.Await(ctx)
+	var cerr *CancelledError
+	if t.expired && errors.As(err, &cerr) && errors.Is(cerr.Cause, ErrTimeout) {
+		return ErrTimeout
+	}
+	return err
+}
+
+// Reschedule moves the deadline of the scope to the given duration from now.
+// If the scope hasn't started yet, the duration is instead used once it starts.
+// Rescheduling has no effect once the timeout has expired, or once [Timeout.Run] has returned.
+func (t *Timeout) Reschedule(timeout time.Duration) {
+	if t.expired || t.done {
+		return
+	}
+
+	t.timeout = timeout
+	if t.task == nil {
+		return
+	}
+	if t.handle != nil {
+		t.handle.Cancel()
+	}
+	t.deadline = time.Now().Add(timeout)
+	t.handle = t.loop.ScheduleCallback(timeout, func() {
+		if t.done {
+			return
+		}
+		t.expired = true
+		t.task.Cancel(ErrTimeout)
+	})
+}
+
+// Deadline returns the point in time at which the scope expires,
+// or the zero time if the scope hasn't started yet.
+func (t *Timeout) Deadline() time.Time {
+	return t.deadline
+}
+
+// Expired reports whether the timeout has expired.
+func (t *Timeout) Expired() bool {
+	return t.expired
+}
+
+// Result holds the outcome of an [Awaitable]: either a value, or the error it failed with.
+type Result[T any] struct {
+	Value T
+	Err   error
+}
+
+// WaitAllTyped waits for all of the given awaitables to complete,
+// returning their results in the same order as the awaitables were passed.
+// Like [Wait], WaitAllTyped will not cancel any awaitables.
+//
+// If the wait itself is interrupted, e.g. by the context being cancelled,
+// the awaitables that have not yet completed will have their results set to the error
+// that interrupted the wait.
+func WaitAllTyped[T any](ctx context.Context, awaitables ...Awaitable[T]) []Result[T] {
+	if len(awaitables) == 0 {
+		return nil
+	}
+
+	futs := make([]Futurer, len(awaitables))
+	for i, a := range awaitables {
+		futs[i] = a
+	}
+	// if any awaitable is still pending after this, the wait must have been interrupted
+	err := Wait(ctx, WaitAll, futs...)
+
+	results := make([]Result[T], len(awaitables))
+	for i, a := range awaitables {
+		if a.HasResult() {
+			results[i].Value, results[i].Err = a.Result()
+		} else {
+			results[i].Err = err
+		}
+	}
+	return results
+}
+
+// AsCompleted returns an [AsyncIterable] that yields the result of each of the given awaitables
+// in the order they complete, allowing results to be processed as soon as they are available.
+// Failed awaitables are yielded along with their errors rather than ending the iteration.
+func AsCompleted[T any](ctx context.Context, awaitables ...Awaitable[T]) AsyncIterable[Result[T]] {
+	return AsyncIter(func(yield func(Result[T]) error) error {
+		var completed Queue[Result[T]]
+		handles := make([]*CallbackHandle, len(awaitables))
+		// unregister from awaitables that are still pending if iteration stops early
+		defer func() {
+			for i, a := range awaitables {
+				a.RemoveCallback(handles[i])
+			}
+		}()
+		for i, a := range awaitables {
+			handles[i] = a.AddResultCallback(func(result T, err error) {
+				_ = completed.Push(Result[T]{Value: result, Err: err})
+			})
+		}
+
+		for range awaitables {
+			res, err := completed.Get().Await(ctx)
+			if err != nil {
+				return err
+			}
+			if err := yield(res); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 // GetFirstResult returns the result of the first successful coroutine.
 // Once a coroutine succeeds, all unfinished tasks will be cancelled.
 // If no coroutine succeeds, the last error is returned.
@@ -133,7 +998,9 @@ func GetFirstResult[T any](ctx context.Context, coros ...Coroutine2[T]) (T, erro
 			if err == nil {
 				waitFut.SetResult(result, nil)
 			} else if done >= len(coros) {
-				waitFut.Cancel(err)
+				// the last error is a failure of the coroutine rather than a cancellation
+				var zero T
+				waitFut.SetResult(zero, err)
 			}
 		})
 	}
@@ -141,6 +1008,74 @@ func GetFirstResult[T any](ctx context.Context, coros ...Coroutine2[T]) (T, erro
 	return waitFut.Await(ctx)
 }
 
+// Gather runs the given coroutines concurrently as tasks, returning their results in the same order.
+// If any coroutine fails, the remaining tasks are cancelled and the error is returned.
+// Use [GatherResults] to collect the error of each coroutine instead.
+func Gather[T any](ctx context.Context, coros ...Coroutine2[T]) ([]T, error) {
+	if len(coros) == 0 {
+		return nil, nil
+	}
+
+	tasks := make([]*Task[T], len(coros))
+	futs := make([]Futurer, len(coros))
+	for i, coro := range coros {
+		tasks[i] = SpawnTask(ctx, coro)
+		futs[i] = tasks[i]
+	}
+
+	if err := Wait(ctx, WaitFirstError, futs...); err != nil {
+		for _, t := range tasks {
+			t.Cancel(nil)
+		}
+		return nil, err
+	}
+
+	results := make([]T, len(tasks))
+	for i, t := range tasks {
+		results[i], _ = t.Result()
+	}
+	return results, nil
+}
+
+// SpawnLimited is like [Gather], but runs at most limit of the given coroutines at a time,
+// starting the next coroutine as soon as a running one finishes.
+// This avoids hand-rolling a [Semaphore] around Gather for large batch jobs,
+// and only spawns as many tasks as are allowed to run concurrently.
+func SpawnLimited[T any](ctx context.Context, limit int, coros ...Coroutine2[T]) ([]T, error) {
+	results := make([]T, len(coros))
+	var next int
+	workers := make([]Coroutine2[any], min(max(1, limit), len(coros)))
+	for i := range workers {
+		workers[i] = func(ctx context.Context) (any, error) {
+			for next < len(coros) {
+				i := next
+				next++
+				result, err := coros[i](ctx)
+				if err != nil {
+					return nil, err
+				}
+				results[i] = result
+			}
+			return nil, nil
+		}
+	}
+
+	if _, err := Gather(ctx, workers...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GatherResults is like [Gather], but waits for every coroutine to finish regardless of failures,
+// returning the result and error of each coroutine in the same order.
+func GatherResults[T any](ctx context.Context, coros ...Coroutine2[T]) []Result[T] {
+	tasks := make([]Awaitable[T], len(coros))
+	for i, coro := range coros {
+		tasks[i] = SpawnTask(ctx, coro)
+	}
+	return WaitAllTyped(ctx, tasks...)
+}
+
 // Sleep suspends the current coroutine for the given duration.
 func Sleep(ctx context.Context, duration time.Duration) error {
 	fut := NewFuture[any]()
@@ -154,14 +1089,96 @@ func Sleep(ctx context.Context, duration time.Duration) error {
 	return err
 }
 
+// Checkpoint yields control to the event loop for one tick, even if there is nothing to wait for,
+// allowing other tasks, callbacks and I/O to be processed before the calling coroutine resumes.
+//
+// Coroutines only yield to the loop when they await, and only notice that they have been cancelled
+// when they do, so long-running CPU-bound loops should call Checkpoint periodically
+// to keep the loop responsive and to act as a cooperative cancellation point.
+// Checkpoint returns a [*CancelledError] if the calling task or ctx has been cancelled,
+// in which case the coroutine should stop what it's doing and return the error.
+// See also [AsyncIterable.WithCheckpoints].
+func Checkpoint(ctx context.Context) error {
+	// unlike with a future, there's nothing for the loop to cancel if only ctx has been cancelled,
+	// so check it here rather than yielding and having the cancellation go unnoticed
+	if err := context.Cause(ctx); err != nil {
+		return newCancelledError(err)
+	}
+	return RunningLoop(ctx).Yield(ctx, nil)
+}
+
+// FromContext returns a [Future] that completes once the target context is done,
+// with the cause of the context's cancellation as its error.
+// The target context may be cancelled from any goroutine,
+// making it possible to race work on the loop against external cancellation signals.
+// The returned Future can be cancelled to stop watching the target context.
+func FromContext(ctx context.Context, target context.Context) *Future[any] {
+	loop := RunningLoop(ctx)
+	fut := NewFuture[any]()
+	stop := context.AfterFunc(target, func() {
+		loop.RunCallbackThreadsafe(ctx, func() {
+			fut.SetResult(nil, context.Cause(target))
+		})
+	})
+	fut.AddDoneCallback(func(error) {
+		stop()
+	})
+	return fut
+}
+
 // Go launches the given function in a goroutine and returns a [Future]
 // that will complete when the goroutine finishes.
+// The goroutine's context carries the values, deadline and cancellation of ctx;
+// see [GoWithOptions] for more control.
 func Go[T any](ctx context.Context, f func(ctx context.Context) (T, error)) *Future[T] {
+	return GoWithOptions(ctx, GoOptions{}, f)
+}
+
+// GoOptions controls the context passed to the goroutine launched by [GoWithOptions].
+type GoOptions struct {
+	// Detach prevents the goroutine's context from being cancelled along with the calling task's context.
+	// The context values of the calling task are still carried over.
+	Detach bool
+	// KeepDeadline keeps the deadline of the calling task's context when detaching.
+	KeepDeadline bool
+	// Timeout, if positive, additionally limits the goroutine's context to the given duration.
+	Timeout time.Duration
+}
+
+// GoWithOptions is like [Go], but allows controlling what the goroutine inherits from ctx.
+// The context values of ctx, such as request-scoped data, are always carried over into the goroutine,
+// while the [EventLoop] is not, as the goroutine is not running on the loop.
+//
+// Tasks have no local storage of their own beyond the values of their context,
+// so there is nothing else to carry over. Nor are values carried back once the goroutine completes:
+// the caller's context can't be modified, and the result is delivered to the caller rather than to a callback
+// running in a context of its own, so f should return any data the caller needs as part of its result.
+func GoWithOptions[T any](ctx context.Context, opts GoOptions, f func(ctx context.Context) (T, error)) *Future[T] {
 	loop := RunningLoop(ctx)
 	fut := NewFuture[T]()
 
 	goroCtx := context.WithValue(ctx, runningLoop{}, nil)
+	var cancels []context.CancelFunc
+	if opts.Detach {
+		goroCtx = context.WithoutCancel(goroCtx)
+		if deadline, ok := ctx.Deadline(); ok && opts.KeepDeadline {
+			var cancel context.CancelFunc
+			goroCtx, cancel = context.WithDeadline(goroCtx, deadline)
+			cancels = append(cancels, cancel)
+		}
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		goroCtx, cancel = context.WithTimeout(goroCtx, opts.Timeout)
+		cancels = append(cancels, cancel)
+	}
+
 	go func() {
+		defer func() {
+			for _, cancel := range cancels {
+				cancel()
+			}
+		}()
 		result, err := f(goroCtx)
 		loop.RunCallbackThreadsafe(goroCtx, func() {
 			fut.SetResult(result, err)
@@ -169,3 +1186,179 @@ func Go[T any](ctx context.Context, f func(ctx context.Context) (T, error)) *Fut
 	}()
 	return fut
 }
+
+// OffloadIfSlow runs fn inline on the event loop, but moves it off the loop once it has run for longer than threshold,
+// so that the rest of fn runs in the background while the calling coroutine awaits its result.
+// This makes it safe to call functions of unpredictable cost, e.g. parsing input of any size,
+// without paying the cost of a goroutine hand-off for the common cheap case.
+//
+// fn must call checkpoint regularly, e.g. once per iteration of its main loop;
+// the time taken is measured at each checkpoint, and fn is moved off the loop at the first checkpoint past the threshold.
+// fn may be moved to a separate goroutine at any checkpoint, so like with [Go], it must not use the loop.
+// If the calling coroutine is cancelled after fn has been moved off the loop, fn keeps running until it returns,
+// and should watch its context to return early.
+func OffloadIfSlow[T any](ctx context.Context, threshold time.Duration, fn func(ctx context.Context, checkpoint func()) (T, error)) (T, error) {
+	var result T
+	var err error
+	var offloaded bool
+	start := time.Now()
+	goroCtx := context.WithValue(ctx, runningLoop{}, nil)
+
+	// run fn as a coroutine so that it can be suspended at a checkpoint and resumed from another goroutine
+	next, stop := iter.Pull(func(yield func(struct{}) bool) {
+		result, err = fn(goroCtx, func() {
+			if !offloaded && time.Since(start) >= threshold {
+				yield(struct{}{})
+			}
+		})
+	})
+	if _, overBudget := next(); !overBudget {
+		return result, err
+	}
+
+	// too slow; resume fn in the background, where it runs to completion
+	offloaded = true
+	return Go(ctx, func(context.Context) (T, error) {
+		defer stop()
+		next()
+		return result, err
+	}).Await(ctx)
+}
+
+// Once runs a coroutine exactly once, with any concurrent or later callers sharing its result.
+// The zero value is ready to use. Once is not threadsafe.
+type Once struct {
+	done *Future[any]
+}
+
+// Do runs coro if Do has not been called before. Otherwise Do waits for the first call to finish,
+// returning the error it returned. If coro panics, later callers get a [*PanicError].
+//
+// The coroutine runs as part of the first caller, and so is subject to its context.
+// Cancelling any later caller does not affect the coroutine.
+// If the first caller is cancelled before the coroutine finishes, the coroutine is run again by the next caller,
+// including callers that were already waiting.
+func (o *Once) Do(ctx context.Context, coro Coroutine1) error {
+	_, err := awaitOnce(ctx, &o.done, func(ctx context.Context) (any, error) {
+		return nil, coro(ctx)
+	})
+	return err
+}
+
+// Lazy is an asynchronously computed value that is computed at most once, on first use.
+// Lazy is not threadsafe.
+type Lazy[T any] struct {
+	init   Coroutine2[T]
+	result *Future[T]
+}
+
+// NewLazy constructs a new [Lazy] whose value is computed by init.
+func NewLazy[T any](init Coroutine2[T]) *Lazy[T] {
+	return &Lazy[T]{init: init}
+}
+
+// Get returns the value of the Lazy, computing it if this is the first call.
+// As with [Once.Do], concurrent callers wait for the first caller to finish computing the value,
+// any error is returned to every caller, and the value is computed again if the first caller is cancelled.
+func (l *Lazy[T]) Get(ctx context.Context) (T, error) {
+	return awaitOnce(ctx, &l.result, l.init)
+}
+
+// awaitOnce waits for the result of a [Once] or [Lazy] if it is being or has been computed,
+// or else computes it by running init. Waiting callers take over if the caller running init is cancelled.
+func awaitOnce[T any](ctx context.Context, fut **Future[T], init Coroutine2[T]) (T, error) {
+	for *fut != nil {
+		done := *fut
+		result, err := done.Shield().Await(ctx)
+		if *fut == done || !done.HasResult() {
+			// either we got the result, or we were cancelled ourselves
+			return result, err
+		}
+	}
+	return runOnce(ctx, fut, init)
+}
+
+// runOnce runs init, completing a new future stored in *fut with its result.
+// If init is cancelled, e.g. because the caller was, *fut is reset so that the next caller runs init again.
+func runOnce[T any](ctx context.Context, fut **Future[T], init Coroutine2[T]) (result T, err error) {
+	done := NewFuture[T]()
+	*fut = done
+	defer func() {
+		r := recover()
+		if r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+		var cerr *CancelledError
+		if errors.As(err, &cerr) || (err != nil && context.Cause(ctx) != nil) {
+			*fut = nil
+		}
+		// complete the future even if init panicked, so that waiting callers don't hang
+		done.SetResult(result, err)
+		if r != nil {
+			panic(r)
+		}
+	}()
+	return init(ctx)
+}
+
+// WaitGroup waits for a collection of operations to finish, like [sync.WaitGroup],
+// but lets coroutines await the counter reaching zero without blocking the event loop.
+//
+// Add and Done are threadsafe, and may be called both from coroutines and from goroutines,
+// e.g. ones launched using [Go]. Wait must be called from a coroutine.
+type WaitGroup struct {
+	mu    sync.Mutex
+	count int
+	// zero is set while coroutines are waiting, and completes once the counter reaches zero
+	zero *Future[any]
+	loop *EventLoop
+}
+
+// Add adds delta, which may be negative, to the WaitGroup counter.
+// If the counter reaches zero, all coroutines waiting on the WaitGroup are woken up.
+// Add panics if the counter goes negative.
+func (wg *WaitGroup) Add(delta int) {
+	wg.mu.Lock()
+	wg.count += delta
+	if wg.count < 0 {
+		wg.mu.Unlock()
+		panic("negative WaitGroup counter")
+	}
+	if wg.count > 0 || wg.zero == nil {
+		wg.mu.Unlock()
+		return
+	}
+
+	zero, loop := wg.zero, wg.loop
+	wg.zero, wg.loop = nil, nil
+	wg.mu.Unlock()
+
+	// we may be called from a goroutine, so complete the future on the loop's thread
+	loop.RunCallbackThreadsafe(context.Background(), func() {
+		zero.SetResult(nil, nil)
+	})
+}
+
+// Done decrements the WaitGroup counter by one.
+func (wg *WaitGroup) Done() {
+	wg.Add(-1)
+}
+
+// Wait suspends the calling coroutine until the WaitGroup counter is zero.
+func (wg *WaitGroup) Wait(ctx context.Context) error {
+	wg.mu.Lock()
+	if wg.count == 0 {
+		wg.mu.Unlock()
+		return nil
+	}
+	if wg.zero == nil {
+		wg.zero = NewFuture[any]()
+		wg.loop = RunningLoop(ctx)
+	}
+	zero := wg.zero
+	wg.mu.Unlock()
+
+	// shield the future, as it is shared by all waiters
+	_, err := zero.Shield().Await(ctx)
+	return err
+}